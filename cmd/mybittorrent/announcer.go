@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// used when a tracker gives no interval
+	defaultAnnounceInterval = 30 * time.Minute
+	// wait before trying again after every tracker failed
+	announceRetryInterval = time.Minute
+	// how long shutdown waits for the completed and stopped announces
+	stoppedAnnounceTimeout = 10 * time.Second
+)
+
+// TransferStats counts the bytes moved for a torrent. It is safe for
+// concurrent use, so the download can update it while it is announced.
+type TransferStats struct {
+	uploaded   atomic.Int64
+	downloaded atomic.Int64
+	left       atomic.Int64
+}
+
+func NewTransferStats(left int) *TransferStats {
+	stats := &TransferStats{}
+	stats.left.Store(int64(left))
+	return stats
+}
+
+// AddUploaded counts n bytes sent to peers.
+func (s *TransferStats) AddUploaded(n int) {
+	s.uploaded.Add(int64(n))
+}
+
+// AddDownloaded counts n bytes received from peers, whether or not they end
+// up in a verified piece.
+func (s *TransferStats) AddDownloaded(n int) {
+	s.downloaded.Add(int64(n))
+}
+
+// AddVerified counts n bytes of verified pieces, which are no longer left to
+// download.
+func (s *TransferStats) AddVerified(n int) {
+	s.left.Add(-int64(n))
+}
+
+func (s *TransferStats) Uploaded() int   { return int(s.uploaded.Load()) }
+func (s *TransferStats) Downloaded() int { return int(s.downloaded.Load()) }
+
+// Left returns the bytes still to download. Verified pieces may include
+// padding between files, so it stops at zero.
+func (s *TransferStats) Left() int {
+	left := int(s.left.Load())
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// Announcer keeps the trackers of a torrent up to date for as long as it is
+// downloaded: it announces started, then again every interval with the
+// current transfer stats, completed once the download finishes and stopped
+// when it is shut down. Peers the trackers return that were not seen before
+// are sent on Peers.
+type Announcer struct {
+	// used when a tracker gives no interval, and after every tracker failed
+	DefaultInterval time.Duration
+	RetryInterval   time.Duration
+	StopTimeout     time.Duration
+
+	trackers *TrackerTiers
+	infoHash []byte
+	stats    *TransferStats
+	// swapped out in tests
+	announce func(url string, params *AnnounceParams) (*TrackerInfo, error)
+
+	peers chan []netip.AddrPort
+	// Run may give up on an announce at shutdown and close peers while the
+	// announce is still in flight
+	peersMu     sync.Mutex
+	peersClosed bool
+	seenPeers   map[netip.AddrPort]bool
+	completed   chan struct{}
+	once        sync.Once
+
+	// tracker ids by tracker URL
+	trackerIDs   map[string]string
+	interval     time.Duration
+	minInterval  time.Duration
+	lastAnnounce time.Time
+}
+
+func NewAnnouncer(trackers *TrackerTiers, infoHash []byte, stats *TransferStats) *Announcer {
+	return &Announcer{
+		DefaultInterval: defaultAnnounceInterval,
+		RetryInterval:   announceRetryInterval,
+		StopTimeout:     stoppedAnnounceTimeout,
+		trackers:        trackers,
+		infoHash:        infoHash,
+		stats:           stats,
+		announce:        announce,
+		peers:           make(chan []netip.AddrPort, 16),
+		seenPeers:       map[netip.AddrPort]bool{},
+		completed:       make(chan struct{}),
+		trackerIDs:      map[string]string{},
+	}
+}
+
+// Peers returns the channel new peers are sent on. It is closed when Run
+// returns.
+func (a *Announcer) Peers() <-chan []netip.AddrPort {
+	return a.peers
+}
+
+// Completed tells the trackers the download has finished. It may be called
+// more than once.
+func (a *Announcer) Completed() {
+	a.once.Do(func() { close(a.completed) })
+}
+
+// Run announces until ctx is done, then announces stopped and returns.
+func (a *Announcer) Run(ctx context.Context) {
+	defer func() {
+		a.peersMu.Lock()
+		defer a.peersMu.Unlock()
+		a.peersClosed = true
+		close(a.peers)
+	}()
+
+	wait := a.announceEvent(ctx, EventStarted)
+	completed := a.completed
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			a.stop(ctx, func() {
+				// a download that finished right before shutdown still counts
+				select {
+				case <-completed:
+					a.announceEvent(ctx, EventCompleted)
+				default:
+				}
+			})
+			return
+		case <-completed:
+			timer.Stop()
+			// no sooner than the tracker allows, unless shutting down
+			if sinceLast := time.Since(a.lastAnnounce); sinceLast < a.minInterval {
+				sleepContext(ctx, a.minInterval-sinceLast)
+			}
+			completed = nil
+			result := make(chan time.Duration, 1)
+			go func() {
+				result <- a.announceEvent(ctx, EventCompleted)
+			}()
+			select {
+			case wait = <-result:
+			case <-ctx.Done():
+				a.stop(ctx, func() { <-result })
+				return
+			}
+		case <-timer.C:
+			wait = a.announceEvent(ctx, EventNone)
+		}
+	}
+}
+
+// announceEvent announces to the trackers and returns how long to wait
+// before the next regular announce.
+func (a *Announcer) announceEvent(ctx context.Context, event string) time.Duration {
+	trackerInfo, err := a.trackers.Announce(func(url string) (*TrackerInfo, error) {
+		params := &AnnounceParams{
+			InfoHash:   a.infoHash,
+			Uploaded:   a.stats.Uploaded(),
+			Downloaded: a.stats.Downloaded(),
+			Left:       a.stats.Left(),
+			Event:      event,
+			TrackerID:  a.trackerIDs[url],
+		}
+		trackerInfo, err := a.announce(url, params)
+		if err == nil && trackerInfo.TrackerID != "" {
+			a.trackerIDs[url] = trackerInfo.TrackerID
+		}
+		return trackerInfo, err
+	})
+	a.lastAnnounce = time.Now()
+	if err != nil {
+		log.Debug().Msgf("announce %s: %s", event, err)
+		return a.RetryInterval
+	}
+	for _, warning := range trackerInfo.Warnings {
+		log.Warn().Msgf("tracker warning: %s", warning)
+	}
+
+	a.interval = time.Duration(trackerInfo.Interval) * time.Second
+	a.minInterval = time.Duration(trackerInfo.MinInterval) * time.Second
+	if a.interval <= 0 {
+		a.interval = a.DefaultInterval
+	}
+	if a.interval < a.minInterval {
+		a.interval = a.minInterval
+	}
+
+	newPeers := []netip.AddrPort{}
+	for _, peer := range trackerInfo.Peers {
+		if !a.seenPeers[peer] {
+			a.seenPeers[peer] = true
+			newPeers = append(newPeers, peer)
+		}
+	}
+	if len(newPeers) > 0 && event != EventStopped {
+		a.sendPeers(ctx, newPeers)
+	}
+	return a.interval
+}
+
+func (a *Announcer) sendPeers(ctx context.Context, peers []netip.AddrPort) {
+	a.peersMu.Lock()
+	defer a.peersMu.Unlock()
+	if a.peersClosed {
+		return
+	}
+	select {
+	case a.peers <- peers:
+	case <-ctx.Done():
+	}
+}
+
+// stop finishes the announce in flight with before, then announces stopped,
+// giving up on both after StopTimeout so that a dead tracker cannot hold up
+// shutdown.
+func (a *Announcer) stop(ctx context.Context, before func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		before()
+		a.announceEvent(ctx, EventStopped)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(a.StopTimeout):
+		log.Debug().Msg("announce stopped: timed out")
+	}
+}
+
+// sleepContext waits for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
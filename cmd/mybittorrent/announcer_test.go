@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAnnounceTracker records every announce and hands out the peer batches
+// in turn, repeating the last one once they run out.
+type fakeAnnounceTracker struct {
+	mu       sync.Mutex
+	batches  [][]string
+	received []AnnounceParams
+}
+
+func (f *fakeAnnounceTracker) announce(url string, params *AnnounceParams) (*TrackerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.received = append(f.received, *params)
+
+	batch := f.batches[len(f.batches)-1]
+	if len(f.received) <= len(f.batches) {
+		batch = f.batches[len(f.received)-1]
+	}
+	return &TrackerInfo{TrackerID: "id-1", Peers: parsePeerAddresses(batch)}, nil
+}
+
+func (f *fakeAnnounceTracker) events() []AnnounceParams {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]AnnounceParams{}, f.received...)
+}
+
+func (f *fakeAnnounceTracker) waitForEvent(t *testing.T, event string) AnnounceParams {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		for _, params := range f.events() {
+			if params.Event == event {
+				return params
+			}
+		}
+	}
+	t.Fatalf("no %q announce", event)
+	return AnnounceParams{}
+}
+
+func newTestAnnouncer(tracker *fakeAnnounceTracker, stats *TransferStats) *Announcer {
+	announcer := NewAnnouncer(NewTrackerTiers("http://tracker.example/announce", nil), testInfoHash, stats)
+	announcer.announce = tracker.announce
+	return announcer
+}
+
+func TestAnnouncerLifecycle(t *testing.T) {
+	tracker := &fakeAnnounceTracker{batches: [][]string{
+		{"10.0.0.1:6881", "10.0.0.2:6881"},
+		{"10.0.0.2:6881", "10.0.0.3:6881"},
+	}}
+	stats := NewTransferStats(1000)
+	announcer := newTestAnnouncer(tracker, stats)
+	announcer.DefaultInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		announcer.Run(ctx)
+	}()
+
+	// only peers not seen before are passed on
+	if peers := <-announcer.Peers(); !slices.Equal(peers, parsePeerAddresses([]string{"10.0.0.1:6881", "10.0.0.2:6881"})) {
+		t.Fatalf("unexpected first peers %v", peers)
+	}
+	stats.AddDownloaded(1200)
+	if peers := <-announcer.Peers(); !slices.Equal(peers, parsePeerAddresses([]string{"10.0.0.3:6881"})) {
+		t.Fatalf("unexpected new peers %v", peers)
+	}
+
+	stats.AddVerified(1000)
+	announcer.Completed()
+	completed := tracker.waitForEvent(t, EventCompleted)
+	if completed.Left != 0 || completed.Downloaded != 1200 {
+		t.Fatalf("unexpected stats in completed announce %+v", completed)
+	}
+
+	cancel()
+	<-done
+	if _, open := <-announcer.Peers(); open {
+		t.Fatalf("expected the peers channel to be closed")
+	}
+
+	events := tracker.events()
+	if events[0].Event != EventStarted || events[0].TrackerID != "" || events[0].Left != 1000 {
+		t.Fatalf("unexpected first announce %+v", events[0])
+	}
+	if last := events[len(events)-1]; last.Event != EventStopped {
+		t.Fatalf("expected the last announce to be stopped, got %+v", last)
+	}
+	for _, params := range events[1:] {
+		if params.TrackerID != "id-1" {
+			t.Fatalf("expected the tracker id to be sent back, got %+v", params)
+		}
+	}
+}
+
+func TestAnnouncerCompletedAtShutdown(t *testing.T) {
+	tracker := &fakeAnnounceTracker{batches: [][]string{{"10.0.0.1:6881"}}}
+	announcer := newTestAnnouncer(tracker, NewTransferStats(0))
+
+	// the download finished and was shut down before the first announce
+	ctx, cancel := context.WithCancel(context.Background())
+	announcer.Completed()
+	cancel()
+	announcer.Run(ctx)
+
+	events := []string{}
+	for _, params := range tracker.events() {
+		events = append(events, params.Event)
+	}
+	expected := []string{EventStarted, EventCompleted, EventStopped}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+}
+
+func TestAnnouncerCompletedTimeout(t *testing.T) {
+	tracker := &fakeAnnounceTracker{batches: [][]string{{"10.0.0.1:6881"}, {"10.0.0.2:6881"}}}
+	announcer := newTestAnnouncer(tracker, NewTransferStats(0))
+	announcer.StopTimeout = 50 * time.Millisecond
+
+	// a tracker that stops answering once the download is done
+	hung := make(chan struct{})
+	announcer.announce = func(url string, params *AnnounceParams) (*TrackerInfo, error) {
+		if params.Event == EventCompleted {
+			<-hung
+		}
+		return tracker.announce(url, params)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		announcer.Run(ctx)
+	}()
+	<-announcer.Peers()
+	announcer.Completed()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected shutdown not to wait for the completed announce")
+	}
+	// the late answer must not be sent on the closed peers channel
+	hung <- struct{}{}
+	tracker.waitForEvent(t, EventCompleted)
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestAnnounceHTTPParams(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte("d8:completei1e10:incompletei2e8:intervali60e12:min intervali30e5:peers0:10:tracker id4:id-2e"))
+	}))
+	defer server.Close()
+
+	params := &AnnounceParams{
+		InfoHash:   testInfoHash,
+		Uploaded:   10,
+		Downloaded: 20,
+		Left:       30,
+		Event:      EventStarted,
+		TrackerID:  "id-1",
+	}
+	trackerInfo, err := announce(server.URL+"/announce?passkey=abc", params)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if trackerInfo.TrackerID != "id-2" || trackerInfo.MinInterval != 30 {
+		t.Fatalf("unexpected tracker info %+v", trackerInfo)
+	}
+
+	expected := map[string]string{
+		"passkey":    "abc",
+		"uploaded":   "10",
+		"downloaded": "20",
+		"left":       "30",
+		"event":      "started",
+		"trackerid":  "id-1",
+	}
+	for key, value := range expected {
+		if query.Get(key) != value {
+			t.Fatalf("expected %s=%s, got %q", key, value, query.Get(key))
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
 	"testing"
 )
 
@@ -127,6 +130,27 @@ func TestDecodeBencode(t *testing.T) {
 			expectedInputLength: 0,
 			err:                 ErrInvalidDictionaryKey,
 		},
+		{
+			name:                "Empty input",
+			input:               "",
+			expectedOutput:      "",
+			expectedInputLength: 0,
+			err:                 io.ErrUnexpectedEOF,
+		},
+		{
+			name:                "String shorter than its declared length",
+			input:               "5:ab",
+			expectedOutput:      "",
+			expectedInputLength: 0,
+			err:                 io.ErrUnexpectedEOF,
+		},
+		{
+			name:                "Unterminated integer",
+			input:               "i12",
+			expectedOutput:      "",
+			expectedInputLength: 0,
+			err:                 io.ErrUnexpectedEOF,
+		},
 		{
 			name:                "Decodes maps as values of maps",
 			input:               "d3:keyi23e6:mapkeyd9:insidekeyi987eee",
@@ -142,7 +166,7 @@ func TestDecodeBencode(t *testing.T) {
 				if err == nil {
 					t.Fatalf("expected error, got nil")
 				}
-				if err != tc.err {
+				if !errors.Is(err, tc.err) {
 					t.Fatalf("expected different error. expected %q, found %q", tc.err.Error(), err.Error())
 				}
 			} else {
@@ -212,7 +236,7 @@ func TestEncodeBencode(t *testing.T) {
 		{
 			name:     "empty string",
 			input:    "",
-			expected: "",
+			expected: "0:",
 			err:      nil,
 		},
 		{
@@ -239,6 +263,30 @@ func TestEncodeBencode(t *testing.T) {
 			expected: "i-1234e",
 			err:      nil,
 		},
+		{
+			name:     "encode int64",
+			input:    int64(5 << 40),
+			expected: "i5497558138880e",
+			err:      nil,
+		},
+		{
+			name:     "encode uint32",
+			input:    uint32(4294967295),
+			expected: "i4294967295e",
+			err:      nil,
+		},
+		{
+			name:     "encode uint64 beyond int64",
+			input:    uint64(18446744073709551615),
+			expected: "i18446744073709551615e",
+			err:      nil,
+		},
+		{
+			name:     "encode big int",
+			input:    new(big.Int).Lsh(big.NewInt(-1), 100),
+			expected: "i-1267650600228229401496703205376e",
+			err:      nil,
+		},
 		{
 			name:     "encode list",
 			input:    BencodeList{"123", 456},
@@ -283,7 +331,7 @@ func TestEncodeBencode(t *testing.T) {
 				if err == nil {
 					t.Fatalf("expected error, got nil")
 				}
-				if err != tc.err {
+				if !errors.Is(err, tc.err) {
 					t.Fatalf("expected different error. expected %q, found %q", tc.err.Error(), err.Error())
 				}
 			} else {
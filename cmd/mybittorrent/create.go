@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+const (
+	minAutoPieceLength = 16 << 10
+	maxAutoPieceLength = 16 << 20
+	// auto-selected piece lengths grow until there are at most this many pieces
+	targetPieceCount = 1500
+)
+
+var ErrNoContent = errors.New("nothing to hash, the torrent would be empty")
+var ErrInvalidPieceLength = errors.New("piece length must be a positive power of two")
+
+var createOutputPath string
+var createAnnounceTiers []string
+var createComment string
+var createCreatedBy string
+var createPrivate bool
+var createWebSeeds []string
+var createPieceLength int
+var createNoDate bool
+
+func init() {
+	createCmd.Flags().StringVarP(&createOutputPath, "output", "o", "", "--output path/to/output.torrent, <name>.torrent if omitted")
+	createCmd.Flags().StringArrayVarP(&createAnnounceTiers, "announce", "a", nil, "--announce url[,url...], once per tier; the first url is the primary tracker")
+	createCmd.Flags().StringVarP(&createComment, "comment", "c", "", "--comment text")
+	createCmd.Flags().StringVar(&createCreatedBy, "created-by", "mybittorrent", "--created-by name")
+	createCmd.Flags().BoolVarP(&createPrivate, "private", "p", false, "--private, to disable DHT and peer exchange")
+	createCmd.Flags().StringArrayVarP(&createWebSeeds, "web-seed", "w", nil, "--web-seed url, may be repeated")
+	createCmd.Flags().IntVarP(&createPieceLength, "piece-length", "l", 0, "--piece-length bytes, auto-selected if omitted")
+	createCmd.Flags().BoolVar(&createNoDate, "no-date", false, "--no-date, to leave out the creation date")
+	rootCmd.AddCommand(createCmd)
+}
+
+var createCmd = &cobra.Command{
+	Use:   "create path/to/file_or_directory",
+	Short: "Create a .torrent file from a file or directory",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		options := createOptions{
+			pieceLength: createPieceLength,
+			comment:     createComment,
+			createdBy:   createCreatedBy,
+			private:     createPrivate,
+			webSeeds:    createWebSeeds,
+		}
+		for _, tier := range createAnnounceTiers {
+			options.announceTiers = append(options.announceTiers, strings.Split(tier, ","))
+		}
+		if !createNoDate {
+			options.creationDate = time.Now()
+		}
+
+		encoded, err := createTorrent(args[0], options)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		outputPath := createOutputPath
+		if outputPath == "" {
+			outputPath = filepath.Base(filepath.Clean(args[0])) + ".torrent"
+		}
+		if err := os.WriteFile(outputPath, []byte(encoded), 0644); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		var decoded metainfo
+		if err := bencode.Unmarshal([]byte(encoded), &decoded); err != nil {
+			fmt.Println(err)
+			return
+		}
+		info, err := parseTorrentInfo(decoded.Info)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Printf("Created %s\n", outputPath)
+		fmt.Printf("Info Hash: %x\n", info.Sha1Sum())
+		fmt.Printf("Piece Length: %d\n", info.PieceLength)
+		fmt.Printf("Pieces: %d\n", info.NumPieces)
+	},
+}
+
+type createOptions struct {
+	// 0 selects a piece length from the content size
+	pieceLength   int
+	announceTiers [][]string
+	comment       string
+	createdBy     string
+	// left out of the torrent when zero
+	creationDate time.Time
+	private      bool
+	webSeeds     []string
+}
+
+// createTorrent hashes the file or directory at contentPath and returns the
+// bencoded metainfo describing it.
+func createTorrent(contentPath string, options createOptions) (string, error) {
+	contentPath = filepath.Clean(contentPath)
+	name := filepath.Base(contentPath)
+
+	root, files, multiFile, err := collectContentFiles(contentPath)
+	if err != nil {
+		return "", err
+	}
+
+	var totalLength int64
+	for _, f := range files {
+		totalLength += f.Length
+	}
+	if totalLength == 0 {
+		return "", ErrNoContent
+	}
+
+	pieceLength := options.pieceLength
+	if pieceLength == 0 {
+		pieceLength = autoPieceLength(totalLength)
+	}
+	if pieceLength <= 0 || pieceLength&(pieceLength-1) != 0 {
+		return "", fmt.Errorf("%w: %d", ErrInvalidPieceLength, pieceLength)
+	}
+
+	store, err := storage.OpenReadOnly(root, files, int64(pieceLength))
+	if err != nil {
+		return "", err
+	}
+	defer store.Close()
+
+	numPieces := int((totalLength + int64(pieceLength) - 1) / int64(pieceLength))
+	pieces, err := hashPieces(store, numPieces, runtime.NumCPU())
+	if err != nil {
+		return "", err
+	}
+
+	info := BencodeMap{
+		"name":         name,
+		"piece length": pieceLength,
+		"pieces":       string(pieces),
+	}
+	if multiFile {
+		fileList := BencodeList{}
+		for _, f := range files {
+			path := BencodeList{}
+			for _, component := range f.Path {
+				path = append(path, component)
+			}
+			fileList = append(fileList, BencodeMap{"length": int(f.Length), "path": path})
+		}
+		info["files"] = fileList
+	} else {
+		info["length"] = int(totalLength)
+	}
+	if options.private {
+		info["private"] = 1
+	}
+
+	torrent := BencodeMap{"info": info}
+	if len(options.announceTiers) > 0 && len(options.announceTiers[0]) > 0 {
+		torrent["announce"] = options.announceTiers[0][0]
+	}
+	if len(options.announceTiers) > 1 || (len(options.announceTiers) == 1 && len(options.announceTiers[0]) > 1) {
+		announceList := BencodeList{}
+		for _, tier := range options.announceTiers {
+			urls := BencodeList{}
+			for _, url := range tier {
+				urls = append(urls, url)
+			}
+			announceList = append(announceList, urls)
+		}
+		torrent["announce-list"] = announceList
+	}
+	if options.comment != "" {
+		torrent["comment"] = options.comment
+	}
+	if options.createdBy != "" {
+		torrent["created by"] = options.createdBy
+	}
+	if !options.creationDate.IsZero() {
+		torrent["creation date"] = int(options.creationDate.Unix())
+	}
+	if len(options.webSeeds) > 0 {
+		urlList := BencodeList{}
+		for _, url := range options.webSeeds {
+			urlList = append(urlList, url)
+		}
+		torrent["url-list"] = urlList
+	}
+
+	return EncodeBencode(torrent)
+}
+
+// collectContentFiles lists the regular files to include, in the order their
+// pieces are laid out, relative to the returned root directory. Directories
+// are walked in lexical order.
+func collectContentFiles(contentPath string) (string, []storage.File, bool, error) {
+	stat, err := os.Stat(contentPath)
+	if err != nil {
+		return "", nil, false, err
+	}
+	if !stat.IsDir() {
+		files := []storage.File{{Path: []string{filepath.Base(contentPath)}, Length: stat.Size()}}
+		return filepath.Dir(contentPath), files, false, nil
+	}
+
+	files := []storage.File{}
+	var offset int64
+	err = filepath.WalkDir(contentPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		fileInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(contentPath, path)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, storage.File{
+			Path:   strings.Split(filepath.ToSlash(relativePath), "/"),
+			Length: fileInfo.Size(),
+			Offset: offset,
+		})
+		offset += fileInfo.Size()
+		return nil
+	})
+	if err != nil {
+		return "", nil, false, err
+	}
+	return contentPath, files, true, nil
+}
+
+func autoPieceLength(totalLength int64) int {
+	pieceLength := minAutoPieceLength
+	for pieceLength < maxAutoPieceLength && (totalLength+int64(pieceLength)-1)/int64(pieceLength) > targetPieceCount {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// hashPieces returns the concatenated SHA-1 hashes of every piece, reading
+// and hashing pieces on several goroutines at once.
+func hashPieces(store *storage.Storage, numPieces int, workers int) ([]byte, error) {
+	hashes := make([]byte, numPieces*sha1.Size)
+
+	indices := make(chan int)
+	done := make(chan struct{})
+	var firstErr error
+	var once sync.Once
+
+	go func() {
+		defer close(indices)
+		for i := 0; i < numPieces; i++ {
+			select {
+			case indices <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				piece, err := store.ReadPiece(i)
+				if err != nil {
+					once.Do(func() {
+						firstErr = fmt.Errorf("failed to read piece %d: %w", i, err)
+						close(done)
+					})
+					return
+				}
+				hash := sha1.Sum(piece)
+				copy(hashes[i*sha1.Size:], hash[:])
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return hashes, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+type createTestCase struct {
+	name          string
+	files         map[string]string
+	contentPath   string
+	options       createOptions
+	expectedFiles []FileEntry
+	expectedErr   error
+}
+
+func TestCreateTorrent(t *testing.T) {
+	testCases := []*createTestCase{
+		{
+			name:        "single file",
+			files:       map[string]string{"content.txt": strings.Repeat("single file content ", 10)},
+			contentPath: "content.txt",
+			options:     createOptions{pieceLength: 64, announceTiers: [][]string{{"http://tracker.example/announce"}}},
+			expectedFiles: []FileEntry{
+				{Path: []string{"content.txt"}, Length: 200, Offset: 0},
+			},
+		},
+		{
+			name: "directory with pieces spanning files",
+			files: map[string]string{
+				"content/b.txt":         strings.Repeat("b", 40),
+				"content/a.txt":         strings.Repeat("a", 10),
+				"content/sub/empty":     "",
+				"content/sub/deeper/c":  strings.Repeat("c", 33),
+				"content/z/last.bin":    "\x00\x01\x02",
+				"content/sub/d.txt":     strings.Repeat("d", 16),
+				"content/sub/deeper/e0": "e",
+			},
+			contentPath: "content",
+			options:     createOptions{pieceLength: 16, announceTiers: [][]string{{"http://tracker.example/announce"}}},
+			expectedFiles: []FileEntry{
+				{Path: []string{"content", "a.txt"}, Length: 10, Offset: 0},
+				{Path: []string{"content", "b.txt"}, Length: 40, Offset: 10},
+				{Path: []string{"content", "sub", "d.txt"}, Length: 16, Offset: 50},
+				{Path: []string{"content", "sub", "deeper", "c"}, Length: 33, Offset: 66},
+				{Path: []string{"content", "sub", "deeper", "e0"}, Length: 1, Offset: 99},
+				{Path: []string{"content", "sub", "empty"}, Length: 0, Offset: 100},
+				{Path: []string{"content", "z", "last.bin"}, Length: 3, Offset: 100},
+			},
+		},
+		{
+			name:        "without trackers",
+			files:       map[string]string{"content.txt": "trackerless"},
+			contentPath: "content.txt",
+			options:     createOptions{pieceLength: 16},
+			expectedFiles: []FileEntry{
+				{Path: []string{"content.txt"}, Length: 11, Offset: 0},
+			},
+		},
+		{
+			name:        "piece length not a power of two",
+			files:       map[string]string{"content.txt": "data"},
+			contentPath: "content.txt",
+			options:     createOptions{pieceLength: 1000},
+			expectedErr: ErrInvalidPieceLength,
+		},
+		{
+			name:        "empty file",
+			files:       map[string]string{"content.txt": ""},
+			contentPath: "content.txt",
+			expectedErr: ErrNoContent,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for path, contents := range tc.files {
+				fullPath := filepath.Join(dir, filepath.FromSlash(path))
+				if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if err := os.WriteFile(fullPath, []byte(contents), 0644); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+
+			encoded, err := createTorrent(filepath.Join(dir, tc.contentPath), tc.options)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			torrentPath := filepath.Join(dir, "out.torrent")
+			if err := os.WriteFile(torrentPath, []byte(encoded), 0644); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			torrent, err := ParseTorrent(torrentPath)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(torrent.Info.Files, tc.expectedFiles) {
+				t.Fatalf("expected files %+v, got %+v", tc.expectedFiles, torrent.Info.Files)
+			}
+
+			// hash the concatenated content independently of the storage layer
+			content := ""
+			for _, f := range tc.expectedFiles {
+				content += tc.files[strings.Join(f.Path, "/")]
+			}
+			expectedHashes := []string{}
+			for start := 0; start < len(content); start += tc.options.pieceLength {
+				end := start + tc.options.pieceLength
+				if end > len(content) {
+					end = len(content)
+				}
+				expectedHashes = append(expectedHashes, fmt.Sprintf("%x", sha1.Sum([]byte(content[start:end]))))
+			}
+			if !reflect.DeepEqual(torrent.Info.PieceHashes, expectedHashes) {
+				t.Fatalf("expected piece hashes %v, got %v", expectedHashes, torrent.Info.PieceHashes)
+			}
+		})
+	}
+}
+
+func TestCreateTorrentMetadata(t *testing.T) {
+	contentPath := filepath.Join(t.TempDir(), "content.txt")
+	if err := os.WriteFile(contentPath, []byte("metadata"), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	encoded, err := createTorrent(contentPath, createOptions{
+		announceTiers: [][]string{{"http://a.example/announce", "udp://b.example:80"}, {"http://c.example/announce"}},
+		comment:       "a comment",
+		createdBy:     "mybittorrent",
+		creationDate:  time.Unix(1700000000, 0),
+		private:       true,
+		webSeeds:      []string{"http://seed.example/content.txt"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var decoded struct {
+		Announce     string     `bencode:"announce"`
+		AnnounceList [][]string `bencode:"announce-list"`
+		Comment      string     `bencode:"comment"`
+		CreatedBy    string     `bencode:"created by"`
+		CreationDate int        `bencode:"creation date"`
+		URLList      []string   `bencode:"url-list"`
+		Info         struct {
+			PieceLength int `bencode:"piece length"`
+			Private     int `bencode:"private"`
+		} `bencode:"info"`
+	}
+	decoder := bencode.NewDecoder(strings.NewReader(encoded))
+	decoder.Strict()
+	if err := decoder.Decode(&decoded); err != nil {
+		t.Fatalf("expected canonical output, got %s", err)
+	}
+
+	if decoded.Announce != "http://a.example/announce" {
+		t.Fatalf("unexpected announce %q", decoded.Announce)
+	}
+	expectedTiers := [][]string{{"http://a.example/announce", "udp://b.example:80"}, {"http://c.example/announce"}}
+	if !reflect.DeepEqual(decoded.AnnounceList, expectedTiers) {
+		t.Fatalf("expected announce-list %v, got %v", expectedTiers, decoded.AnnounceList)
+	}
+	if decoded.Comment != "a comment" || decoded.CreatedBy != "mybittorrent" || decoded.CreationDate != 1700000000 {
+		t.Fatalf("unexpected comment %q, created by %q or creation date %d", decoded.Comment, decoded.CreatedBy, decoded.CreationDate)
+	}
+	if !reflect.DeepEqual(decoded.URLList, []string{"http://seed.example/content.txt"}) {
+		t.Fatalf("unexpected url-list %v", decoded.URLList)
+	}
+	if decoded.Info.Private != 1 || decoded.Info.PieceLength != minAutoPieceLength {
+		t.Fatalf("unexpected private flag %d or piece length %d", decoded.Info.Private, decoded.Info.PieceLength)
+	}
+}
+
+type autoPieceLengthTestCase struct {
+	totalLength         int64
+	expectedPieceLength int
+}
+
+func TestAutoPieceLength(t *testing.T) {
+	testCases := []*autoPieceLengthTestCase{
+		{totalLength: 1, expectedPieceLength: 16 << 10},
+		{totalLength: 1500 * 16 << 10, expectedPieceLength: 16 << 10},
+		{totalLength: 1500*16<<10 + 1, expectedPieceLength: 32 << 10},
+		{totalLength: 700 << 20, expectedPieceLength: 512 << 10},
+		{totalLength: 1 << 40, expectedPieceLength: 16 << 20},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.totalLength), func(t *testing.T) {
+			pieceLength := autoPieceLength(tc.totalLength)
+			if pieceLength != tc.expectedPieceLength {
+				t.Fatalf("expected piece length %d, got %d", tc.expectedPieceLength, pieceLength)
+			}
+		})
+	}
+}
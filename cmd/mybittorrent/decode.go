@@ -3,29 +3,90 @@ package main
 import (
 	// Uncomment this line to pass the first stage
 
-	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
 	"github.com/spf13/cobra"
 )
 
+var decodeInputPath string
+var decodeBinaryFormat string
+
 func init() {
+	decodeCmd.Flags().StringVarP(&decodeInputPath, "file", "f", "", "--file path/to/bencoded_file, - for stdin")
+	decodeCmd.Flags().StringVar(&decodeBinaryFormat, "binary", "hex", "--binary hex|base64, how to write strings that are not UTF-8")
 	rootCmd.AddCommand(decodeCmd)
 }
 
 var decodeCmd = &cobra.Command{
-	Use:  "decode",
-	Args: cobra.ExactArgs(1),
+	Use:  "decode [bencoded_value]",
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		bencodedValue := args[0]
+		binaryFormat, err := parseBinaryFormat(decodeBinaryFormat)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
 
-		decoded, err := DecodeBencode(bencodedValue)
+		var output any
+		if decodeInputPath != "" {
+			output, err = decodeFile(decodeInputPath)
+		} else if len(args) == 1 {
+			var decoded *DecodedToken
+			decoded, err = DecodeBencode(args[0])
+			if decoded != nil {
+				output = decoded.Output
+			}
+		} else {
+			err = fmt.Errorf("either a bencoded value or --file is required")
+		}
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 
-		jsonOutput, _ := json.Marshal(decoded.Output)
+		jsonOutput, err := bencode.ToJSON(output, binaryFormat)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
 		fmt.Println(string(jsonOutput))
 	},
 }
+
+func decodeFile(filename string) (any, error) {
+	input, err := openInput(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	decoder := bencode.NewDecoder(input)
+	decoder.UseBigInt()
+
+	var output any
+	if err := decoder.Decode(&output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// openInput opens filename for reading, or stdin if filename is "-".
+func openInput(filename string) (io.ReadCloser, error) {
+	if filename == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(filename)
+}
+
+func parseBinaryFormat(format string) (bencode.BinaryFormat, error) {
+	switch format {
+	case "hex":
+		return bencode.BinaryHex, nil
+	case "base64":
+		return bencode.BinaryBase64, nil
+	}
+	return 0, fmt.Errorf("unknown binary format %q, expected hex or base64", format)
+}
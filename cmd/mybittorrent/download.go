@@ -3,32 +3,35 @@ package main
 import (
 	// Uncomment this line to pass the first stage
 
-	"crypto/sha1"
+	"context"
 	"fmt"
-	"io"
-	"math"
-	"math/rand"
-	"net"
-	"os"
+	"net/netip"
 	"strings"
 	"time"
 
-	"github.com/kr/pretty"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
 var downloadOutputPath string
+var downloadMaxPeers int
+
+const (
+	peerDialTimeout = 10 * time.Second
+	// give up when no peer could be connected to for this long
+	peerSearchTimeout = 2 * time.Minute
+)
 
 func init() {
-	downloadCmd.Flags().StringVarP(&downloadOutputPath, "output", "o", "", "--output path/to/output_file")
+	downloadCmd.Flags().StringVarP(&downloadOutputPath, "output", "o", "", "--output path/to/output_file, or directory for multi-file torrents")
 	downloadCmd.MarkFlagRequired("output")
+	downloadCmd.Flags().IntVar(&downloadMaxPeers, "max-peers", defaultMaxPeers, "--max-peers number of peers to download from at once")
 	rootCmd.AddCommand(downloadCmd)
 }
 
 var downloadCmd = &cobra.Command{
-	Use:  "download path/to/torrent_file",
+	Use:  "download path/to/torrent_file|magnet_link",
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		log := log.Level(zerolog.DebugLevel)
@@ -36,236 +39,79 @@ var downloadCmd = &cobra.Command{
 
 		outputPath := downloadOutputPath
 
-		torrent, err := ParseTorrent(filename)
+		torrent, err := LoadTorrent(filename)
 		if err != nil {
 			fmt.Println("parse torrent: ", err.Error())
 			return
 		}
 
-		log.Debug().Msgf("%d", torrent.Info.Length)
-		log.Debug().Msgf(strings.Join(torrent.Info.PieceHashes, ","))
-		log.Debug().Msgf("%d", torrent.Info.PieceLength)
-
-		fileLength := torrent.Info.Length
-		// open file for writing
-		file, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			fmt.Printf("failed to open file for writing: %s\n", err.Error())
+		if torrent.TrackerTiers().Len() == 0 && len(torrent.Peers) == 0 {
+			fmt.Println("download: ", ErrNoTrackers.Error())
 			return
 		}
-		defer file.Close()
-
-		pieceLengths := []int{}
-		sumPieceLenghts := 0
-		for i := 0; i < torrent.Info.NumPieces; i++ {
-			pieceLength := torrent.Info.PieceLength
-			if pieceLength+sumPieceLenghts > fileLength {
-				pieceLength = fileLength - sumPieceLenghts
-			}
-			pieceLengths = append(pieceLengths, pieceLength)
-			sumPieceLenghts += pieceLength
-		}
 
-		log.Debug().Msgf("piece lengths: %s", pretty.Sprint(pieceLengths))
-
-		// reserve space for entire file
-		err = file.Truncate(int64(fileLength))
-		if err != nil {
-			fmt.Printf("failed to truncate file: %s\n", err.Error())
-			return
-		}
+		log.Debug().Msgf("%d", torrent.Info.Length)
+		log.Debug().Msgf(strings.Join(torrent.Info.PieceHashes, ","))
+		log.Debug().Msgf("%d", torrent.Info.PieceLength)
 
-		trackerInfo, err := GetTrackerInfo(torrent)
+		// create every file of the torrent, sized to its final length
+		store, err := torrent.Info.OpenStorage(outputPath)
 		if err != nil {
-			fmt.Println("get tracker info: ", err.Error())
-			return
-		}
-
-		numPeers := len(trackerInfo.Peers)
-		if numPeers == 0 {
-			fmt.Println("no peers found")
+			fmt.Printf("failed to open storage for writing: %s\n", err.Error())
 			return
 		}
-
-		randomPeerAddress := trackerInfo.Peers[rand.Intn(numPeers)]
-
-		log.Debug().Msgf("Chosen peer: %s", randomPeerAddress)
-
-		tcpConn, err := net.Dial("tcp", randomPeerAddress)
-		if err != nil {
-			fmt.Println("net dial: ", err.Error())
-			return
+		defer store.Close()
+
+		// keep announcing in the background, feeding us peers, until done
+		stats := NewTransferStats(torrent.Info.Length)
+		announcer := NewAnnouncer(torrent.TrackerTiers(), torrent.Info.InfoHash(), stats)
+		ctx, cancel := context.WithCancel(context.Background())
+		announcerDone := make(chan struct{})
+		var announced <-chan []netip.AddrPort
+		if torrent.TrackerTiers().Len() > 0 {
+			announced = announcer.Peers()
+			go func() {
+				defer close(announcerDone)
+				announcer.Run(ctx)
+			}()
+		} else {
+			close(announcerDone)
 		}
-
-		err = SendHandshake(tcpConn, torrent.Info.Sha1Sum())
-		if err != nil {
-			fmt.Println("send handshake: ", err.Error())
+		defer func() {
+			cancel()
+			<-announcerDone
+		}()
+
+		swarm := NewSwarm(torrent.Info, store, stats)
+		swarm.MaxPeers = downloadMaxPeers
+		if err := swarm.Run(ctx, withKnownPeers(ctx, torrent.Peers, announced)); err != nil {
+			fmt.Println("download: ", err.Error())
 			return
 		}
+		log.Debug().Msgf("Downloaded %s to %s", torrent.Info.Name, outputPath)
+		announcer.Completed()
+	},
+}
 
-		remotePeerIDBytes, err := ReadHandshakeAck(tcpConn, torrent.Info.Sha1Sum())
-		if err != nil {
-			fmt.Println("read handshake ack: ", err.Error())
+// withKnownPeers sends known, then every batch of peers received on
+// announced, which may be nil when the torrent has no trackers.
+func withKnownPeers(ctx context.Context, known []netip.AddrPort, announced <-chan []netip.AddrPort) <-chan []netip.AddrPort {
+	peers := make(chan []netip.AddrPort, 1)
+	if len(known) > 0 {
+		peers <- known
+	}
+	go func() {
+		defer close(peers)
+		if announced == nil {
 			return
 		}
-
-		log.Debug().Msgf("Chosen peer id: %x", remotePeerIDBytes)
-
-		messageLengthBytes := make([]byte, 4)
-		messageIdBytes := make([]byte, 1)
-		var messageId byte
-
-		numBlocksPerPiece := []int{}
-		totalNumBlocks := 0
-		for _, pieceLength := range pieceLengths {
-			numBlocks := int(math.Ceil(float64(pieceLength) / 16384))
-			numBlocksPerPiece = append(numBlocksPerPiece, numBlocks)
-			totalNumBlocks += int(numBlocks)
-		}
-
-		numBlocksWritten := 0
-		blocksPerPiece := make([][][]byte, torrent.Info.NumPieces)
-		for i := 0; i < torrent.Info.NumPieces; i++ {
-			blocksPerPiece[i] = make([][]byte, numBlocksPerPiece[i])
-		}
-		for numBlocksWritten < totalNumBlocks {
-			_, err = io.ReadAtLeast(tcpConn, messageLengthBytes, 4)
-			if err != nil {
-				fmt.Println("read message length: ", err.Error())
-				return
-			}
-
-			messageLength := byteSliceToInt(messageLengthBytes)
-			log.Debug().Msgf("message length: %d", messageLength)
-
-			if messageLength == 0 {
-				fmt.Println("got keepalive")
-				continue
-			}
-
-			payloadLength := messageLength - 1
-
-			_, err = io.ReadAtLeast(tcpConn, messageIdBytes, 1)
-			if err != nil {
-				fmt.Println("read message id: ", err.Error())
+		for batch := range announced {
+			select {
+			case peers <- batch:
+			case <-ctx.Done():
 				return
 			}
-
-			messageId = messageIdBytes[0]
-			if messageId == BitfieldMessageID {
-				log.Debug().Msg("found bitfield message")
-
-				payload := make([]byte, payloadLength)
-				_, err := io.ReadAtLeast(tcpConn, payload, payloadLength)
-				if err != nil {
-					fmt.Println("read message payload: ", err.Error())
-					return
-				}
-
-				log.Debug().Msg(pretty.Sprint(payload))
-
-				err = SendInterested(tcpConn)
-				if err != nil {
-					fmt.Printf("failed to send Interested message: %s\n", err.Error())
-					return
-				}
-			} else if messageId == ChokeMessageID {
-				log.Debug().Msgf("found choke message")
-				time.Sleep(3 * time.Second)
-			} else if messageId == UnchokeMessageID {
-				log.Debug().Msgf("got unchoke message")
-
-				for pieceNumber := 0; pieceNumber < torrent.Info.NumPieces; pieceNumber++ {
-					remainingBlockLength := pieceLengths[pieceNumber]
-					numBlocks := numBlocksPerPiece[pieceNumber]
-					for blockNumber := 0; blockNumber < numBlocks; blockNumber++ {
-						// min(x,y) is only go >1.21, and codecrafters are running this on 1.19 it seems
-						blockSize := remainingBlockLength
-						if remainingBlockLength > 16384 {
-							blockSize = 16384
-						}
-						log.Debug().Msgf("requesting block %d for piece %d, size %d", blockNumber, pieceNumber, blockSize)
-						err := RequestPieceBlock(tcpConn, pieceNumber, blockNumber, blockSize)
-						if err != nil {
-							fmt.Printf("failed to request block %d for piece %d: %q", blockNumber, pieceNumber, err.Error())
-							return
-						}
-						remainingBlockLength -= 16384
-						log.Debug().Msgf("requested block %d for piece %d", blockNumber, pieceNumber)
-					}
-				}
-			} else if messageId == PieceMessageID {
-				headers := make([]byte, 8)
-				blockLength := payloadLength - 8
-				if blockLength > 16384 || blockLength < 10 { // 10 should be minimum: 1-byte message_id + 8-byte index/begin + 1 byte block
-					fmt.Printf("wrong block length received: %d\n", blockLength)
-				}
-
-				_, err := io.ReadAtLeast(tcpConn, headers, 8)
-				if err != nil {
-					fmt.Println("read piece headers: ", err.Error())
-					return
-				}
-
-				pieceIndex := byteSliceToInt(headers[0:4])
-				log.Debug().Msgf("received block for piece %d", pieceIndex)
-				beginOffset := byteSliceToInt(headers[4:8])
-				if beginOffset > pieceLengths[pieceIndex] {
-					fmt.Printf("begin offset greater than piece length: %d\n", beginOffset)
-					return
-				}
-				log.Debug().Msgf("block begins at %d", beginOffset)
-				log.Debug().Msgf("block length is %d", blockLength)
-
-				block := make([]byte, blockLength)
-				_, err = io.ReadAtLeast(tcpConn, block, blockLength)
-				if err != nil {
-					fmt.Println("read block", err.Error())
-					return
-				}
-
-				filePos := pieceIndex*torrent.Info.PieceLength + beginOffset
-				_, err = file.Seek(int64(filePos), 0)
-				if err != nil {
-					fmt.Println("output file seek: ", err.Error())
-					return
-				}
-
-				n, err := file.Write(block)
-				if err != nil {
-					fmt.Println("file write: ", err.Error())
-					return
-				}
-				if n != blockLength {
-					fmt.Printf("wrote %d bytes but block length was %d\n", n, blockLength)
-					return
-				}
-
-				blockNumber := beginOffset / 16384
-				blocksPerPiece[pieceIndex][blockNumber] = block
-				numBlocksWritten++
-				log.Debug().Msgf("wrote block %d for piece %d", blockNumber, pieceIndex)
-			} else {
-				log.Debug().Msgf("found message id %d\n", messageId)
-				break
-			}
 		}
-
-		for i := 0; i < torrent.Info.NumPieces; i++ {
-			var allPieceBytes []byte
-			for _, b := range blocksPerPiece[i] {
-				allPieceBytes = append(allPieceBytes, b...)
-			}
-
-			hasher := sha1.New()
-			hasher.Write([]byte(allPieceBytes))
-			pieceHash := hasher.Sum(nil)
-
-			if fmt.Sprintf("%x", pieceHash) != torrent.Info.PieceHashes[i] {
-				fmt.Printf("hash mismatch, wanted %q, obtained %q\n", torrent.Info.PieceHashes[i], fmt.Sprintf("%x", pieceHash))
-				return
-			}
-			log.Debug().Msgf("Piece %d downloaded to %s\n", i, outputPath)
-		}
-	},
+	}()
+	return peers
 }
@@ -3,18 +3,15 @@ package main
 import (
 	// Uncomment this line to pass the first stage
 
-	"crypto/sha1"
+	"errors"
 	"fmt"
-	"io"
-	"math"
 	"math/rand"
-	"net"
-	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
-	"github.com/kr/pretty"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/storage"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -22,17 +19,11 @@ import (
 
 var pieceDownloadOutputPath string
 
-const (
-	ChokeMessageID byte = iota
-	UnchokeMessageID
-	InterestedMessageID
-	NotInterestedMessageID
-	HaveMessageID
-	BitfieldMessageID
-	RequestMessageID
-	PieceMessageID
-	CancelMessageID
-)
+// blocks are requested 16 KiB at a time, the last block of a piece may be
+// shorter
+const maxBlockLength = 16 << 10
+
+var ErrInvalidBlock = errors.New("invalid block")
 
 func init() {
 	downloadPieceCmd.Flags().StringVarP(&pieceDownloadOutputPath, "output", "o", "", "--output path/to/output_file")
@@ -65,27 +56,23 @@ var downloadPieceCmd = &cobra.Command{
 		log.Debug().Msgf(strings.Join(torrent.Info.PieceHashes, ","))
 		log.Debug().Msgf("%d", torrent.Info.PieceLength)
 
-		fileLength := torrent.Info.Length
-		// open file for writing
-		file, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			fmt.Printf("failed to open file for writing: %s\n", err.Error())
+		if requestedPieceIndex < 0 || requestedPieceIndex >= torrent.Info.NumPieces {
+			fmt.Printf("piece index %d out of range, the torrent has %d pieces\n", requestedPieceIndex, torrent.Info.NumPieces)
 			return
 		}
-		defer file.Close()
-
-		pieceLength := torrent.Info.PieceLength
-		// if it's the last piece, adjust the piece length
-		if requestedPieceIndex == torrent.Info.NumPieces-1 {
-			pieceLength = fileLength % pieceLength
-		}
-
-		// reserve space for 1 piece length
-		err = file.Truncate(int64(pieceLength))
+		// the last piece, or the last piece of a file in v2 torrents, is shorter
+		pieceLength := torrent.Info.PieceSize(requestedPieceIndex)
+
+		// the output holds a single piece, whichever files of the torrent it spans
+		store, err := storage.Open(filepath.Dir(outputPath), []storage.File{{
+			Path:   []string{filepath.Base(outputPath)},
+			Length: int64(pieceLength),
+		}}, int64(pieceLength))
 		if err != nil {
-			fmt.Printf("failed to truncate file: %s\n", err.Error())
+			fmt.Printf("failed to open file for writing: %s\n", err.Error())
 			return
 		}
+		defer store.Close()
 
 		trackerInfo, err := GetTrackerInfo(torrent)
 		if err != nil {
@@ -103,154 +90,74 @@ var downloadPieceCmd = &cobra.Command{
 
 		log.Debug().Msgf("Chosen peer: %s", randomPeerAddress)
 
-		tcpConn, err := net.Dial("tcp", randomPeerAddress)
+		peer, err := DialPeer(randomPeerAddress, torrent.Info.InfoHash())
 		if err != nil {
 			fmt.Println(err.Error())
 			return
 		}
+		peer.Start()
+		defer peer.Close()
 
-		err = SendHandshake(tcpConn, torrent.Info.Sha1Sum())
-		if err != nil {
-			fmt.Println(err.Error())
-			return
-		}
-
-		remotePeerIDBytes, err := ReadHandshakeAck(tcpConn, torrent.Info.Sha1Sum())
-		if err != nil {
-			fmt.Println(err.Error())
-			return
-		}
+		log.Debug().Msgf("Chosen peer id: %x", peer.PeerID)
 
-		log.Debug().Msgf("Chosen peer id: %x", remotePeerIDBytes)
-
-		messageLengthBytes := make([]byte, 4)
-		messageIdBytes := make([]byte, 1)
-		var messageId byte
-
-		numBlocks := int(math.Ceil(float64(pieceLength) / 16384))
+		numBlocks := (pieceLength + maxBlockLength - 1) / maxBlockLength
 		blocks := make([][]byte, numBlocks)
 		numBlocksWritten := 0
 
 		for numBlocksWritten < numBlocks {
-			_, err = io.ReadAtLeast(tcpConn, messageLengthBytes, 4)
-			if err != nil {
-				fmt.Println(err.Error())
-				return
-			}
-
-			messageLength := byteSliceToInt(messageLengthBytes)
-			log.Debug().Msgf("message length: %d", messageLength)
-
-			if messageLength == 0 {
-				fmt.Println("got keepalive")
-				continue
-			}
-
-			payloadLength := messageLength - 1
-
-			_, err = io.ReadAtLeast(tcpConn, messageIdBytes, 1)
-			if err != nil {
-				fmt.Println(err.Error())
+			message, open := <-peer.Messages()
+			if !open {
+				fmt.Println(peer.Err().Error())
 				return
 			}
+			log.Debug().Msgf("received %s", message)
 
-			messageId = messageIdBytes[0]
-			if messageId == BitfieldMessageID {
-				log.Debug().Msg("found bitfield message")
-
-				payload := make([]byte, payloadLength)
-				_, err := io.ReadAtLeast(tcpConn, payload, payloadLength)
-				if err != nil {
-					fmt.Println(err.Error())
+			switch message.ID {
+			case peerwire.Bitfield:
+				if !peerwire.PieceBitfield(message.Payload).Has(requestedPieceIndex) {
+					fmt.Printf("peer does not have piece %d\n", requestedPieceIndex)
 					return
 				}
 
-				log.Debug().Msg(pretty.Sprint(payload))
-
-				err = SendInterested(tcpConn)
-				if err != nil {
+				if err := peer.SetInterested(true); err != nil {
 					fmt.Printf("failed to send Interested message: %s\n", err.Error())
 					return
 				}
-			} else if messageId == ChokeMessageID {
-				log.Debug().Msgf("found choke message")
-				time.Sleep(3 * time.Second)
-			} else if messageId == UnchokeMessageID {
-				log.Debug().Msgf("got unchoke message")
-
-				remainingLength := pieceLength
-				for blockNumber := 0; blockNumber < numBlocks; blockNumber++ {
-					log.Debug().Msgf("requesting block %d for piece %d", blockNumber, requestedPieceIndex)
-					// min(x,y) is only go >1.21, and codecrafters are running this on 1.19 it seems
-					blockSize := remainingLength
-					if remainingLength > 16384 {
-						blockSize = 16384
-					}
-					err := RequestPieceBlock(tcpConn, requestedPieceIndex, blockNumber, blockSize)
-					if err != nil {
-						fmt.Printf("failed to request block %d for piece %d: %q", blockNumber, requestedPieceIndex, err.Error())
-						return
-					}
-					remainingLength -= 16384
-					log.Debug().Msgf("requested block %d for piece %d", blockNumber, requestedPieceIndex)
-				}
-			} else if messageId == PieceMessageID {
-				headers := make([]byte, 8)
-				blockLength := payloadLength - 8
-				if blockLength > 16384 || blockLength < 10 { // 10 should be minimum: 1-byte message_id + 8-byte index/begin + 1 byte block
-					fmt.Printf("wrong block length received: %d\n", blockLength)
-				}
-
-				_, err := io.ReadAtLeast(tcpConn, headers, 8)
-				if err != nil {
-					fmt.Println(err.Error())
+			case peerwire.Unchoke:
+				// requests still pending when we were choked were dropped, ask again
+				if err := requestPiece(peer, requestedPieceIndex, blocks, pieceLength); err != nil {
+					fmt.Printf("failed to request piece %d: %q", requestedPieceIndex, err.Error())
 					return
 				}
-
-				pieceIndex := byteSliceToInt(headers[0:4])
+				log.Debug().Msgf("requested %d blocks for piece %d", numBlocks, requestedPieceIndex)
+			case peerwire.Piece:
+				pieceIndex := int(message.Index)
 				if pieceIndex != requestedPieceIndex {
 					fmt.Printf("requested piece index %d, but got a block for piece index %d\n", requestedPieceIndex, pieceIndex)
 					return
 				}
-				log.Debug().Msgf("received block for piece %d", pieceIndex)
-				beginOffset := byteSliceToInt(headers[4:8])
-				if beginOffset > pieceLength {
-					fmt.Printf("begin offset greater than piece length: %d\n", beginOffset)
-					return
-				}
-				log.Debug().Msgf("block begins at %d", beginOffset)
-				log.Debug().Msgf("block length is %d", blockLength)
-
-				block := make([]byte, blockLength)
-				_, err = io.ReadAtLeast(tcpConn, block, blockLength)
-				if err != nil {
+				beginOffset := int(message.Begin)
+				block := message.Payload
+				if err := checkBlock(beginOffset, len(block), pieceLength); err != nil {
 					fmt.Println(err.Error())
 					return
 				}
 
-				_, err = file.Seek(int64(beginOffset), 0)
-				if err != nil {
-					fmt.Println(err.Error())
-					return
+				blockNumber := beginOffset / maxBlockLength
+				if blocks[blockNumber] != nil {
+					log.Debug().Msgf("dropped duplicate block %d", blockNumber)
+					continue
 				}
 
-				n, err := file.Write(block)
+				err = store.WriteBlock(0, beginOffset, block)
 				if err != nil {
 					fmt.Println(err.Error())
 					return
 				}
-				if n != blockLength {
-					fmt.Printf("wrote %d bytes but block length was %d\n", n, blockLength)
-					return
-				}
 
-				blockNumber := beginOffset / 16384
 				blocks[blockNumber] = block
 				numBlocksWritten++
 				log.Debug().Msgf("wrote block %d", blockNumber)
-			} else {
-				log.Debug().Msgf("found message id %d\n", messageId)
-				break
 			}
 		}
 
@@ -259,12 +166,8 @@ var downloadPieceCmd = &cobra.Command{
 			allPieceBytes = append(allPieceBytes, b...)
 		}
 
-		hasher := sha1.New()
-		hasher.Write([]byte(allPieceBytes))
-		pieceHash := hasher.Sum(nil)
-
-		if fmt.Sprintf("%x", pieceHash) != torrent.Info.PieceHashes[requestedPieceIndex] {
-			fmt.Printf("hash mismatch, wanted %q, obtained %q\n", torrent.Info.PieceHashes[requestedPieceIndex], fmt.Sprintf("%x", pieceHash))
+		if err := torrent.Info.VerifyPiece(requestedPieceIndex, allPieceBytes); err != nil {
+			fmt.Println(err.Error())
 			return
 		}
 
@@ -272,57 +175,31 @@ var downloadPieceCmd = &cobra.Command{
 	},
 }
 
-func byteSliceToInt(b []byte) int {
-	bLen := len(b)
-	res := 0
-	power := float64(bLen - 1)
-	for i := 0; i < bLen; i++ {
-		res += int(math.Pow(256, power)) * int(b[i])
-		power--
-	}
-	return res
-}
-
-func intToByteSlice(i int) []byte {
-	res := make([]byte, 4)
-	for b := 3; b >= 0; b-- {
-		res[b] = byte(i & 255)
-		i = i >> 8
-	}
-	return res
-}
-
-func SendInterested(tcpConn net.Conn) error {
-	numBytesWritten, err := tcpConn.Write([]byte{0, 0, 0, 1, InterestedMessageID})
-	if err != nil {
-		return err
-	}
-
-	if numBytesWritten != 5 {
-		return fmt.Errorf("didn't write full ack. num bytes written: %d", numBytesWritten)
+// requestPiece asks for the blocks of a piece that were not received yet.
+func requestPiece(peer *PeerConn, pieceIndex int, blocks [][]byte, pieceLength int) error {
+	for begin := 0; begin < pieceLength; begin += maxBlockLength {
+		if blocks[begin/maxBlockLength] != nil {
+			continue
+		}
+		// min(x,y) is only go >1.21, and codecrafters are running this on 1.19 it seems
+		length := pieceLength - begin
+		if length > maxBlockLength {
+			length = maxBlockLength
+		}
+		request := &peerwire.Message{ID: peerwire.Request, Index: uint32(pieceIndex), Begin: uint32(begin), Length: uint32(length)}
+		if err := peer.Send(request); err != nil {
+			return err
+		}
 	}
-
 	return nil
 }
 
-func RequestPieceBlock(tcpConn net.Conn, pieceIndex int, blockIndex int, blockSize int) error {
-	// length 13 = message id + 3 32-bit integers (index, begin, length)
-	indexBytes := intToByteSlice(pieceIndex)
-	beginBytes := intToByteSlice(blockIndex * 16384)
-	blockBytes := intToByteSlice(blockSize)
-
-	payload := []byte{0, 0, 0, 13, RequestMessageID}
-	payload = append(payload, indexBytes...)
-	payload = append(payload, beginBytes...)
-	payload = append(payload, blockBytes...)
-	numBytesWritten, err := tcpConn.Write(payload)
-	if err != nil {
-		return err
-	}
-
-	if numBytesWritten != 17 {
-		return fmt.Errorf("didn't write full ack. num bytes written: %d", numBytesWritten)
+// checkBlock validates where a received block lands within its piece. Only
+// the last block of a piece may be shorter than the others.
+func checkBlock(begin int, length int, pieceLength int) error {
+	if length == 0 || length > maxBlockLength || begin%maxBlockLength != 0 || begin+length > pieceLength ||
+		(length < maxBlockLength && begin+length != pieceLength) {
+		return fmt.Errorf("%w: %d bytes at offset %d of a %d byte piece", ErrInvalidBlock, length, begin, pieceLength)
 	}
-
 	return nil
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+type checkBlockTestCase struct {
+	name        string
+	begin       int
+	length      int
+	pieceLength int
+	expectedErr error
+}
+
+func TestCheckBlock(t *testing.T) {
+	testCases := []*checkBlockTestCase{
+		{
+			name:        "full block",
+			begin:       maxBlockLength,
+			length:      maxBlockLength,
+			pieceLength: 3 * maxBlockLength,
+		},
+		{
+			name:        "short last block",
+			begin:       maxBlockLength,
+			length:      100,
+			pieceLength: maxBlockLength + 100,
+		},
+		{
+			name:        "short block before the end",
+			begin:       0,
+			length:      100,
+			pieceLength: 2 * maxBlockLength,
+			expectedErr: ErrInvalidBlock,
+		},
+		{
+			name:        "unaligned block",
+			begin:       100,
+			length:      maxBlockLength,
+			pieceLength: 2 * maxBlockLength,
+			expectedErr: ErrInvalidBlock,
+		},
+		{
+			name:        "block past the end",
+			begin:       maxBlockLength,
+			length:      maxBlockLength,
+			pieceLength: maxBlockLength + 100,
+			expectedErr: ErrInvalidBlock,
+		},
+		{
+			name:        "empty block",
+			begin:       0,
+			length:      0,
+			pieceLength: maxBlockLength,
+			expectedErr: ErrInvalidBlock,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkBlock(tc.begin, tc.length, tc.pieceLength)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/spf13/cobra"
+)
+
+const dumpPreviewLength = 48
+
+func init() {
+	rootCmd.AddCommand(dumpCmd)
+}
+
+var dumpCmd = &cobra.Command{
+	Use:   "dump [path/to/file]",
+	Short: "Print a bencoded file, stdin or raw HTTP tracker response as an annotated tree",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		filename := "-"
+		if len(args) == 1 {
+			filename = args[0]
+		}
+
+		input, err := openInput(filename)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer input.Close()
+
+		dump, err := dumpBencode(input)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Print(dump)
+	},
+}
+
+// dumpBencode reads a single bencoded value, or a raw HTTP response carrying
+// one, and returns it as an annotated tree.
+func dumpBencode(input io.Reader) (string, error) {
+	out := &strings.Builder{}
+	reader := bufio.NewReader(input)
+	// a raw tracker response saved with its HTTP headers
+	if prefix, _ := reader.Peek(5); string(prefix) == "HTTP/" {
+		httpResponse, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			return "", err
+		}
+		defer httpResponse.Body.Close()
+		fmt.Fprintf(out, "%s %s\n", httpResponse.Proto, httpResponse.Status)
+		reader = bufio.NewReader(httpResponse.Body)
+	}
+
+	decoder := bencode.NewDecoder(reader)
+	decoder.UseBigInt()
+	tok, err := decoder.Token()
+	if err != nil {
+		return "", err
+	}
+	root, err := readDumpNode(decoder, tok, 0, "")
+	if err != nil {
+		return "", err
+	}
+
+	printDumpNode(out, root, 0)
+	printDumpSummary(out, root)
+	return out.String(), nil
+}
+
+// dumpNode is a decoded value along with where it sits in the input.
+type dumpNode struct {
+	path     string
+	offset   int64
+	size     int64
+	kind     bencode.Delim // 'l', 'd', or 0 for strings and integers
+	value    any
+	children []*dumpNode
+}
+
+// readDumpNode builds the node for tok, which started at offset, reading the
+// rest of its container from decoder if it opens one.
+func readDumpNode(decoder *bencode.Decoder, tok bencode.Token, offset int64, path string) (*dumpNode, error) {
+	node := &dumpNode{path: path, offset: offset}
+
+	delim, isDelim := tok.(bencode.Delim)
+	if !isDelim {
+		node.value = tok
+		node.size = decoder.InputOffset() - offset
+		return node, nil
+	}
+	node.kind = delim
+
+	for {
+		childOffset := decoder.InputOffset()
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if tok == bencode.Delim('e') {
+			break
+		}
+
+		childPath := path + "[" + strconv.Itoa(len(node.children)) + "]"
+		if delim == 'd' {
+			childPath = joinDumpPath(path, tok.(string))
+			childOffset = decoder.InputOffset()
+			tok, err = decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		child, err := readDumpNode(decoder, tok, childOffset, childPath)
+		if err != nil {
+			return nil, err
+		}
+		node.children = append(node.children, child)
+	}
+
+	node.size = decoder.InputOffset() - offset
+	return node, nil
+}
+
+func joinDumpPath(path string, key string) string {
+	if !utf8.ValidString(key) {
+		key = fmt.Sprintf("%x", key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func printDumpNode(out *strings.Builder, node *dumpNode, depth int) {
+	label := node.path
+	if label == "" {
+		label = "(root)"
+	}
+	fmt.Fprintf(out, "@%-8d%s%s: %s\n", node.offset, strings.Repeat("  ", depth), label, describeDumpNode(node))
+
+	for _, child := range node.children {
+		printDumpNode(out, child, depth+1)
+	}
+}
+
+func describeDumpNode(node *dumpNode) string {
+	switch node.kind {
+	case 'd':
+		return fmt.Sprintf("dict, %d keys, %d bytes", len(node.children), node.size)
+	case 'l':
+		return fmt.Sprintf("list, %d items, %d bytes", len(node.children), node.size)
+	}
+
+	str, isString := node.value.(string)
+	if !isString {
+		return fmt.Sprintf("int %v", node.value)
+	}
+
+	if !utf8.ValidString(str) {
+		preview := []byte(str)
+		ellipsis := ""
+		if len(preview) > dumpPreviewLength/2 {
+			preview = preview[:dumpPreviewLength/2]
+			ellipsis = "..."
+		}
+		return fmt.Sprintf("string, %d bytes, binary %x%s", len(str), preview, ellipsis)
+	}
+
+	if len(str) > dumpPreviewLength {
+		return fmt.Sprintf("string, %d bytes, %q...", len(str), str[:dumpPreviewLength])
+	}
+	return fmt.Sprintf("string, %d bytes, %q", len(str), str)
+}
+
+// printDumpSummary points out the well-known binary blobs that are hard to
+// read in the tree: piece hashes in metainfo files and compact peer lists in
+// tracker responses.
+func printDumpSummary(out *strings.Builder, root *dumpNode) {
+	summaries := []string{}
+	for _, child := range root.children {
+		switch child.path {
+		case "info":
+			for _, infoChild := range child.children {
+				if pieces, ok := infoChild.value.(string); ok && infoChild.path == "info.pieces" {
+					summaries = append(summaries, describeDumpBlob("piece hashes", pieces, 20))
+				}
+			}
+		case "peers":
+			if peers, ok := child.value.(string); ok {
+				summaries = append(summaries, describeDumpBlob("compact IPv4 peers", peers, 6))
+			}
+		case "peers6":
+			if peers, ok := child.value.(string); ok {
+				summaries = append(summaries, describeDumpBlob("compact IPv6 peers", peers, 18))
+			}
+		}
+	}
+
+	if len(summaries) == 0 {
+		return
+	}
+	out.WriteString("\nsummary:\n")
+	for _, summary := range summaries {
+		out.WriteString("  " + summary + "\n")
+	}
+}
+
+func describeDumpBlob(name string, blob string, entrySize int) string {
+	if len(blob)%entrySize != 0 {
+		return fmt.Sprintf("%s: %d bytes is not a multiple of %d", name, len(blob), entrySize)
+	}
+	return fmt.Sprintf("%s: %d", name, len(blob)/entrySize)
+}
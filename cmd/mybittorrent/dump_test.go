@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+type dumpTestCase struct {
+	name           string
+	input          string
+	expectedOutput string
+	err            error
+}
+
+func TestDumpBencode(t *testing.T) {
+	testCases := []*dumpTestCase{
+		{
+			name:  "nested dictionaries and lists",
+			input: "d4:infod5:filesld6:lengthi5e4:pathl1:aeee6:pieces20:aaaaaaaaaaaaaaaaaaaaee",
+			expectedOutput: "@0       (root): dict, 1 keys, 74 bytes\n" +
+				"@7         info: dict, 2 keys, 66 bytes\n" +
+				"@15          info.files: list, 1 items, 26 bytes\n" +
+				"@16            info.files[0]: dict, 2 keys, 24 bytes\n" +
+				"@25              info.files[0].length: int 5\n" +
+				"@34              info.files[0].path: list, 1 items, 5 bytes\n" +
+				"@35                info.files[0].path[0]: string, 1 bytes, \"a\"\n" +
+				"@49          info.pieces: string, 20 bytes, \"aaaaaaaaaaaaaaaaaaaa\"\n" +
+				"\nsummary:\n  piece hashes: 1\n",
+		},
+		{
+			name:  "nested lists",
+			input: "li1eli2eee",
+			expectedOutput: "@0       (root): list, 2 items, 10 bytes\n" +
+				"@1         [0]: int 1\n" +
+				"@4         [1]: list, 1 items, 5 bytes\n" +
+				"@5           [1][0]: int 2\n",
+		},
+		{
+			name:           "binary string",
+			input:          "3:\xff\x00\x01",
+			expectedOutput: "@0       (root): string, 3 bytes, binary ff0001\n",
+		},
+		{
+			name:  "compact peers",
+			input: "d5:peers6:\x0a\x00\x00\x01\x1a\xe1e",
+			expectedOutput: "@0       (root): dict, 1 keys, 17 bytes\n" +
+				"@8         peers: string, 6 bytes, binary 0a0000011ae1\n" +
+				"\nsummary:\n  compact IPv4 peers: 1\n",
+		},
+		{
+			name:           "integer beyond int",
+			input:          "i99999999999999999999999e",
+			expectedOutput: "@0       (root): int 99999999999999999999999\n",
+		},
+		{
+			name:  "raw HTTP tracker response",
+			input: "HTTP/1.1 200 OK\r\nContent-Length: 8\r\n\r\nd1:ai1ee",
+			expectedOutput: "HTTP/1.1 200 OK\n" +
+				"@0       (root): dict, 1 keys, 8 bytes\n" +
+				"@4         a: int 1\n",
+		},
+		{
+			name:  "unterminated dictionary",
+			input: "d1:ai1e",
+			err:   bencode.ErrUnterminatedDictionary,
+		},
+		{
+			name:  "invalid string length",
+			input: "l1x",
+			err:   bencode.ErrInvalidStringLength,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := dumpBencode(strings.NewReader(tc.input))
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if output != tc.expectedOutput {
+				t.Fatalf("expected output %q, got %q", tc.expectedOutput, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/spf13/cobra"
+)
+
+var encodeInputPath string
+var encodeOutputPath string
+
+func init() {
+	encodeCmd.Flags().StringVarP(&encodeInputPath, "file", "f", "", "--file path/to/json_file, - for stdin")
+	encodeCmd.Flags().StringVarP(&encodeOutputPath, "output", "o", "", "--output path/to/output_file, stdout if omitted")
+	rootCmd.AddCommand(encodeCmd)
+}
+
+var encodeCmd = &cobra.Command{
+	Use:   "encode [json_value]",
+	Short: "Encode JSON, as printed by decode, back into bencode",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var jsonInput []byte
+		var err error
+		if encodeInputPath != "" {
+			var input io.ReadCloser
+			input, err = openInput(encodeInputPath)
+			if err == nil {
+				jsonInput, err = io.ReadAll(input)
+				input.Close()
+			}
+		} else if len(args) == 1 {
+			jsonInput = []byte(args[0])
+		} else {
+			err = fmt.Errorf("either a JSON value or --file is required")
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		value, err := bencode.FromJSON(jsonInput)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		var output io.Writer = os.Stdout
+		if encodeOutputPath != "" {
+			file, err := os.Create(encodeOutputPath)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			defer file.Close()
+			output = file
+		}
+
+		if err := bencode.NewEncoder(output).Encode(value); err != nil {
+			fmt.Println(err)
+			return
+		}
+	},
+}
@@ -35,14 +35,14 @@ var handshakeCmd = &cobra.Command{
 		}
 		defer tcpConn.Close()
 
-		torrentSha1Sum := torrentFile.Info.Sha1Sum()
+		infoHash := torrentFile.Info.InfoHash()
 
-		if err := SendHandshake(tcpConn, torrentSha1Sum); err != nil {
+		if err := SendHandshake(tcpConn, infoHash); err != nil {
 			fmt.Println(err.Error())
 			return
 		}
 
-		trackerPeerID, err := ReadHandshakeAck(tcpConn, torrentSha1Sum)
+		trackerPeerID, err := ReadHandshakeAck(tcpConn, infoHash)
 		if err != nil {
 			fmt.Println(err.Error())
 			return
@@ -28,11 +28,23 @@ var infoCmd = &cobra.Command{
 		fmt.Printf("Tracker URL: %s\n", torrent.Announce)
 		fmt.Printf("Length: %d\n", torrent.Info.Length)
 
-		infoSha1Sum := torrent.Info.Sha1Sum()
+		infoHash := torrent.Info.InfoHash()
 
-		fmt.Printf("Info Hash: %s\n", fmt.Sprintf("%x", infoSha1Sum))
+		fmt.Printf("Info Hash: %s\n", fmt.Sprintf("%x", infoHash))
+		if torrent.Info.V2 {
+			fmt.Printf("Info Hash v2: %x\n", torrent.Info.Sha256Sum())
+		}
 		fmt.Printf("Piece Length: %d\n", torrent.Info.PieceLength)
 
-		fmt.Printf("Piece Hashes:\n%s\n", strings.Join(torrent.Info.PieceHashes, "\n"))
+		if torrent.Info.V1 {
+			fmt.Printf("Piece Hashes:\n%s\n", strings.Join(torrent.Info.PieceHashes, "\n"))
+		}
+
+		if torrent.Info.MultiFile {
+			fmt.Printf("Files:\n")
+			for _, f := range torrent.Info.Files {
+				fmt.Printf("%s (%d bytes, %d-%d)\n", f.DisplayPath(), f.Length, f.Offset, f.End())
+			}
+		}
 	},
 }
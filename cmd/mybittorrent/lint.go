@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/spf13/cobra"
+)
+
+var ErrNotCanonical = errors.New("input is not canonical bencode")
+
+func init() {
+	bencodeCmd.AddCommand(lintCmd)
+	rootCmd.AddCommand(bencodeCmd)
+}
+
+var bencodeCmd = &cobra.Command{
+	Use:   "bencode",
+	Short: "Tools for working with bencoded data",
+}
+
+var lintCmd = &cobra.Command{
+	Use:   "lint path/to/file",
+	Short: "Report every deviation from canonical bencode in a file, - for stdin",
+	Args:  cobra.ExactArgs(1),
+	// the error is printed by main, which exits with a non-zero status
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filename := args[0]
+
+		var input io.Reader = os.Stdin
+		if filename != "-" {
+			file, err := os.Open(filename)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			input = file
+		}
+
+		decoder := bencode.NewDecoder(input)
+		var decoded any
+		if err := decoder.Decode(&decoded); err != nil {
+			return err
+		}
+
+		warnings := decoder.Warnings()
+		for _, warning := range warnings {
+			fmt.Println(warning.Error())
+		}
+
+		end := decoder.InputOffset()
+		if _, err := decoder.Token(); err != io.EOF {
+			return fmt.Errorf("bencode: %w at offset %d", bencode.ErrTrailingData, end)
+		}
+
+		if len(warnings) > 0 {
+			return fmt.Errorf("%w: %d deviations", ErrNotCanonical, len(warnings))
+		}
+		fmt.Println("OK")
+		return nil
+	},
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/magnet"
+)
+
+// announced as the bytes left to download while the torrent's length is
+// still unknown; anything above zero marks us as a leecher
+const unknownLeft = 1
+
+var ErrNoPeers = errors.New("no peers found")
+
+func isMagnetLink(arg string) bool {
+	return strings.HasPrefix(strings.ToLower(arg), "magnet:")
+}
+
+// LoadTorrent parses a .torrent file, or resolves a magnet link by fetching
+// the info dictionary from its peers.
+func LoadTorrent(arg string) (*TorrentFile, error) {
+	if !isMagnetLink(arg) {
+		return ParseTorrent(arg)
+	}
+
+	link, err := magnet.Parse(arg)
+	if err != nil {
+		return nil, err
+	}
+	return ResolveMagnet(link)
+}
+
+// ResolveMagnet fetches the metadata of a magnet link from the first peer
+// that provides it.
+func ResolveMagnet(link *magnet.Link) (*TorrentFile, error) {
+	peers, err := GetMagnetPeers(link)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, peer := range peers {
+		rawInfo, err := FetchMetadata(peer, link.InfoHash[:])
+		if err != nil {
+			lastErr = fmt.Errorf("fetch metadata from %s: %w", peer, err)
+			continue
+		}
+
+		info, err := parseTorrentInfo(rawInfo)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		torrent := &TorrentFile{
+			AnnounceList: magnetTrackerTiers(link),
+			Info:         info,
+			Peers:        resolvePeers(peers),
+		}
+		if len(link.Trackers) > 0 {
+			torrent.Announce = link.Trackers[0]
+		}
+		return torrent, nil
+	}
+	return nil, lastErr
+}
+
+// GetMagnetPeers returns the peers listed in a magnet link followed by those
+// its trackers return, without duplicates.
+func GetMagnetPeers(link *magnet.Link) ([]string, error) {
+	peers := []string{}
+	seen := map[string]bool{}
+	addPeers := func(addresses []string) {
+		for _, address := range addresses {
+			if !seen[address] {
+				seen[address] = true
+				peers = append(peers, address)
+			}
+		}
+	}
+
+	addPeers(link.Peers)
+
+	var trackerErr error
+	trackers := NewTrackerTiers("", magnetTrackerTiers(link))
+	if trackers.Len() > 0 {
+		params := &AnnounceParams{InfoHash: link.InfoHash[:], Left: unknownLeft}
+		trackerInfo, err := trackers.Announce(func(announceURL string) (*TrackerInfo, error) {
+			return announce(announceURL, params)
+		})
+		if err == nil {
+			trackerPeers := []string{}
+			for _, peer := range trackerInfo.Peers {
+				trackerPeers = append(trackerPeers, peer.String())
+			}
+			addPeers(trackerPeers)
+		}
+		trackerErr = err
+	}
+
+	if len(peers) == 0 {
+		if trackerErr != nil {
+			return nil, trackerErr
+		}
+		return nil, ErrNoPeers
+	}
+	return peers, nil
+}
+
+// resolvePeers looks up the addresses of peers given as host:port, leaving
+// out those that cannot be resolved.
+func resolvePeers(peers []string) []netip.AddrPort {
+	addrs := []netip.AddrPort{}
+	for _, peer := range peers {
+		addr, err := net.ResolveTCPAddr("tcp", peer)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr.AddrPort())
+	}
+	return addrs
+}
+
+// magnetTrackerTiers puts every tracker of a magnet link in a tier of its
+// own, so that all of them are announced to.
+func magnetTrackerTiers(link *magnet.Link) [][]string {
+	tiers := [][]string{}
+	for _, tracker := range link.Trackers {
+		tiers = append(tiers, []string{tracker})
+	}
+	return tiers
+}
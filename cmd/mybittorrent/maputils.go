@@ -48,3 +48,21 @@ func GetIntValue(m BencodeMap, key string) (int, error) {
 
 	return intValue, nil
 }
+
+// GetOptionalStringValue is GetStringValue for keys that may be missing, in
+// which case it returns "".
+func GetOptionalStringValue(m BencodeMap, key string) (string, error) {
+	if _, keyExists := m[key]; !keyExists {
+		return "", nil
+	}
+	return GetStringValue(m, key)
+}
+
+// GetOptionalIntValue is GetIntValue for keys that may be missing, in which
+// case it returns 0.
+func GetOptionalIntValue(m BencodeMap, key string) (int, error) {
+	if _, keyExists := m[key]; !keyExists {
+		return 0, nil
+	}
+	return GetIntValue(m, key)
+}
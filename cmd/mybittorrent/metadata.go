@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
+)
+
+const (
+	extensionHandshakeID byte = 0
+	// id we ask peers to use when sending us ut_metadata messages
+	localMetadataExtensionID byte = 1
+
+	metadataPieceLength  = 16 << 10
+	maxMetadataSize      = 16 << 20
+	metadataFetchTimeout = 30 * time.Second
+)
+
+// ut_metadata msg_type values (BEP 9)
+const (
+	metadataRequest = iota
+	metadataData
+	metadataReject
+)
+
+var ErrExtensionsUnsupported = errors.New("peer does not support the extension protocol")
+var ErrMetadataUnsupported = errors.New("peer does not support ut_metadata")
+var ErrMetadataRejected = errors.New("peer rejected the metadata request")
+var ErrMetadataHashMismatch = errors.New("metadata does not match the info hash")
+var ErrInvalidMetadataSize = errors.New("invalid metadata size")
+var ErrInvalidMetadataPiece = errors.New("invalid metadata piece")
+
+type extensionHandshake struct {
+	M            map[string]int `bencode:"m"`
+	MetadataSize int            `bencode:"metadata_size,omitempty"`
+}
+
+type metadataMessage struct {
+	MsgType   int `bencode:"msg_type"`
+	Piece     int `bencode:"piece"`
+	TotalSize int `bencode:"total_size,omitempty"`
+}
+
+// FetchMetadata connects to a peer and downloads the info dictionary of the
+// torrent with the given info hash using the ut_metadata extension (BEP 9).
+// The returned bytes are verified to hash to infoHash.
+func FetchMetadata(peerAddress string, infoHash []byte) ([]byte, error) {
+	tcpConn, err := net.DialTimeout("tcp", peerAddress, metadataFetchTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer tcpConn.Close()
+	tcpConn.SetDeadline(time.Now().Add(metadataFetchTimeout))
+
+	if err := SendExtensionHandshake(tcpConn, infoHash); err != nil {
+		return nil, err
+	}
+
+	reserved, _, err := readHandshake(tcpConn, infoHash)
+	if err != nil {
+		return nil, err
+	}
+	if reserved[extensionReservedByte]&extensionReservedBit == 0 {
+		return nil, ErrExtensionsUnsupported
+	}
+
+	return fetchMetadata(tcpConn, infoHash)
+}
+
+// fetchMetadata runs the extension handshake and ut_metadata exchange on a
+// connection that has completed the BitTorrent handshake.
+func fetchMetadata(conn io.ReadWriter, infoHash []byte) ([]byte, error) {
+	handshake, err := bencode.Marshal(extensionHandshake{
+		M: map[string]int{"ut_metadata": int(localMetadataExtensionID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeExtendedMessage(conn, extensionHandshakeID, handshake); err != nil {
+		return nil, err
+	}
+
+	// bitfield and have messages may arrive before the extension handshake
+	payload, err := readExtendedMessage(conn, extensionHandshakeID)
+	if err != nil {
+		return nil, err
+	}
+	var remoteHandshake extensionHandshake
+	if err := bencode.Unmarshal(payload, &remoteHandshake); err != nil {
+		return nil, fmt.Errorf("invalid extension handshake: %w", err)
+	}
+
+	remoteMetadataID := remoteHandshake.M["ut_metadata"]
+	if remoteMetadataID <= 0 || remoteMetadataID > 255 {
+		return nil, ErrMetadataUnsupported
+	}
+	metadataSize := remoteHandshake.MetadataSize
+	if metadataSize <= 0 || metadataSize > maxMetadataSize {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidMetadataSize, metadataSize)
+	}
+
+	numPieces := (metadataSize + metadataPieceLength - 1) / metadataPieceLength
+	for i := 0; i < numPieces; i++ {
+		request, err := bencode.Marshal(metadataMessage{MsgType: metadataRequest, Piece: i})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeExtendedMessage(conn, byte(remoteMetadataID), request); err != nil {
+			return nil, err
+		}
+	}
+
+	metadata := make([]byte, metadataSize)
+	received := make([]bool, numPieces)
+	numReceived := 0
+	for numReceived < numPieces {
+		payload, err := readExtendedMessage(conn, localMetadataExtensionID)
+		if err != nil {
+			return nil, err
+		}
+		message, data, err := parseMetadataMessage(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		switch message.MsgType {
+		case metadataReject:
+			return nil, fmt.Errorf("%w: piece %d", ErrMetadataRejected, message.Piece)
+		case metadataData:
+		default:
+			continue
+		}
+
+		if message.Piece < 0 || message.Piece >= numPieces || received[message.Piece] {
+			return nil, fmt.Errorf("%w: unexpected piece %d", ErrInvalidMetadataPiece, message.Piece)
+		}
+		start := message.Piece * metadataPieceLength
+		end := start + metadataPieceLength
+		if end > metadataSize {
+			end = metadataSize
+		}
+		if len(data) != end-start {
+			return nil, fmt.Errorf("%w: piece %d is %d bytes, expected %d", ErrInvalidMetadataPiece, message.Piece, len(data), end-start)
+		}
+
+		copy(metadata[start:end], data)
+		received[message.Piece] = true
+		numReceived++
+	}
+
+	metadataHash := sha1.Sum(metadata)
+	if !bytes.Equal(metadataHash[:], infoHash) {
+		return nil, fmt.Errorf("%w: got %x", ErrMetadataHashMismatch, metadataHash)
+	}
+	return metadata, nil
+}
+
+// parseMetadataMessage splits a ut_metadata payload into its bencoded
+// dictionary and the piece data that follows it.
+func parseMetadataMessage(payload []byte) (*metadataMessage, []byte, error) {
+	decoder := bencode.NewDecoder(bytes.NewReader(payload))
+	var message metadataMessage
+	if err := decoder.Decode(&message); err != nil {
+		return nil, nil, fmt.Errorf("invalid ut_metadata message: %w", err)
+	}
+	return &message, payload[decoder.InputOffset():], nil
+}
+
+// readExtendedMessage skips messages until an extended message with the given
+// extended id arrives, and returns its payload after the id byte.
+func readExtendedMessage(r io.Reader, extendedID byte) ([]byte, error) {
+	for {
+		message, err := peerwire.ReadMessage(r)
+		if err != nil {
+			return nil, err
+		}
+		if message != nil && message.ID == peerwire.Extended && len(message.Payload) > 0 && message.Payload[0] == extendedID {
+			return message.Payload[1:], nil
+		}
+	}
+}
+
+func writeExtendedMessage(w io.Writer, extendedID byte, payload []byte) error {
+	message := &peerwire.Message{ID: peerwire.Extended, Payload: append([]byte{extendedID}, payload...)}
+	_, err := message.WriteTo(w)
+	return err
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/magnet"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
+)
+
+// fakeMetadataPeer serves metadata over the extension protocol to a single
+// connection.
+type fakeMetadataPeer struct {
+	metadata     []byte
+	noExtensions bool
+	reject       bool
+}
+
+func (p *fakeMetadataPeer) start(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		p.serve(conn)
+	}()
+	return listener.Addr().String()
+}
+
+func (p *fakeMetadataPeer) serve(conn net.Conn) {
+	handshake := make([]byte, 68)
+	if _, err := io.ReadFull(conn, handshake); err != nil {
+		return
+	}
+	if !p.noExtensions {
+		handshake[20+extensionReservedByte] |= extensionReservedBit
+	} else {
+		handshake[20+extensionReservedByte] = 0
+	}
+	conn.Write(handshake)
+	if p.noExtensions {
+		return
+	}
+
+	// a bitfield ahead of the extension handshake must be skipped
+	(&peerwire.Message{ID: peerwire.Bitfield, Payload: []byte{0xff}}).WriteTo(conn)
+
+	payload, err := readExtendedMessage(conn, extensionHandshakeID)
+	if err != nil {
+		return
+	}
+	var clientHandshake extensionHandshake
+	if err := bencode.Unmarshal(payload, &clientHandshake); err != nil {
+		return
+	}
+	clientMetadataID := byte(clientHandshake.M["ut_metadata"])
+
+	handshakePayload, _ := bencode.Marshal(extensionHandshake{
+		M:            map[string]int{"ut_metadata": 3, "ut_pex": 2},
+		MetadataSize: len(p.metadata),
+	})
+	writeExtendedMessage(conn, extensionHandshakeID, handshakePayload)
+
+	for {
+		payload, err := readExtendedMessage(conn, 3)
+		if err != nil {
+			return
+		}
+		request, _, err := parseMetadataMessage(payload)
+		if err != nil {
+			return
+		}
+
+		if p.reject {
+			response, _ := bencode.Marshal(metadataMessage{MsgType: metadataReject, Piece: request.Piece})
+			writeExtendedMessage(conn, clientMetadataID, response)
+			continue
+		}
+
+		start := request.Piece * metadataPieceLength
+		end := start + metadataPieceLength
+		if end > len(p.metadata) {
+			end = len(p.metadata)
+		}
+		response, _ := bencode.Marshal(metadataMessage{MsgType: metadataData, Piece: request.Piece, TotalSize: len(p.metadata)})
+		writeExtendedMessage(conn, clientMetadataID, append(response, p.metadata[start:end]...))
+	}
+}
+
+// bigInfoDict spans two metadata pieces.
+func bigInfoDict(t *testing.T) []byte {
+	info, err := bencode.Marshal(BencodeMap{
+		"name":         "big.bin",
+		"length":       1000 * 16384,
+		"piece length": 16384,
+		"pieces":       strings.Repeat("0123456789abcdefghij", 1000),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(info) <= metadataPieceLength {
+		t.Fatalf("expected metadata longer than one piece, got %d bytes", len(info))
+	}
+	return info
+}
+
+type fetchMetadataTestCase struct {
+	name        string
+	peer        *fakeMetadataPeer
+	infoHash    []byte
+	expectedErr error
+}
+
+func TestFetchMetadata(t *testing.T) {
+	metadata := bigInfoDict(t)
+	infoHash := sha1.Sum(metadata)
+	otherHash := sha1.Sum([]byte("something else"))
+
+	testCases := []*fetchMetadataTestCase{
+		{
+			name:     "two pieces",
+			peer:     &fakeMetadataPeer{metadata: metadata},
+			infoHash: infoHash[:],
+		},
+		{
+			name:        "metadata for another torrent",
+			peer:        &fakeMetadataPeer{metadata: metadata},
+			infoHash:    otherHash[:],
+			expectedErr: ErrMetadataHashMismatch,
+		},
+		{
+			name:        "peer rejects requests",
+			peer:        &fakeMetadataPeer{metadata: metadata, reject: true},
+			infoHash:    infoHash[:],
+			expectedErr: ErrMetadataRejected,
+		},
+		{
+			name:        "peer without the extension protocol",
+			peer:        &fakeMetadataPeer{metadata: metadata, noExtensions: true},
+			infoHash:    infoHash[:],
+			expectedErr: ErrExtensionsUnsupported,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fetched, err := FetchMetadata(tc.peer.start(t), tc.infoHash)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if err == nil && !bytes.Equal(fetched, metadata) {
+				t.Fatalf("fetched metadata differs from what the peer served")
+			}
+		})
+	}
+}
+
+func TestResolveMagnet(t *testing.T) {
+	metadata := bigInfoDict(t)
+	peerAddress := (&fakeMetadataPeer{metadata: metadata}).start(t)
+
+	link, err := magnet.Parse("magnet:?xt=urn:btih:" + strings.Repeat("00", 20) + "&x.pe=" + peerAddress)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	link.InfoHash = sha1.Sum(metadata)
+
+	torrent, err := ResolveMagnet(link)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if torrent.Info.Name != "big.bin" || torrent.Info.NumPieces != 1000 || torrent.Info.Length != 1000*16384 {
+		t.Fatalf("unexpected torrent info %+v", torrent.Info)
+	}
+	if !bytes.Equal(torrent.Info.Sha1Sum(), link.InfoHash[:]) {
+		t.Fatalf("expected info hash %x, got %x", link.InfoHash, torrent.Info.Sha1Sum())
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
+)
+
+const (
+	peerHandshakeTimeout = 10 * time.Second
+	// a keep-alive is due after two minutes without sending anything
+	peerKeepAliveInterval = 2 * time.Minute
+	// peers keeping alive every two minutes are never this quiet
+	peerReadTimeout  = 3 * time.Minute
+	peerWriteTimeout = 30 * time.Second
+	peerSendQueue    = 64
+)
+
+var ErrPeerConnClosed = errors.New("peer connection closed")
+
+// PeerState is the choke and interest state of both ends of a connection.
+// Connections start out choked and not interested on both sides.
+type PeerState struct {
+	AmChoking      bool
+	AmInterested   bool
+	PeerChoking    bool
+	PeerInterested bool
+}
+
+// PeerConn is a connection to a peer that has completed the handshake. Once
+// started, a reader goroutine delivers the peer's messages on Messages and a
+// writer goroutine sends what is passed to Send, along with keep-alives.
+type PeerConn struct {
+	Addr   netip.AddrPort
+	PeerID []byte
+
+	// send a keep-alive when nothing was sent for this long
+	KeepAliveInterval time.Duration
+	// drop the peer when nothing was received for this long
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	conn     net.Conn
+	outgoing chan *peerwire.Message
+	messages chan *peerwire.Message
+	done     chan struct{}
+	once     sync.Once
+
+	mu    sync.Mutex
+	state PeerState
+	err   error
+}
+
+// DialPeer connects to addr and exchanges handshakes. The returned
+// connection still has to be started.
+func DialPeer(addr netip.AddrPort, infoHash []byte) (*PeerConn, error) {
+	conn, err := net.DialTimeout("tcp", addr.String(), peerDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("net dial: %w", err)
+	}
+	peerConn, err := NewPeerConn(conn, infoHash)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	peerConn.Addr = addr
+	return peerConn, nil
+}
+
+// NewPeerConn sends our handshake over conn and reads the peer's.
+func NewPeerConn(conn net.Conn, infoHash []byte) (*PeerConn, error) {
+	conn.SetDeadline(time.Now().Add(peerHandshakeTimeout))
+	if err := SendHandshake(conn, infoHash); err != nil {
+		return nil, fmt.Errorf("send handshake: %w", err)
+	}
+	remotePeerID, err := ReadHandshakeAck(conn, infoHash)
+	if err != nil {
+		return nil, fmt.Errorf("read handshake ack: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	peerConn := &PeerConn{
+		PeerID:            remotePeerID,
+		KeepAliveInterval: peerKeepAliveInterval,
+		ReadTimeout:       peerReadTimeout,
+		WriteTimeout:      peerWriteTimeout,
+		conn:              conn,
+		outgoing:          make(chan *peerwire.Message, peerSendQueue),
+		messages:          make(chan *peerwire.Message),
+		done:              make(chan struct{}),
+		state:             PeerState{AmChoking: true, PeerChoking: true},
+	}
+	if tcpAddr, isTCP := conn.RemoteAddr().(*net.TCPAddr); isTCP {
+		peerConn.Addr = tcpAddr.AddrPort()
+	}
+	return peerConn, nil
+}
+
+// Start runs the reader and writer goroutines until the connection is
+// closed.
+func (p *PeerConn) Start() {
+	go p.readLoop()
+	go p.writeLoop()
+}
+
+// Messages delivers every message but keep-alives, after the state has been
+// updated for it. It is closed once the connection is.
+func (p *PeerConn) Messages() <-chan *peerwire.Message {
+	return p.messages
+}
+
+// Done is closed once the connection is.
+func (p *PeerConn) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err tells why the connection was closed, nil while it is open.
+func (p *PeerConn) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *PeerConn) State() PeerState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// Send queues a message for the writer, blocking while the queue is full.
+func (p *PeerConn) Send(message *peerwire.Message) error {
+	select {
+	case <-p.done:
+		return p.Err()
+	default:
+	}
+
+	p.mu.Lock()
+	switch message.ID {
+	case peerwire.Choke:
+		p.state.AmChoking = true
+	case peerwire.Unchoke:
+		p.state.AmChoking = false
+	case peerwire.Interested:
+		p.state.AmInterested = true
+	case peerwire.NotInterested:
+		p.state.AmInterested = false
+	}
+	p.mu.Unlock()
+
+	select {
+	case p.outgoing <- message:
+		return nil
+	case <-p.done:
+		return p.Err()
+	}
+}
+
+// SetInterested tells the peer whether we want its pieces, unless it already
+// knows.
+func (p *PeerConn) SetInterested(interested bool) error {
+	if p.State().AmInterested == interested {
+		return nil
+	}
+	if interested {
+		return p.Send(&peerwire.Message{ID: peerwire.Interested})
+	}
+	return p.Send(&peerwire.Message{ID: peerwire.NotInterested})
+}
+
+// SetChoking tells the peer whether we serve its requests, unless it already
+// knows.
+func (p *PeerConn) SetChoking(choking bool) error {
+	if p.State().AmChoking == choking {
+		return nil
+	}
+	if choking {
+		return p.Send(&peerwire.Message{ID: peerwire.Choke})
+	}
+	return p.Send(&peerwire.Message{ID: peerwire.Unchoke})
+}
+
+func (p *PeerConn) Close() error {
+	p.closeWithError(ErrPeerConnClosed)
+	return nil
+}
+
+// closeWithError closes the connection, recording the first reason given.
+func (p *PeerConn) closeWithError(err error) {
+	p.once.Do(func() {
+		p.mu.Lock()
+		p.err = err
+		p.mu.Unlock()
+		close(p.done)
+		p.conn.Close()
+	})
+}
+
+func (p *PeerConn) readLoop() {
+	defer close(p.messages)
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(p.ReadTimeout))
+		message, err := peerwire.ReadMessage(p.conn)
+		if err != nil {
+			p.closeWithError(fmt.Errorf("read message: %w", err))
+			return
+		}
+		if message == nil {
+			continue
+		}
+
+		p.mu.Lock()
+		switch message.ID {
+		case peerwire.Choke:
+			p.state.PeerChoking = true
+		case peerwire.Unchoke:
+			p.state.PeerChoking = false
+		case peerwire.Interested:
+			p.state.PeerInterested = true
+		case peerwire.NotInterested:
+			p.state.PeerInterested = false
+		}
+		p.mu.Unlock()
+
+		select {
+		case p.messages <- message:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *PeerConn) writeLoop() {
+	keepAlive := time.NewTimer(p.KeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		// a nil message is a keep-alive
+		var message *peerwire.Message
+		select {
+		case message = <-p.outgoing:
+		case <-keepAlive.C:
+		case <-p.done:
+			return
+		}
+
+		p.conn.SetWriteDeadline(time.Now().Add(p.WriteTimeout))
+		if _, err := message.WriteTo(p.conn); err != nil {
+			p.closeWithError(fmt.Errorf("write %s: %w", message, err))
+			return
+		}
+
+		if !keepAlive.Stop() {
+			select {
+			case <-keepAlive.C:
+			default:
+			}
+		}
+		keepAlive.Reset(p.KeepAliveInterval)
+	}
+}
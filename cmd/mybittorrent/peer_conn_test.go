@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
+)
+
+var testRemotePeerID = []byte("-TS0001-remotepeer01")
+
+// newTestPeerConn returns a handshaken connection along with the remote end
+// of it.
+func newTestPeerConn(t *testing.T) (*PeerConn, net.Conn) {
+	local, remote := net.Pipe()
+	t.Cleanup(func() { remote.Close() })
+
+	go func() {
+		handshake := make([]byte, 68)
+		if _, err := io.ReadFull(remote, handshake); err != nil {
+			return
+		}
+		copy(handshake[48:], testRemotePeerID)
+		remote.Write(handshake)
+	}()
+
+	peer, err := NewPeerConn(local, testInfoHash)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	return peer, remote
+}
+
+func readTestMessage(t *testing.T, conn net.Conn) *peerwire.Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	message, err := peerwire.ReadMessage(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return message
+}
+
+func TestPeerConnHandshake(t *testing.T) {
+	peer, _ := newTestPeerConn(t)
+	if !bytes.Equal(peer.PeerID, testRemotePeerID) {
+		t.Fatalf("expected peer id %q, got %q", testRemotePeerID, peer.PeerID)
+	}
+	if state := peer.State(); state != (PeerState{AmChoking: true, PeerChoking: true}) {
+		t.Fatalf("unexpected initial state %+v", state)
+	}
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go func() {
+		handshake := make([]byte, 68)
+		io.ReadFull(remote, handshake)
+		handshake[28] ^= 0xff
+		remote.Write(handshake)
+	}()
+	if _, err := NewPeerConn(local, testInfoHash); err == nil {
+		t.Fatalf("expected a handshake for another torrent to be refused")
+	}
+}
+
+func TestPeerConnState(t *testing.T) {
+	peer, remote := newTestPeerConn(t)
+	peer.Start()
+
+	go func() {
+		(&peerwire.Message{ID: peerwire.Unchoke}).WriteTo(remote)
+		(*peerwire.Message)(nil).WriteTo(remote)
+		(&peerwire.Message{ID: peerwire.Interested}).WriteTo(remote)
+	}()
+	for _, expected := range []peerwire.MessageID{peerwire.Unchoke, peerwire.Interested} {
+		if message := <-peer.Messages(); message == nil || message.ID != expected {
+			t.Fatalf("expected %s, got %s", expected, message)
+		}
+	}
+	if state := peer.State(); state.PeerChoking || !state.PeerInterested {
+		t.Fatalf("unexpected state %+v", state)
+	}
+
+	// repeating ourselves sends nothing
+	peer.SetInterested(true)
+	peer.SetInterested(true)
+	peer.SetChoking(false)
+	peer.SetInterested(false)
+	for _, expected := range []peerwire.MessageID{peerwire.Interested, peerwire.Unchoke, peerwire.NotInterested} {
+		if message := readTestMessage(t, remote); message == nil || message.ID != expected {
+			t.Fatalf("expected %s, got %s", expected, message)
+		}
+	}
+	if state := peer.State(); state.AmChoking || state.AmInterested {
+		t.Fatalf("unexpected state %+v", state)
+	}
+}
+
+func TestPeerConnKeepAlive(t *testing.T) {
+	peer, remote := newTestPeerConn(t)
+	peer.KeepAliveInterval = 20 * time.Millisecond
+	peer.Start()
+
+	if message := readTestMessage(t, remote); message != nil {
+		t.Fatalf("expected a keep-alive, got %s", message)
+	}
+}
+
+func TestPeerConnReadTimeout(t *testing.T) {
+	peer, _ := newTestPeerConn(t)
+	peer.ReadTimeout = 20 * time.Millisecond
+	peer.Start()
+
+	select {
+	case <-peer.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected a silent peer to be dropped")
+	}
+	if !errors.Is(peer.Err(), os.ErrDeadlineExceeded) {
+		t.Fatalf("expected error %v, got %v", os.ErrDeadlineExceeded, peer.Err())
+	}
+	if _, open := <-peer.Messages(); open {
+		t.Fatalf("expected messages to be closed")
+	}
+}
+
+func TestPeerConnClose(t *testing.T) {
+	peer, _ := newTestPeerConn(t)
+	peer.Start()
+	peer.Close()
+
+	if err := peer.Send(&peerwire.Message{ID: peerwire.Interested}); !errors.Is(err, ErrPeerConnClosed) {
+		t.Fatalf("expected error %v, got %v", ErrPeerConnClosed, err)
+	}
+	if _, open := <-peer.Messages(); open {
+		t.Fatalf("expected messages to be closed")
+	}
+}
@@ -3,13 +3,17 @@ package main
 import (
 	// Uncomment this line to pass the first stage
 
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/magnet"
 	"github.com/spf13/cobra"
 )
 
@@ -18,19 +22,71 @@ func init() {
 }
 
 type TrackerInfo struct {
+	// seeders and leechers, zero when the tracker does not say
 	Complete    int
 	Incomplete  int
 	Interval    int
 	MinInterval int
-	Peers       []string
+	// to be sent back in later announces to the same tracker
+	TrackerID string
+	// warning messages of the trackers that responded; the announce still
+	// succeeded
+	Warnings []string
+	Peers    []netip.AddrPort
 }
 
+// announce events; regular announces carry none
+const (
+	EventNone      = ""
+	EventStarted   = "started"
+	EventCompleted = "completed"
+	EventStopped   = "stopped"
+)
+
+// AnnounceParams is what we tell a tracker about our download of a torrent.
+type AnnounceParams struct {
+	InfoHash   []byte
+	Uploaded   int
+	Downloaded int
+	Left       int
+	Event      string
+	// as handed out by the tracker in an earlier response, if any
+	TrackerID string
+}
+
+var ErrUnsupportedTrackerScheme = errors.New("unsupported tracker URL scheme")
+
+// a tracker that does not respond in time counts as failed, so the next one
+// in its tier gets a turn
+const trackerHTTPTimeout = 30 * time.Second
+
+// trackerHTTPClient is shared by announces and scrapes.
+var trackerHTTPClient = &http.Client{Timeout: trackerHTTPTimeout}
+
 var peersCmd = &cobra.Command{
-	Use:  "peers",
+	Use:  "peers path/to/torrent_file|magnet_link",
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		filename := args[0]
 
+		// a magnet link is enough to announce, no need to fetch the metadata
+		if isMagnetLink(filename) {
+			link, err := magnet.Parse(filename)
+			if err != nil {
+				fmt.Println(err.Error())
+				return
+			}
+
+			peers, err := GetMagnetPeers(link)
+			if err != nil {
+				fmt.Println(err.Error())
+				return
+			}
+
+			fmt.Println(strings.Join(peers, "\n"))
+			return
+		}
+
 		torrent, err := ParseTorrent(filename)
 		if err != nil {
 			fmt.Println(err.Error())
@@ -43,102 +99,77 @@ var peersCmd = &cobra.Command{
 			return
 		}
 
-		fmt.Println(strings.Join(trackerInfo.Peers, "\n"))
+		printWarnings(trackerInfo.Warnings)
+		for _, peer := range trackerInfo.Peers {
+			fmt.Println(peer)
+		}
 	},
 }
 
-func GetTrackerInfo(torrent *TorrentFile) (*TrackerInfo, error) {
-	httpClient := &http.Client{}
-
-	trackerURL, err := url.Parse(torrent.Announce)
-	if err != nil {
-		return nil, fmt.Errorf("invalid tracker URL")
+// printWarnings reports tracker warnings on stderr, leaving stdout to the
+// command's output.
+func printWarnings(warnings []string) {
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "tracker warning: %s\n", warning)
 	}
-	queryParams := url.Values{}
-	queryParams.Add("info_hash", string(torrent.Info.Sha1Sum()))
-	queryParams.Add("peer_id", PeerID)
-	queryParams.Add("port", "6881")
-	queryParams.Add("uploaded", "0")
-	queryParams.Add("downloaded", "0")
-	queryParams.Add("left", strconv.Itoa(torrent.Info.Length))
-	queryParams.Add("compact", "1")
-	trackerURL.RawQuery = queryParams.Encode()
+}
 
-	httpResponse, err := httpClient.Get(trackerURL.String())
-	if err != nil {
-		return nil, fmt.Errorf("http error calling tracker: %s", err.Error())
-	}
-	defer httpResponse.Body.Close()
-	body, err := io.ReadAll(httpResponse.Body)
-	if err != nil {
-		return nil, fmt.Errorf("http error reading tracker response body: %s", err.Error())
+// GetTrackerInfo announces to the torrent's trackers, failing over across
+// tiers, and returns the peers of every tracker that responded.
+func GetTrackerInfo(torrent *TorrentFile) (*TrackerInfo, error) {
+	params := &AnnounceParams{
+		InfoHash: torrent.Info.InfoHash(),
+		Left:     torrent.Info.Length,
 	}
+	return torrent.TrackerTiers().Announce(func(announceURL string) (*TrackerInfo, error) {
+		return announce(announceURL, params)
+	})
+}
 
-	decodedBody, err := DecodeBencode(string(body))
+// announce asks the tracker at announceURL for peers of a torrent, reporting
+// our progress as in params. HTTP or UDP is picked by the URL scheme.
+func announce(announceURL string, params *AnnounceParams) (*TrackerInfo, error) {
+	trackerURL, err := url.Parse(announceURL)
 	if err != nil {
-		return nil, err
-	}
-
-	responseMap, isMap := decodedBody.Output.(BencodeMap)
-	if !isMap {
-		return nil, fmt.Errorf("failed to obtain map from tracker response")
+		return nil, fmt.Errorf("invalid tracker URL")
 	}
 
-	peerURLs, err := parsePeers(responseMap)
-	if err != nil {
-		return nil, err
+	switch trackerURL.Scheme {
+	case "http", "https":
+		return announceHTTP(trackerURL, params)
+	case "udp":
+		return udpTrackerClient.Announce(trackerURL, params)
 	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedTrackerScheme, trackerURL.Scheme)
+}
 
-	complete, err := GetIntValue(responseMap, "complete")
-	if err != nil {
-		return nil, err
+func announceHTTP(trackerURL *url.URL, params *AnnounceParams) (*TrackerInfo, error) {
+	// keep any query the tracker URL comes with, such as a passkey
+	queryParams := trackerURL.Query()
+	queryParams.Add("info_hash", string(params.InfoHash))
+	queryParams.Add("peer_id", PeerID)
+	queryParams.Add("port", "6881")
+	queryParams.Add("uploaded", strconv.Itoa(params.Uploaded))
+	queryParams.Add("downloaded", strconv.Itoa(params.Downloaded))
+	queryParams.Add("left", strconv.Itoa(params.Left))
+	queryParams.Add("compact", "1")
+	if params.Event != EventNone {
+		queryParams.Add("event", params.Event)
 	}
-
-	incomplete, err := GetIntValue(responseMap, "incomplete")
-	if err != nil {
-		return nil, err
+	if params.TrackerID != "" {
+		queryParams.Add("trackerid", params.TrackerID)
 	}
+	trackerURL.RawQuery = queryParams.Encode()
 
-	interval, err := GetIntValue(responseMap, "interval")
+	httpResponse, err := trackerHTTPClient.Get(trackerURL.String())
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("http error calling tracker: %s", err.Error())
 	}
+	defer httpResponse.Body.Close()
 
-	minInterval, err := GetIntValue(responseMap, "min interval")
+	responseMap, err := decodeTrackerResponse(httpResponse.Body)
 	if err != nil {
 		return nil, err
 	}
-
-	return &TrackerInfo{
-		Complete:    complete,
-		Incomplete:  incomplete,
-		Interval:    interval,
-		MinInterval: minInterval,
-		Peers:       peerURLs,
-	}, nil
-}
-
-func parsePeers(trackerResponseMap BencodeMap) ([]string, error) {
-	peerListString, err := GetStringValue(trackerResponseMap, "peers")
-	if err != nil {
-		return nil, fmt.Errorf("failed to read peer list as string")
-	}
-
-	peerListBytes := []byte(peerListString)
-	if len(peerListBytes)%6 != 0 {
-		return nil, fmt.Errorf("invalid compact peer list length")
-	}
-
-	numPeers := len(peerListBytes) / 6
-	peerURLs := []string{}
-	for i := 0; i < numPeers; i++ {
-		ipParts := []string{}
-		for b := i * 6; b < (i*6)+4; b++ {
-			ipParts = append(ipParts, strconv.Itoa(int(peerListBytes[b])))
-		}
-		port := 256*int(peerListBytes[i*6+4]) + int(peerListBytes[i*6+5])
-		peerURLs = append(peerURLs, strings.Join(ipParts, ".")+":"+strconv.Itoa(port))
-	}
-
-	return peerURLs, nil
+	return parseTrackerResponse(responseMap)
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math/rand"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
+)
+
+type PiecePriority int
+
+const (
+	PrioritySkip PiecePriority = iota
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+)
+
+// until this many pieces are complete pieces are picked at random: a rare
+// piece takes longest to get, and a first piece is needed soon to have
+// something to offer
+const randomFirstPieces = 4
+
+// PiecePicker decides which piece to download next. It counts how many of
+// the connected peers have every piece, and picks the rarest of the pieces
+// with the highest priority, breaking ties at random. It is not safe for
+// concurrent use.
+type PiecePicker struct {
+	availability []int
+	priorities   []PiecePriority
+	have         peerwire.PieceBitfield
+	numHave      int
+	rand         *rand.Rand
+}
+
+func NewPiecePicker(numPieces int) *PiecePicker {
+	priorities := make([]PiecePriority, numPieces)
+	for i := range priorities {
+		priorities[i] = PriorityNormal
+	}
+	return &PiecePicker{
+		availability: make([]int, numPieces),
+		priorities:   priorities,
+		have:         peerwire.NewPieceBitfield(numPieces),
+		rand:         rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// AddBitfield counts the pieces of a peer that connected.
+func (p *PiecePicker) AddBitfield(bitfield peerwire.PieceBitfield) {
+	for index := range p.availability {
+		if bitfield.Has(index) {
+			p.availability[index]++
+		}
+	}
+}
+
+// RemoveBitfield stops counting the pieces of a peer that went away.
+func (p *PiecePicker) RemoveBitfield(bitfield peerwire.PieceBitfield) {
+	for index := range p.availability {
+		if bitfield.Has(index) && p.availability[index] > 0 {
+			p.availability[index]--
+		}
+	}
+}
+
+// AddHave counts a piece a peer announced it got.
+func (p *PiecePicker) AddHave(index int) {
+	if index >= 0 && index < len(p.availability) {
+		p.availability[index]++
+	}
+}
+
+func (p *PiecePicker) SetPriority(index int, priority PiecePriority) {
+	if index >= 0 && index < len(p.priorities) {
+		p.priorities[index] = priority
+	}
+}
+
+// MarkHave records a piece we have completed, so it is not picked again.
+func (p *PiecePicker) MarkHave(index int) {
+	if !p.have.Has(index) {
+		p.have.Set(index)
+		p.numHave++
+	}
+}
+
+func (p *PiecePicker) Has(index int) bool {
+	return p.have.Has(index)
+}
+
+// Wanted reports whether a piece is yet to be downloaded.
+func (p *PiecePicker) Wanted(index int) bool {
+	return !p.have.Has(index) && p.priorities[index] != PrioritySkip
+}
+
+// Done reports whether every piece not skipped has been completed.
+func (p *PiecePicker) Done() bool {
+	for index := range p.priorities {
+		if p.Wanted(index) {
+			return false
+		}
+	}
+	return true
+}
+
+// Pick returns the piece to download next from a peer with the given
+// bitfield, leaving out pieces for which exclude returns true, such as those
+// already under way. It returns false when the peer has nothing we want.
+func (p *PiecePicker) Pick(bitfield peerwire.PieceBitfield, exclude func(index int) bool) (int, bool) {
+	random := p.numHave < randomFirstPieces
+
+	picked := -1
+	numTied := 0
+	for index := range p.priorities {
+		if !p.Wanted(index) || !bitfield.Has(index) || exclude(index) {
+			continue
+		}
+		comparison := 1
+		if picked >= 0 {
+			comparison = p.compare(index, picked, random)
+		}
+		switch {
+		case comparison > 0:
+			picked = index
+			numTied = 1
+		case comparison == 0:
+			// every one of the tied pieces ends up picked with the same
+			// probability
+			numTied++
+			if p.rand.Intn(numTied) == 0 {
+				picked = index
+			}
+		}
+	}
+	return picked, picked >= 0
+}
+
+// compare returns a positive number when piece a is a better pick than piece
+// b, a negative one when it is worse and 0 for a tie.
+func (p *PiecePicker) compare(a int, b int, random bool) int {
+	if p.priorities[a] != p.priorities[b] {
+		return int(p.priorities[a] - p.priorities[b])
+	}
+	if random {
+		return 0
+	}
+	return p.availability[b] - p.availability[a]
+}
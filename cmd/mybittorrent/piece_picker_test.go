@@ -0,0 +1,153 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
+)
+
+const testNumPieces = 10
+
+func testBitfield(pieces []int) peerwire.PieceBitfield {
+	bitfield := peerwire.NewPieceBitfield(testNumPieces)
+	for _, index := range pieces {
+		bitfield.Set(index)
+	}
+	return bitfield
+}
+
+var allTestPieces = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+
+type piecePickerTestCase struct {
+	name string
+	// pieces we have completed
+	have []int
+	// pieces of the connected peers, and of those that went away again
+	peers [][]int
+	gone  [][]int
+	// pieces of the peer picked for, all of them if nil
+	bitfield   []int
+	priorities map[int]PiecePriority
+	exclude    []int
+	// every piece that may be picked, each of which must be at some point
+	expected []int
+}
+
+func TestPiecePicker(t *testing.T) {
+	firstPieces := []int{0, 1, 2, 3}
+	testCases := []*piecePickerTestCase{
+		{
+			name:     "rarest first",
+			have:     firstPieces,
+			peers:    [][]int{allTestPieces, {4, 5, 6, 7, 8}, {4, 5, 6, 7}},
+			expected: []int{9},
+		},
+		{
+			name:     "rarest tie broken at random",
+			have:     firstPieces,
+			peers:    [][]int{allTestPieces, {4, 5, 6, 7}},
+			expected: []int{8, 9},
+		},
+		{
+			name:     "pieces under way left out",
+			have:     firstPieces,
+			peers:    [][]int{allTestPieces, {4, 5, 6, 7, 8}, {4, 5, 6, 7}},
+			exclude:  []int{9},
+			expected: []int{8},
+		},
+		{
+			name:     "pieces of departed peers not counted",
+			have:     firstPieces,
+			peers:    [][]int{allTestPieces, {9}, {9}},
+			gone:     [][]int{{9}, {9}},
+			expected: []int{4, 5, 6, 7, 8, 9},
+		},
+		{
+			name:       "priority before rarity",
+			have:       firstPieces,
+			peers:      [][]int{allTestPieces, {4, 5, 6, 7, 8}, {4, 5, 6, 7}},
+			priorities: map[int]PiecePriority{5: PriorityHigh, 9: PriorityLow},
+			expected:   []int{5},
+		},
+		{
+			name:       "skipped pieces never picked",
+			have:       firstPieces,
+			peers:      [][]int{allTestPieces, {4, 5, 6, 7, 8}, {4, 5, 6, 7}},
+			priorities: map[int]PiecePriority{8: PrioritySkip, 9: PrioritySkip},
+			expected:   []int{4, 5, 6, 7},
+		},
+		{
+			name:     "random first pieces",
+			peers:    [][]int{allTestPieces, {0, 1, 2, 3, 4, 5, 6, 7, 8}},
+			expected: allTestPieces,
+		},
+		{
+			name:     "only pieces the peer has",
+			have:     firstPieces,
+			peers:    [][]int{allTestPieces, {9}},
+			bitfield: []int{0, 1, 4, 5},
+			expected: []int{4, 5},
+		},
+		{
+			name:     "nothing wanted",
+			have:     firstPieces,
+			peers:    [][]int{allTestPieces},
+			bitfield: firstPieces,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			picker := NewPiecePicker(testNumPieces)
+			picker.rand = rand.New(rand.NewSource(1))
+			for _, index := range tc.have {
+				picker.MarkHave(index)
+			}
+			for _, pieces := range tc.peers {
+				picker.AddBitfield(testBitfield(pieces))
+			}
+			for _, pieces := range tc.gone {
+				picker.RemoveBitfield(testBitfield(pieces))
+			}
+			for index, priority := range tc.priorities {
+				picker.SetPriority(index, priority)
+			}
+			bitfield := testBitfield(allTestPieces)
+			if tc.bitfield != nil {
+				bitfield = testBitfield(tc.bitfield)
+			}
+			excluded := testBitfield(tc.exclude)
+
+			picked := map[int]bool{}
+			for i := 0; i < 200; i++ {
+				index, found := picker.Pick(bitfield, excluded.Has)
+				if found {
+					picked[index] = true
+				}
+			}
+			pickedPieces := []int{}
+			for index := range picked {
+				pickedPieces = append(pickedPieces, index)
+			}
+			slices.Sort(pickedPieces)
+			if !slices.Equal(pickedPieces, tc.expected) {
+				t.Fatalf("expected picks among %v, got %v", tc.expected, pickedPieces)
+			}
+		})
+	}
+}
+
+func TestPiecePickerDone(t *testing.T) {
+	picker := NewPiecePicker(3)
+	picker.SetPriority(2, PrioritySkip)
+	picker.MarkHave(0)
+	if picker.Done() {
+		t.Fatalf("expected piece 1 to be wanted still")
+	}
+	picker.MarkHave(1)
+	if !picker.Done() {
+		t.Fatalf("expected the skipped piece not to be waited for")
+	}
+}
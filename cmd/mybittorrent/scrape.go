@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/magnet"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(scrapeCmd)
+}
+
+var ErrScrapeUnsupported = errors.New("tracker does not support scraping")
+
+// ScrapeInfo is a tracker's view of the swarm of one torrent.
+type ScrapeInfo struct {
+	// seeders
+	Complete int
+	// leechers
+	Incomplete int
+	// number of times the torrent has been downloaded
+	Downloaded int
+}
+
+// scrapeTarget is a torrent to scrape, with the trackers that may know it.
+type scrapeTarget struct {
+	name     string
+	infoHash []byte
+	trackers *TrackerTiers
+}
+
+var scrapeCmd = &cobra.Command{
+	Use:  "scrape path/to/torrent_file|magnet_link...",
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		targets := []*scrapeTarget{}
+		for _, arg := range args {
+			target, err := loadScrapeTarget(arg)
+			if err != nil {
+				fmt.Println(err.Error())
+				return
+			}
+			targets = append(targets, target)
+		}
+
+		scrapes, errs := ScrapeTorrents(targets)
+		for i, target := range targets {
+			fmt.Printf("%s (%x)\n", target.name, target.infoHash)
+			if errs[i] != nil {
+				fmt.Println(errs[i].Error())
+				continue
+			}
+			fmt.Printf("Seeders: %d\n", scrapes[i].Complete)
+			fmt.Printf("Leechers: %d\n", scrapes[i].Incomplete)
+			fmt.Printf("Completed: %d\n", scrapes[i].Downloaded)
+		}
+	},
+}
+
+// loadScrapeTarget reads the info hash and trackers of a .torrent file or
+// magnet link, without fetching any metadata.
+func loadScrapeTarget(arg string) (*scrapeTarget, error) {
+	if isMagnetLink(arg) {
+		link, err := magnet.Parse(arg)
+		if err != nil {
+			return nil, err
+		}
+		name := link.DisplayName
+		if name == "" {
+			name = link.HexInfoHash()
+		}
+		return &scrapeTarget{
+			name:     name,
+			infoHash: link.InfoHash[:],
+			trackers: NewTrackerTiers("", magnetTrackerTiers(link)),
+		}, nil
+	}
+
+	torrent, err := ParseTorrent(arg)
+	if err != nil {
+		return nil, err
+	}
+	return &scrapeTarget{
+		name:     torrent.Info.Name,
+		infoHash: torrent.Info.InfoHash(),
+		trackers: torrent.Trackers,
+	}, nil
+}
+
+// ScrapeTorrents asks the trackers of every target for its stats, trying
+// trackers in tier order until one answers. Targets that share a tracker are
+// scraped with a single request. The error of a target is set only when
+// none of its trackers answered.
+func ScrapeTorrents(targets []*scrapeTarget) ([]*ScrapeInfo, []error) {
+	scrapes := make([]*ScrapeInfo, len(targets))
+	targetErrs := make([][]error, len(targets))
+	scraped := map[string]bool{}
+
+	for i, target := range targets {
+		for _, tier := range target.trackers.Tiers() {
+			for _, trackerURL := range tier {
+				if scrapes[i] != nil || scraped[trackerURL] {
+					continue
+				}
+				scraped[trackerURL] = true
+
+				// every target still waiting that this tracker may know
+				batch := []int{}
+				infoHashes := [][]byte{}
+				for j := i; j < len(targets); j++ {
+					if scrapes[j] == nil && hasTracker(targets[j].trackers, trackerURL) {
+						batch = append(batch, j)
+						infoHashes = append(infoHashes, targets[j].infoHash)
+					}
+				}
+
+				batchScrapes, err := scrape(trackerURL, infoHashes)
+				for k, j := range batch {
+					if err != nil {
+						targetErrs[j] = append(targetErrs[j], fmt.Errorf("%s: %w", trackerURL, err))
+						continue
+					}
+					scrapes[j] = batchScrapes[k]
+				}
+			}
+		}
+	}
+
+	errs := make([]error, len(targets))
+	for i := range targets {
+		if scrapes[i] != nil {
+			continue
+		}
+		if len(targetErrs[i]) == 0 {
+			errs[i] = ErrNoTrackers
+			continue
+		}
+		errs[i] = fmt.Errorf("all %d trackers failed: %w", len(targetErrs[i]), errors.Join(targetErrs[i]...))
+	}
+	return scrapes, errs
+}
+
+func hasTracker(trackers *TrackerTiers, trackerURL string) bool {
+	for _, tier := range trackers.Tiers() {
+		for _, url := range tier {
+			if url == trackerURL {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scrape asks the tracker with the given announce URL for the stats of
+// several torrents, returned in the order of infoHashes. HTTP or UDP is
+// picked by the URL scheme.
+func scrape(announceURL string, infoHashes [][]byte) ([]*ScrapeInfo, error) {
+	trackerURL, err := url.Parse(announceURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracker URL")
+	}
+
+	switch trackerURL.Scheme {
+	case "http", "https":
+		scrapeURL, err := ScrapeURL(trackerURL)
+		if err != nil {
+			return nil, err
+		}
+		return scrapeHTTP(scrapeURL, infoHashes)
+	case "udp":
+		return udpTrackerClient.Scrape(trackerURL, infoHashes)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedTrackerScheme, trackerURL.Scheme)
+}
+
+// ScrapeURL derives the scrape URL of an HTTP tracker from its announce URL
+// by replacing "announce" at the start of the last path segment with
+// "scrape". Trackers whose announce URL has no such segment cannot be
+// scraped.
+func ScrapeURL(announceURL *url.URL) (*url.URL, error) {
+	dir, last := path.Split(announceURL.Path)
+	if !strings.HasPrefix(last, "announce") {
+		return nil, fmt.Errorf("%w: %s", ErrScrapeUnsupported, announceURL.Redacted())
+	}
+
+	scrapeURL := *announceURL
+	scrapeURL.Path = dir + "scrape" + strings.TrimPrefix(last, "announce")
+	scrapeURL.RawPath = ""
+	return &scrapeURL, nil
+}
+
+func scrapeHTTP(scrapeURL *url.URL, infoHashes [][]byte) ([]*ScrapeInfo, error) {
+	queryParams := scrapeURL.Query()
+	for _, infoHash := range infoHashes {
+		queryParams.Add("info_hash", string(infoHash))
+	}
+	requestURL := *scrapeURL
+	requestURL.RawQuery = queryParams.Encode()
+
+	httpResponse, err := trackerHTTPClient.Get(requestURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("http error calling tracker: %s", err.Error())
+	}
+	defer httpResponse.Body.Close()
+
+	responseMap, err := decodeTrackerResponse(httpResponse.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := GetMapValue(responseMap, "files")
+	if err != nil {
+		return nil, err
+	}
+
+	scrapes := []*ScrapeInfo{}
+	for _, infoHash := range infoHashes {
+		// a tracker leaves out the torrents it does not know
+		scrapeInfo := &ScrapeInfo{}
+		if fileMap, found := files[string(infoHash)].(BencodeMap); found {
+			if scrapeInfo, err = parseScrapeInfo(fileMap); err != nil {
+				return nil, err
+			}
+		}
+		scrapes = append(scrapes, scrapeInfo)
+	}
+	return scrapes, nil
+}
+
+func parseScrapeInfo(fileMap BencodeMap) (*ScrapeInfo, error) {
+	complete, err := GetOptionalIntValue(fileMap, "complete")
+	if err != nil {
+		return nil, err
+	}
+
+	incomplete, err := GetOptionalIntValue(fileMap, "incomplete")
+	if err != nil {
+		return nil, err
+	}
+
+	downloaded, err := GetOptionalIntValue(fileMap, "downloaded")
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScrapeInfo{
+		Complete:   complete,
+		Incomplete: incomplete,
+		Downloaded: downloaded,
+	}, nil
+}
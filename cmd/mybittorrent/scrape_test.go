@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+type scrapeURLTestCase struct {
+	announceURL string
+	expected    string
+	expectedErr error
+}
+
+func TestScrapeURL(t *testing.T) {
+	testCases := []*scrapeURLTestCase{
+		{announceURL: "http://example.com/announce", expected: "http://example.com/scrape"},
+		{announceURL: "http://example.com/x/announce", expected: "http://example.com/x/scrape"},
+		{announceURL: "http://example.com/announce.php", expected: "http://example.com/scrape.php"},
+		{announceURL: "http://example.com/announce?passkey=abc", expected: "http://example.com/scrape?passkey=abc"},
+		{announceURL: "http://example.com/a", expectedErr: ErrScrapeUnsupported},
+		{announceURL: "http://example.com/announce/x", expectedErr: ErrScrapeUnsupported},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.announceURL, func(t *testing.T) {
+			announceURL, err := url.Parse(tc.announceURL)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			scrapeURL, err := ScrapeURL(announceURL)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if scrapeURL.String() != tc.expected {
+				t.Fatalf("expected %s, got %s", tc.expected, scrapeURL)
+			}
+		})
+	}
+}
+
+// fakeHTTPScrapeTracker knows the torrents in files and records the info
+// hashes of every scrape request.
+func fakeHTTPScrapeTracker(t *testing.T, files bencode.Dict, requested *[][]string) *httptest.Server {
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scrape" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		*requested = append(*requested, r.URL.Query()["info_hash"])
+		mu.Unlock()
+
+		body, err := bencode.Marshal(bencode.Dict{"files": files})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestScrapeHTTP(t *testing.T) {
+	known := bytes.Repeat([]byte{1}, 20)
+	unknown := bytes.Repeat([]byte{2}, 20)
+	files := bencode.Dict{
+		string(known): bencode.Dict{"complete": 5, "incomplete": 3, "downloaded": 50},
+	}
+	requested := [][]string{}
+	server := fakeHTTPScrapeTracker(t, files, &requested)
+
+	scrapes, err := scrape(server.URL+"/announce", [][]byte{known, unknown})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []*ScrapeInfo{{Complete: 5, Incomplete: 3, Downloaded: 50}, {}}
+	if !reflect.DeepEqual(scrapes, expected) {
+		t.Fatalf("expected %v, got %v", expected, scrapes)
+	}
+	if !reflect.DeepEqual(requested, [][]string{{string(known), string(unknown)}}) {
+		t.Fatalf("expected both info hashes in one request, got %q", requested)
+	}
+}
+
+func TestScrapeHTTPFailureReason(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d14:failure reason9:forbiddene"))
+	}))
+	defer server.Close()
+
+	if _, err := scrape(server.URL+"/announce", [][]byte{testInfoHash}); !errors.Is(err, ErrTrackerFailure) {
+		t.Fatalf("expected error %v, got %v", ErrTrackerFailure, err)
+	}
+}
+
+func TestScrapeTorrents(t *testing.T) {
+	first := bytes.Repeat([]byte{1}, 20)
+	second := bytes.Repeat([]byte{2}, 20)
+	third := bytes.Repeat([]byte{3}, 20)
+	files := bencode.Dict{
+		string(first):  bencode.Dict{"complete": 1, "incomplete": 0, "downloaded": 10},
+		string(second): bencode.Dict{"complete": 2, "incomplete": 0, "downloaded": 20},
+	}
+	requested := [][]string{}
+	server := fakeHTTPScrapeTracker(t, files, &requested)
+
+	udpTracker := newFakeUDPTracker(t)
+	udpTrackerClient = newTestUDPTrackerClient()
+	defer func() { udpTrackerClient = NewUDPTrackerClient() }()
+
+	httpURL := server.URL + "/announce"
+	deadURL := "http://127.0.0.1:1/announce"
+	targets := []*scrapeTarget{
+		{infoHash: first, trackers: NewTrackerTiers(httpURL, nil)},
+		{infoHash: second, trackers: NewTrackerTiers("", [][]string{{httpURL}, {deadURL}})},
+		// the dead tracker fails, so the UDP tracker of the next tier answers
+		{infoHash: third, trackers: NewTrackerTiers("", [][]string{{deadURL}, {udpTracker.url().String()}})},
+		{infoHash: third, trackers: NewTrackerTiers("", [][]string{{deadURL}})},
+	}
+
+	scrapes, errs := ScrapeTorrents(targets)
+	expected := []*ScrapeInfo{
+		{Complete: 1, Downloaded: 10},
+		{Complete: 2, Downloaded: 20},
+		{Complete: 3, Downloaded: 30, Incomplete: 4},
+		nil,
+	}
+	if !reflect.DeepEqual(scrapes, expected) {
+		t.Fatalf("expected %v, got %v", expected, scrapes)
+	}
+	for i := 0; i < 3; i++ {
+		if errs[i] != nil {
+			t.Fatalf("unexpected error for target %d: %s", i, errs[i])
+		}
+	}
+	if errs[3] == nil {
+		t.Fatalf("expected an error when every tracker fails")
+	}
+	if len(requested) != 1 || len(requested[0]) != 2 {
+		t.Fatalf("expected the targets sharing a tracker to be scraped at once, got %q", requested)
+	}
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultMaxPeers = 30
+	// block requests kept in flight to every peer
+	defaultRequestQueue = 10
+	// peers that send none of the blocks we asked for for this long are
+	// dropped, making room for others
+	peerRequestTimeout = time.Minute
+	// dropped peers are tried again after this long, doubling with every
+	// time they are dropped without having sent a block
+	peerRetryBackoff = 30 * time.Second
+	maxPeerRetries   = 5
+)
+
+var ErrInvalidBitfield = errors.New("invalid bitfield")
+var ErrInvalidHave = errors.New("have for a piece the torrent does not have")
+var ErrPeerStalled = errors.New("peer sent no blocks in time")
+
+// Swarm downloads a torrent from many peers at once. Every connected peer is
+// asked for blocks of the pieces its bitfield says it has; the blocks it was
+// asked for go back to the others when it chokes us or goes away, and a new
+// peer takes its place. Peers that went away are tried again later.
+type Swarm struct {
+	MaxPeers       int
+	RequestQueue   int
+	RequestTimeout time.Duration
+	RetryBackoff   time.Duration
+
+	info  *TorrentInfo
+	store *storage.Storage
+	stats *TransferStats
+	// swapped out in tests
+	dial func(addr netip.AddrPort, infoHash []byte) (*PeerConn, error)
+
+	mu     sync.Mutex
+	picker *PiecePicker
+	// connected peers, whose wanted counts follow the pieces we still want
+	peers map[*swarmPeer]bool
+	// pieces being downloaded, by index
+	active map[int]*pieceDownload
+	// closed and replaced when blocks become free to request, waking the
+	// peers that had nothing left to ask for
+	wake     chan struct{}
+	complete chan struct{}
+	failed   chan struct{}
+	err      error
+}
+
+type pieceDownload struct {
+	data []byte
+	// peer every block was requested from, nil while it is not requested
+	requested   []*swarmPeer
+	received    []bool
+	numReceived int
+}
+
+type swarmPeer struct {
+	conn     *PeerConn
+	bitfield peerwire.PieceBitfield
+	// requests in flight, guarded by Swarm.mu
+	inflight map[blockRequest]bool
+	// pieces the peer has that we still want, guarded by Swarm.mu
+	wanted int
+}
+
+type peerExit struct {
+	addr netip.AddrPort
+	err  error
+	// whether the peer sent any block we asked for
+	delivered bool
+}
+
+type blockRequest struct {
+	index  int
+	begin  int
+	length int
+}
+
+func NewSwarm(info *TorrentInfo, store *storage.Storage, stats *TransferStats) *Swarm {
+	s := &Swarm{
+		MaxPeers:       defaultMaxPeers,
+		RequestQueue:   defaultRequestQueue,
+		RequestTimeout: peerRequestTimeout,
+		RetryBackoff:   peerRetryBackoff,
+		info:           info,
+		store:          store,
+		stats:          stats,
+		dial:           DialPeer,
+		picker:         NewPiecePicker(info.NumPieces),
+		peers:          map[*swarmPeer]bool{},
+		active:         map[int]*pieceDownload{},
+		wake:           make(chan struct{}),
+		complete:       make(chan struct{}),
+		failed:         make(chan struct{}),
+	}
+	return s
+}
+
+// SetPriority changes the priority of a piece, PrioritySkip leaving it out
+// of the download.
+func (s *Swarm) SetPriority(index int, priority PiecePriority) {
+	if index < 0 || index >= s.info.NumPieces {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changeWanted(index, func() { s.picker.SetPriority(index, priority) })
+	s.checkComplete()
+}
+
+// Run connects to the peers sent on peers, up to MaxPeers at a time, until
+// every piece has been downloaded and verified. A peer that goes away is
+// tried again after RetryBackoff, up to maxPeerRetries times in a row
+// without sending a block. Run gives up when no peer could be connected to
+// for a while.
+func (s *Swarm) Run(ctx context.Context, peers <-chan []netip.AddrPort) error {
+	s.mu.Lock()
+	s.checkComplete()
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	candidates := []netip.AddrPort{}
+	seen := map[netip.AddrPort]bool{}
+	// times every peer was dropped since it last sent a block
+	failures := map[netip.AddrPort]int{}
+	exited := make(chan peerExit)
+	retry := make(chan netip.AddrPort)
+	numPeers := 0
+	numRetrying := 0
+	var lastErr error
+	var idle <-chan time.Time
+
+	for {
+		for numPeers < s.MaxPeers && len(candidates) > 0 {
+			addr := candidates[0]
+			candidates = candidates[1:]
+			numPeers++
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				delivered, err := s.runPeer(ctx, addr)
+				select {
+				case exited <- peerExit{addr: addr, err: err, delivered: delivered}:
+				case <-ctx.Done():
+				}
+			}()
+		}
+
+		if numPeers > 0 || len(candidates) > 0 {
+			idle = nil
+		} else if peers == nil && numRetrying == 0 {
+			return errors.Join(ErrNoPeers, lastErr)
+		} else if idle == nil {
+			idle = time.After(peerSearchTimeout)
+		}
+
+		select {
+		case <-s.complete:
+			return nil
+		case <-s.failed:
+			return s.err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-idle:
+			return errors.Join(ErrNoPeers, lastErr)
+		case batch, open := <-peers:
+			if !open {
+				peers = nil
+				continue
+			}
+			rand.Shuffle(len(batch), func(i, j int) {
+				batch[i], batch[j] = batch[j], batch[i]
+			})
+			for _, addr := range batch {
+				if !seen[addr] {
+					seen[addr] = true
+					candidates = append(candidates, addr)
+				}
+			}
+		case exit := <-exited:
+			numPeers--
+			if exit.err != nil {
+				log.Debug().Msgf("dropped peer: %s", exit.err)
+				lastErr = exit.err
+			}
+			if exit.delivered {
+				failures[exit.addr] = 0
+			}
+			failures[exit.addr]++
+			if failures[exit.addr] > maxPeerRetries {
+				continue
+			}
+			numRetrying++
+			backoff := s.RetryBackoff << (failures[exit.addr] - 1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				timer := time.NewTimer(backoff)
+				defer timer.Stop()
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					return
+				}
+				select {
+				case retry <- exit.addr:
+				case <-ctx.Done():
+				}
+			}()
+		case addr := <-retry:
+			numRetrying--
+			candidates = append(candidates, addr)
+		}
+	}
+}
+
+// runPeer downloads from a single peer until it goes away or ctx is done,
+// and reports whether the peer sent any block.
+func (s *Swarm) runPeer(ctx context.Context, addr netip.AddrPort) (bool, error) {
+	conn, err := s.dial(addr, s.info.InfoHash())
+	if err != nil {
+		return false, err
+	}
+	conn.Start()
+	defer conn.Close()
+
+	peer := &swarmPeer{
+		conn:     conn,
+		bitfield: peerwire.NewPieceBitfield(s.info.NumPieces),
+		inflight: map[blockRequest]bool{},
+	}
+	s.mu.Lock()
+	s.peers[peer] = true
+	s.mu.Unlock()
+	defer s.dropPeer(peer)
+
+	ticker := time.NewTicker(s.RequestTimeout / 4)
+	defer ticker.Stop()
+	// a peer only stalls while it has requests to answer; one that chokes
+	// us or has nothing we want may stay
+	lastBlock := time.Now()
+	delivered := false
+	for {
+		select {
+		case <-ctx.Done():
+			return delivered, nil
+		case <-ticker.C:
+			if !s.waitingFor(peer) {
+				lastBlock = time.Now()
+			} else if time.Since(lastBlock) > s.RequestTimeout {
+				return delivered, fmt.Errorf("%s: %w", addr, ErrPeerStalled)
+			}
+		case <-s.wakeChannel():
+			if err := s.update(peer); err != nil {
+				return delivered, fmt.Errorf("%s: %w", addr, err)
+			}
+		case message, open := <-conn.Messages():
+			if !open {
+				return delivered, fmt.Errorf("%s: %w", addr, conn.Err())
+			}
+			if message.ID == peerwire.Piece {
+				lastBlock = time.Now()
+				delivered = true
+			}
+			if err := s.handleMessage(peer, message); err != nil {
+				return delivered, fmt.Errorf("%s: %w", addr, err)
+			}
+		}
+	}
+}
+
+// waitingFor reports whether the peer has requests in flight.
+func (s *Swarm) waitingFor(peer *swarmPeer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(peer.inflight) > 0
+}
+
+func (s *Swarm) handleMessage(peer *swarmPeer, message *peerwire.Message) error {
+	switch message.ID {
+	case peerwire.Bitfield:
+		bitfield := peerwire.PieceBitfield(message.Payload)
+		if !bitfield.Valid(s.info.NumPieces) {
+			return fmt.Errorf("%w: %d bytes for %d pieces", ErrInvalidBitfield, len(bitfield), s.info.NumPieces)
+		}
+		s.setBitfield(peer, bitfield)
+	case peerwire.Have:
+		index := int(message.Index)
+		if index >= s.info.NumPieces {
+			return fmt.Errorf("%w: %d", ErrInvalidHave, index)
+		}
+		s.addHave(peer, index)
+	case peerwire.Choke:
+		// the peer drops the requests it has not answered yet
+		s.release(peer)
+		return nil
+	case peerwire.Unchoke:
+	case peerwire.Piece:
+		if err := s.receiveBlock(peer, message); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	return s.update(peer)
+}
+
+func (s *Swarm) setBitfield(peer *swarmPeer, bitfield peerwire.PieceBitfield) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.picker.RemoveBitfield(peer.bitfield)
+	s.picker.AddBitfield(bitfield)
+	peer.bitfield = bitfield
+	peer.wanted = 0
+	for index := 0; index < s.info.NumPieces; index++ {
+		if s.picker.Wanted(index) && bitfield.Has(index) {
+			peer.wanted++
+		}
+	}
+}
+
+func (s *Swarm) addHave(peer *swarmPeer, index int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if peer.bitfield.Has(index) {
+		return
+	}
+	peer.bitfield.Set(index)
+	s.picker.AddHave(index)
+	if s.picker.Wanted(index) {
+		peer.wanted++
+	}
+}
+
+// update tells the peer whether we are interested and fills its request
+// queue.
+func (s *Swarm) update(peer *swarmPeer) error {
+	if err := peer.conn.SetInterested(s.interesting(peer)); err != nil {
+		return err
+	}
+	return s.request(peer)
+}
+
+// request fills the peer's request queue, unless it chokes us.
+func (s *Swarm) request(peer *swarmPeer) error {
+	if peer.conn.State().PeerChoking {
+		return nil
+	}
+	for _, r := range s.nextRequests(peer) {
+		request := &peerwire.Message{ID: peerwire.Request, Index: uint32(r.index), Begin: uint32(r.begin), Length: uint32(r.length)}
+		if err := peer.conn.Send(request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextRequests picks blocks for the peer until its queue is full: first the
+// missing blocks of pieces already under way, then those of the pieces the
+// picker chooses.
+func (s *Swarm) nextRequests(peer *swarmPeer) []blockRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := []blockRequest{}
+	wanted := s.RequestQueue - len(peer.inflight)
+	assign := func(index int, piece *pieceDownload) {
+		pieceLength := len(piece.data)
+		for b := range piece.requested {
+			if len(requests) >= wanted {
+				return
+			}
+			if piece.requested[b] != nil || piece.received[b] {
+				continue
+			}
+			r := blockRequest{index: index, begin: b * maxBlockLength, length: maxBlockLength}
+			if r.begin+r.length > pieceLength {
+				r.length = pieceLength - r.begin
+			}
+			piece.requested[b] = peer
+			peer.inflight[r] = true
+			requests = append(requests, r)
+		}
+	}
+
+	for index, piece := range s.active {
+		if len(requests) >= wanted {
+			return requests
+		}
+		if peer.bitfield.Has(index) {
+			assign(index, piece)
+		}
+	}
+	underWay := func(index int) bool {
+		return s.active[index] != nil
+	}
+	for len(requests) < wanted {
+		index, found := s.picker.Pick(peer.bitfield, underWay)
+		if !found {
+			break
+		}
+		pieceLength := s.info.PieceSize(index)
+		numBlocks := (pieceLength + maxBlockLength - 1) / maxBlockLength
+		piece := &pieceDownload{
+			data:      make([]byte, pieceLength),
+			requested: make([]*swarmPeer, numBlocks),
+			received:  make([]bool, numBlocks),
+		}
+		s.active[index] = piece
+		assign(index, piece)
+	}
+	return requests
+}
+
+// receiveBlock stores a block, and the piece it completes once verified.
+// Blocks nobody is waiting for any more are dropped.
+func (s *Swarm) receiveBlock(peer *swarmPeer, message *peerwire.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, begin := int(message.Index), int(message.Begin)
+	delete(peer.inflight, blockRequest{index: index, begin: begin, length: len(message.Payload)})
+
+	piece := s.active[index]
+	if piece == nil {
+		return nil
+	}
+	if err := checkBlock(begin, len(message.Payload), len(piece.data)); err != nil {
+		return err
+	}
+	b := begin / maxBlockLength
+	if piece.received[b] {
+		return nil
+	}
+	// a block that arrives after a choke may have been asked of another
+	// peer since
+	if other := piece.requested[b]; other != nil && other != peer {
+		delete(other.inflight, blockRequest{index: index, begin: begin, length: len(message.Payload)})
+	}
+	copy(piece.data[begin:], message.Payload)
+	piece.requested[b] = nil
+	piece.received[b] = true
+	piece.numReceived++
+	s.stats.AddDownloaded(len(message.Payload))
+	if piece.numReceived < len(piece.received) {
+		return nil
+	}
+
+	// a piece that fails verification is downloaded again
+	delete(s.active, index)
+	if err := s.info.VerifyPiece(index, piece.data); err != nil {
+		log.Warn().Msgf("piece %d: %s", index, err)
+		s.wakePeers()
+		return nil
+	}
+	if err := s.store.WriteBlock(index, 0, piece.data); err != nil {
+		s.fail(fmt.Errorf("storage write: %w", err))
+		return err
+	}
+	s.changeWanted(index, func() { s.picker.MarkHave(index) })
+	s.stats.AddVerified(len(piece.data))
+	log.Debug().Msgf("piece %d verified, %d/%d", index, s.picker.numHave, s.info.NumPieces)
+	s.checkComplete()
+	return nil
+}
+
+// checkComplete ends the download once every wanted piece is in, called
+// with s.mu held.
+func (s *Swarm) checkComplete() {
+	if !s.picker.Done() {
+		return
+	}
+	select {
+	case <-s.complete:
+	default:
+		close(s.complete)
+	}
+}
+
+// dropPeer forgets a peer that went away.
+func (s *Swarm) dropPeer(peer *swarmPeer) {
+	s.release(peer)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.picker.RemoveBitfield(peer.bitfield)
+	delete(s.peers, peer)
+}
+
+// release gives the requests the peer has not answered to other peers.
+func (s *Swarm) release(peer *swarmPeer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(peer.inflight) == 0 {
+		return
+	}
+	for r := range peer.inflight {
+		if piece := s.active[r.index]; piece != nil && piece.requested[r.begin/maxBlockLength] == peer {
+			piece.requested[r.begin/maxBlockLength] = nil
+		}
+		delete(peer.inflight, r)
+	}
+	s.wakePeers()
+}
+
+func (s *Swarm) wakeChannel() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.wake
+}
+
+// wakePeers is called with s.mu held.
+func (s *Swarm) wakePeers() {
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+// interesting reports whether the peer has a piece we are missing.
+func (s *Swarm) interesting(peer *swarmPeer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return peer.wanted > 0
+}
+
+// changeWanted applies change to the picker and updates the wanted count of
+// every peer that has the piece if it is no longer wanted, or wanted again.
+// It is called with s.mu held.
+func (s *Swarm) changeWanted(index int, change func()) {
+	wanted := s.picker.Wanted(index)
+	change()
+	if s.picker.Wanted(index) == wanted {
+		return
+	}
+	for peer := range s.peers {
+		if !peer.bitfield.Has(index) {
+			continue
+		}
+		if wanted {
+			peer.wanted--
+		} else {
+			peer.wanted++
+		}
+	}
+}
+
+// fail stops the download, called with s.mu held.
+func (s *Swarm) fail(err error) {
+	select {
+	case <-s.failed:
+	default:
+		s.err = err
+		close(s.failed)
+	}
+}
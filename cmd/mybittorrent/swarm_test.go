@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/peerwire"
+)
+
+// newTestTorrentInfo returns the info of a single-file torrent of content.
+func newTestTorrentInfo(t *testing.T, content []byte, pieceLength int) *TorrentInfo {
+	pieces := []byte{}
+	for start := 0; start < len(content); start += pieceLength {
+		end := start + pieceLength
+		if end > len(content) {
+			end = len(content)
+		}
+		pieceHash := sha1.Sum(content[start:end])
+		pieces = append(pieces, pieceHash[:]...)
+	}
+
+	rawInfo, err := bencode.Marshal(map[string]any{
+		"name":         "test.bin",
+		"piece length": pieceLength,
+		"pieces":       string(pieces),
+		"length":       len(content),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	info, err := parseTorrentInfo(rawInfo)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return info
+}
+
+// fakeSeeder serves the pieces it has to a single connection.
+type fakeSeeder struct {
+	content     []byte
+	pieceLength int
+	// pieces to announce, all of them if nil
+	pieces []int
+	// hang up after serving this many blocks, never if 0
+	disconnectAfter int
+	// choke after serving this many blocks, and never unchoke again
+	chokeAfter int
+	// garble the first block served
+	corrupt bool
+	// unchoke, but never answer a request
+	ignoreRequests bool
+}
+
+func (f *fakeSeeder) serve(conn net.Conn, numPieces int) {
+	defer conn.Close()
+
+	handshake := make([]byte, 68)
+	if _, err := io.ReadFull(conn, handshake); err != nil {
+		return
+	}
+	copy(handshake[48:], testRemotePeerID)
+	conn.Write(handshake)
+
+	bitfield := peerwire.NewPieceBitfield(numPieces)
+	for index := 0; index < numPieces; index++ {
+		if f.pieces == nil {
+			bitfield.Set(index)
+		}
+	}
+	for _, index := range f.pieces {
+		bitfield.Set(index)
+	}
+	(&peerwire.Message{ID: peerwire.Bitfield, Payload: bitfield}).WriteTo(conn)
+
+	numServed := 0
+	choked := true
+	for {
+		message, err := peerwire.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		if message == nil {
+			continue
+		}
+		switch message.ID {
+		case peerwire.Interested:
+			if choked && (f.chokeAfter == 0 || numServed < f.chokeAfter) {
+				choked = false
+				(&peerwire.Message{ID: peerwire.Unchoke}).WriteTo(conn)
+			}
+		case peerwire.Request:
+			if choked || f.ignoreRequests {
+				continue
+			}
+			start := int(message.Index)*f.pieceLength + int(message.Begin)
+			block := append([]byte{}, f.content[start:start+int(message.Length)]...)
+			if f.corrupt && numServed == 0 {
+				block[0] ^= 0xff
+			}
+			(&peerwire.Message{ID: peerwire.Piece, Index: message.Index, Begin: message.Begin, Payload: block}).WriteTo(conn)
+			numServed++
+
+			if numServed == f.disconnectAfter {
+				return
+			}
+			if numServed == f.chokeAfter {
+				choked = true
+				(&peerwire.Message{ID: peerwire.Choke}).WriteTo(conn)
+			}
+		}
+	}
+}
+
+// newTestSwarm returns a swarm over the given seeders, which are dialed as
+// 10.0.0.1:6881, 10.0.0.2:6881 and so on.
+func newTestSwarm(t *testing.T, info *TorrentInfo, seeders []*fakeSeeder) (*Swarm, string, []netip.AddrPort) {
+	outputPath := filepath.Join(t.TempDir(), "test.bin")
+	store, err := info.OpenStorage(outputPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	addrs := []netip.AddrPort{}
+	seederByAddr := map[netip.AddrPort]*fakeSeeder{}
+	for i, seeder := range seeders {
+		addr := netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, 0, byte(i + 1)}), 6881)
+		addrs = append(addrs, addr)
+		seederByAddr[addr] = seeder
+	}
+
+	var wg sync.WaitGroup
+	t.Cleanup(wg.Wait)
+	swarm := NewSwarm(info, store, NewTransferStats(info.Length))
+	swarm.dial = func(addr netip.AddrPort, infoHash []byte) (*PeerConn, error) {
+		seeder := seederByAddr[addr]
+		if seeder == nil {
+			return nil, fmt.Errorf("dial %s: connection refused", addr)
+		}
+		local, remote := net.Pipe()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			seeder.serve(remote, info.NumPieces)
+		}()
+		return NewPeerConn(local, infoHash)
+	}
+	return swarm, outputPath, addrs
+}
+
+func runTestSwarm(t *testing.T, swarm *Swarm, addrs []netip.AddrPort) error {
+	peers := make(chan []netip.AddrPort, 1)
+	peers <- addrs
+	close(peers)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return swarm.Run(ctx, peers)
+}
+
+func TestSwarmDownload(t *testing.T) {
+	content := make([]byte, 7*32<<10+1000)
+	rand.New(rand.NewSource(1)).Read(content)
+	info := newTestTorrentInfo(t, content, 32<<10)
+
+	seeders := []*fakeSeeder{
+		{content: content, pieceLength: info.PieceLength, pieces: []int{0, 2, 4, 6}, corrupt: true},
+		{content: content, pieceLength: info.PieceLength, pieces: []int{1, 3, 5, 7}},
+		{content: content, pieceLength: info.PieceLength, disconnectAfter: 3},
+		{content: content, pieceLength: info.PieceLength, chokeAfter: 2},
+	}
+	swarm, outputPath, addrs := newTestSwarm(t, info, seeders)
+	swarm.RequestQueue = 3
+
+	if err := runTestSwarm(t, swarm, addrs); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	downloaded, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Fatalf("downloaded content differs")
+	}
+	if left := swarm.stats.Left(); left != 0 {
+		t.Fatalf("expected nothing left, got %d", left)
+	}
+}
+
+func TestSwarmSkippedPieces(t *testing.T) {
+	content := make([]byte, 3*32<<10)
+	rand.New(rand.NewSource(2)).Read(content)
+	info := newTestTorrentInfo(t, content, 32<<10)
+
+	swarm, outputPath, addrs := newTestSwarm(t, info, []*fakeSeeder{{content: content, pieceLength: info.PieceLength}})
+	swarm.SetPriority(1, PrioritySkip)
+	if err := runTestSwarm(t, swarm, addrs); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	downloaded, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := append([]byte{}, content...)
+	copy(expected[32<<10:], make([]byte, 32<<10))
+	if !bytes.Equal(downloaded, expected) {
+		t.Fatalf("expected every piece but the skipped one")
+	}
+}
+
+func TestSwarmKnownPeersWithoutTrackers(t *testing.T) {
+	content := make([]byte, 2*32<<10)
+	rand.New(rand.NewSource(4)).Read(content)
+	info := newTestTorrentInfo(t, content, 32<<10)
+
+	swarm, outputPath, addrs := newTestSwarm(t, info, []*fakeSeeder{{content: content, pieceLength: info.PieceLength}})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := swarm.Run(ctx, withKnownPeers(ctx, addrs, nil)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	downloaded, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Fatalf("downloaded content differs")
+	}
+}
+
+func TestSwarmNoPeers(t *testing.T) {
+	content := make([]byte, 1000)
+	info := newTestTorrentInfo(t, content, 32<<10)
+
+	// a seeder that never answers and an address that refuses connections,
+	// both tried again until they run out of retries
+	swarm, _, addrs := newTestSwarm(t, info, []*fakeSeeder{{content: content, pieceLength: info.PieceLength, ignoreRequests: true}})
+	addrs = append(addrs, netip.MustParseAddrPort("10.0.0.99:6881"))
+	swarm.RequestTimeout = 50 * time.Millisecond
+	swarm.RetryBackoff = time.Millisecond
+
+	err := runTestSwarm(t, swarm, addrs)
+	if !errors.Is(err, ErrNoPeers) || !errors.Is(err, ErrPeerStalled) {
+		t.Fatalf("expected error %v, got %v", ErrNoPeers, err)
+	}
+}
+
+func TestSwarmRetriesDroppedPeers(t *testing.T) {
+	content := make([]byte, 3*32<<10)
+	rand.New(rand.NewSource(3)).Read(content)
+	info := newTestTorrentInfo(t, content, 32<<10)
+
+	// the only seeder hangs up every few blocks
+	swarm, outputPath, addrs := newTestSwarm(t, info, []*fakeSeeder{{content: content, pieceLength: info.PieceLength, disconnectAfter: 2}})
+	swarm.RetryBackoff = time.Millisecond
+	if err := runTestSwarm(t, swarm, addrs); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	downloaded, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Fatalf("downloaded content differs")
+	}
+}
+
+func TestSwarmReleaseReassigns(t *testing.T) {
+	content := make([]byte, 2*32<<10)
+	info := newTestTorrentInfo(t, content, 32<<10)
+	swarm := NewSwarm(info, nil, NewTransferStats(info.Length))
+	swarm.RequestQueue = 2
+
+	newPeer := func() *swarmPeer {
+		bitfield := peerwire.NewPieceBitfield(info.NumPieces)
+		bitfield.Set(0)
+		bitfield.Set(1)
+		return &swarmPeer{bitfield: bitfield, inflight: map[blockRequest]bool{}}
+	}
+	first, second := newPeer(), newPeer()
+
+	firstRequests := swarm.nextRequests(first)
+	secondRequests := swarm.nextRequests(second)
+	if len(firstRequests) != 2 || len(secondRequests) != 2 || firstRequests[0].index == secondRequests[0].index {
+		t.Fatalf("expected the peers to get a piece each, got %v and %v", firstRequests, secondRequests)
+	}
+	if requests := swarm.nextRequests(second); len(requests) != 0 {
+		t.Fatalf("expected a full queue, got %v", requests)
+	}
+
+	// the blocks of a choking peer go to the other
+	swarm.release(first)
+	for r := range second.inflight {
+		delete(second.inflight, r)
+	}
+	if requests := swarm.nextRequests(second); len(requests) != 2 || requests[0] != firstRequests[0] || requests[1] != firstRequests[1] {
+		t.Fatalf("expected %v to be reassigned, got %v", firstRequests, requests)
+	}
+}
+
+func TestSwarmInterestFollowsWantedPieces(t *testing.T) {
+	info := newTestTorrentInfo(t, make([]byte, 3*32<<10), 32<<10)
+	swarm := NewSwarm(info, nil, NewTransferStats(info.Length))
+	peer := &swarmPeer{bitfield: peerwire.NewPieceBitfield(info.NumPieces)}
+	swarm.peers[peer] = true
+
+	bitfield := peerwire.NewPieceBitfield(info.NumPieces)
+	bitfield.Set(1)
+	swarm.setBitfield(peer, bitfield)
+	if !swarm.interesting(peer) {
+		t.Fatalf("expected a peer with a wanted piece to be interesting")
+	}
+
+	swarm.SetPriority(1, PrioritySkip)
+	if swarm.interesting(peer) {
+		t.Fatalf("expected a peer with only skipped pieces not to be interesting")
+	}
+	swarm.addHave(peer, 2)
+	if !swarm.interesting(peer) {
+		t.Fatalf("expected a have for a wanted piece to make the peer interesting")
+	}
+
+	swarm.mu.Lock()
+	swarm.changeWanted(2, func() { swarm.picker.MarkHave(2) })
+	swarm.mu.Unlock()
+	if swarm.interesting(peer) {
+		t.Fatalf("expected a peer with only verified pieces not to be interesting")
+	}
+	swarm.SetPriority(1, PriorityNormal)
+	if !swarm.interesting(peer) {
+		t.Fatalf("expected a piece wanted again to make the peer interesting")
+	}
+}
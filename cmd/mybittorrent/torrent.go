@@ -0,0 +1,339 @@
+package main
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/storage"
+)
+
+var ErrPieceHashMismatch = errors.New("piece hash mismatch")
+var ErrPieceCountMismatch = errors.New("number of piece hashes does not match the length")
+
+// metainfo is the top level of a .torrent file. The info dictionary is kept
+// as raw bytes so its hash is computed over exactly what the author wrote.
+type metainfo struct {
+	Announce     string             `bencode:"announce"`
+	AnnounceList [][]string         `bencode:"announce-list"`
+	Info         bencode.RawMessage `bencode:"info"`
+	// v2 piece hashes of every file longer than one piece, by pieces root
+	PieceLayers map[string]string `bencode:"piece layers"`
+}
+
+// infoDict covers every layout of the info dictionary: v1 single-file
+// torrents have a length, v1 multi-file torrents a list of files under a
+// directory name, and v2 torrents a file tree. Hybrid torrents have both a
+// v1 layout and a file tree.
+type infoDict struct {
+	Name        string         `bencode:"name"`
+	PieceLength int            `bencode:"piece length"`
+	Pieces      string         `bencode:"pieces"`
+	Length      *int           `bencode:"length"`
+	MD5Sum      string         `bencode:"md5sum"`
+	Files       []infoFileDict `bencode:"files"`
+	MetaVersion int            `bencode:"meta version"`
+	FileTree    bencode.Dict   `bencode:"file tree"`
+}
+
+type infoFileDict struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+	MD5Sum string   `bencode:"md5sum"`
+	// BEP 47 attributes, "p" marking padding files
+	Attr string `bencode:"attr"`
+}
+
+func ParseTorrent(filename string) (*TorrentFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var decoded metainfo
+	if err := bencode.NewDecoder(file).Decode(&decoded); err != nil {
+		return nil, err
+	}
+
+	if decoded.Info == nil {
+		return nil, ErrMissingMapKey
+	}
+	// trackerless torrents are valid, their peers come from elsewhere
+	trackers := NewTrackerTiers(decoded.Announce, decoded.AnnounceList)
+
+	info, err := parseTorrentInfo(decoded.Info)
+	if err != nil {
+		return nil, err
+	}
+	if info.V2 {
+		if err := info.setPieceLayers(decoded.PieceLayers); err != nil {
+			return nil, err
+		}
+	}
+
+	return &TorrentFile{
+		Announce:     decoded.Announce,
+		AnnounceList: decoded.AnnounceList,
+		Trackers:     trackers,
+		Info:         info,
+	}, nil
+}
+
+func parseTorrentInfo(rawInfo []byte) (*TorrentInfo, error) {
+	var decodedInfo infoDict
+	if err := bencode.Unmarshal(rawInfo, &decodedInfo); err != nil {
+		return nil, err
+	}
+
+	if decodedInfo.PieceLength <= 0 {
+		return nil, fmt.Errorf("invalid piece length: %d", decodedInfo.PieceLength)
+	}
+
+	hasV1 := decodedInfo.Length != nil || decodedInfo.Files != nil
+	hasV2 := decodedInfo.FileTree != nil
+	if decodedInfo.MetaVersion > 2 || hasV2 != (decodedInfo.MetaVersion == 2) {
+		return nil, fmt.Errorf("%w: meta version %d", ErrUnsupportedMetaVersion, decodedInfo.MetaVersion)
+	}
+
+	piecesFullLength := len(decodedInfo.Pieces)
+	if piecesFullLength%20 != 0 {
+		return nil, fmt.Errorf("invalid piece hashes length: %d", piecesFullLength)
+	}
+
+	info := &TorrentInfo{
+		rawInfo:      rawInfo,
+		Name:         decodedInfo.Name,
+		PieceLength:  decodedInfo.PieceLength,
+		V1:           hasV1,
+		V2:           hasV2,
+		piecesString: decodedInfo.Pieces,
+	}
+
+	switch {
+	case decodedInfo.Length != nil:
+		info.Files = []FileEntry{{
+			Path:   []string{decodedInfo.Name},
+			Length: *decodedInfo.Length,
+			MD5Sum: decodedInfo.MD5Sum,
+		}}
+		info.streamLength = *decodedInfo.Length
+	case decodedInfo.Files != nil:
+		info.MultiFile = true
+		offset := 0
+		for _, f := range decodedInfo.Files {
+			if len(f.Path) == 0 {
+				return nil, fmt.Errorf("file entry without a path in %q", decodedInfo.Name)
+			}
+			if f.Length < 0 {
+				return nil, fmt.Errorf("invalid length %d for file %s", f.Length, strings.Join(f.Path, "/"))
+			}
+			// padding files only align the next file to a piece boundary
+			if !strings.Contains(f.Attr, "p") {
+				info.Files = append(info.Files, FileEntry{
+					Path:   append([]string{decodedInfo.Name}, f.Path...),
+					Length: f.Length,
+					MD5Sum: f.MD5Sum,
+					Offset: offset,
+				})
+			}
+			offset += f.Length
+		}
+		info.streamLength = offset
+	case !hasV2:
+		return nil, ErrMissingMapKey
+	}
+
+	if hasV2 {
+		if err := info.parseFileTree(decodedInfo.FileTree, hasV1); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range info.Files {
+		if f.Length < 0 {
+			return nil, fmt.Errorf("invalid length %d for file %s", f.Length, f.DisplayPath())
+		}
+		info.Length += f.Length
+	}
+
+	info.PieceHashes = info.parsePieceHashes()
+	info.NumPieces = (info.streamLength + info.PieceLength - 1) / info.PieceLength
+	if hasV1 && len(info.PieceHashes) != info.NumPieces {
+		return nil, fmt.Errorf("%w: %d hashes for %d pieces", ErrPieceCountMismatch, len(info.PieceHashes), info.NumPieces)
+	}
+
+	return info, nil
+}
+
+type TorrentFile struct {
+	Announce string
+	// tiers of tracker URLs (BEP 12), which take precedence over Announce
+	AnnounceList [][]string
+	// built from AnnounceList or Announce when nil
+	Trackers *TrackerTiers
+	// peers known without asking a tracker, such as those of a magnet link
+	Peers []netip.AddrPort
+	Info  *TorrentInfo
+}
+
+// TrackerTiers returns the trackers of the torrent, building them from
+// AnnounceList or Announce on first use.
+func (t *TorrentFile) TrackerTiers() *TrackerTiers {
+	if t.Trackers == nil {
+		t.Trackers = NewTrackerTiers(t.Announce, t.AnnounceList)
+	}
+	return t.Trackers
+}
+
+type TorrentInfo struct {
+	rawInfo []byte
+	Name    string
+	// total length of all files
+	Length      int
+	PieceLength int
+	// v1 SHA-1 piece hashes, empty for v2-only torrents
+	PieceHashes []string
+	NumPieces   int
+	// a single-file torrent has exactly one entry, named after the torrent
+	Files     []FileEntry
+	MultiFile bool
+	// V1 and V2 report which metadata versions the torrent carries; hybrid
+	// torrents carry both
+	V1           bool
+	V2           bool
+	piecesString string
+	// length of the byte stream pieces are laid out over, including padding
+	// between files
+	streamLength int
+	// v2 piece hashes by pieces root, for files longer than one piece
+	pieceLayers map[string][]byte
+}
+
+// FileEntry is one file of a torrent. Pieces are laid out over the
+// concatenation of all files, so the file covers the byte range
+// [Offset, Offset+Length) of that stream.
+type FileEntry struct {
+	// path components, starting with the torrent name
+	Path   []string
+	Length int
+	MD5Sum string
+	Offset int
+	// v2 merkle root of the file's 16 KiB blocks, nil for empty files and
+	// v1-only torrents
+	PiecesRoot []byte
+}
+
+func (f FileEntry) End() int {
+	return f.Offset + f.Length
+}
+
+func (f FileEntry) DisplayPath() string {
+	return strings.Join(f.Path, "/")
+}
+
+// OpenStorage creates the files of the torrent under outputPath. For a
+// single-file torrent outputPath is the file itself; for a multi-file torrent
+// it is the directory that takes the place of the torrent name.
+func (info *TorrentInfo) OpenStorage(outputPath string) (*storage.Storage, error) {
+	root := outputPath
+	files := []storage.File{}
+	for _, f := range info.Files {
+		path := f.Path[1:]
+		if !info.MultiFile {
+			root = filepath.Dir(outputPath)
+			path = []string{filepath.Base(outputPath)}
+		}
+		files = append(files, storage.File{
+			Path:   path,
+			Length: int64(f.Length),
+			Offset: int64(f.Offset),
+		})
+	}
+	return storage.Open(root, files, int64(info.PieceLength))
+}
+
+func (info *TorrentInfo) parsePieceHashes() []string {
+	piecesBytes := []byte(info.piecesString)
+	piecesFullLength := len(piecesBytes)
+
+	piecesHashes := []string{}
+	for i := 0; i < piecesFullLength/20; i++ {
+		piecesHashes = append(piecesHashes, fmt.Sprintf("%x", piecesBytes[i*20:(i+1)*20]))
+	}
+	return piecesHashes
+}
+
+// PieceSize returns the length of a piece, which is shorter than the piece
+// length for the last piece of the torrent and, in v2-only torrents, for the
+// last piece of every file.
+func (info *TorrentInfo) PieceSize(pieceIndex int) int {
+	start := pieceIndex * info.PieceLength
+	end := start + info.PieceLength
+	if info.V1 {
+		if end > info.streamLength {
+			end = info.streamLength
+		}
+	} else if f := info.fileAt(start); f != nil && end > f.End() {
+		end = f.End()
+	}
+	return end - start
+}
+
+// VerifyPiece checks a downloaded piece against the SHA-1 piece hash and, for
+// v2 and hybrid torrents, against the merkle tree of the file it belongs to.
+func (info *TorrentInfo) VerifyPiece(pieceIndex int, piece []byte) error {
+	if pieceIndex < 0 || pieceIndex >= info.NumPieces {
+		return fmt.Errorf("%w: no piece %d", ErrPieceHashMismatch, pieceIndex)
+	}
+	if len(piece) != info.PieceSize(pieceIndex) {
+		return fmt.Errorf("%w: piece %d is %d bytes, expected %d", ErrPieceHashMismatch, pieceIndex, len(piece), info.PieceSize(pieceIndex))
+	}
+
+	if info.V1 {
+		pieceHash := fmt.Sprintf("%x", sha1.Sum(piece))
+		if pieceHash != info.PieceHashes[pieceIndex] {
+			return fmt.Errorf("%w: wanted %q, obtained %q", ErrPieceHashMismatch, info.PieceHashes[pieceIndex], pieceHash)
+		}
+	}
+	if info.V2 {
+		return info.verifyPieceV2(pieceIndex, piece)
+	}
+	return nil
+}
+
+// fileAt returns the non-empty file holding the byte at offset of the piece
+// stream, or nil if it falls in padding.
+func (info *TorrentInfo) fileAt(offset int) *FileEntry {
+	for i := range info.Files {
+		f := &info.Files[i]
+		if f.Length > 0 && f.Offset <= offset && offset < f.End() {
+			return f
+		}
+	}
+	return nil
+}
+
+// InfoHash returns the 20-byte info hash used in handshakes and tracker
+// announces: the v1 SHA-1 hash when the torrent has v1 metadata, otherwise
+// the truncated v2 hash.
+func (info *TorrentInfo) InfoHash() []byte {
+	if info.V1 {
+		return info.Sha1Sum()
+	}
+	return info.TruncatedSha256Sum()
+}
+
+// Sha1Sum returns the v1 info hash, computed over the info dictionary bytes
+// exactly as they appear in the .torrent file. Re-encoding the decoded
+// dictionary would change the hash of any non-canonically encoded torrent.
+func (info *TorrentInfo) Sha1Sum() []byte {
+	hasher := sha1.New()
+	hasher.Write(info.rawInfo)
+	return hasher.Sum(nil)
+}
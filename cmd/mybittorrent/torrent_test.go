@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+type parseTorrentTestCase struct {
+	name             string
+	filename         string
+	expectedInfoHash string
+	expectedLength   int
+}
+
+func TestParseTorrentInfoHash(t *testing.T) {
+	testCases := []*parseTorrentTestCase{
+		{
+			name:             "canonical sample torrent",
+			filename:         "../../sample.torrent",
+			expectedInfoHash: "d69f91e6b2ae4c542468d1073a71d4ea13879a7f",
+			expectedLength:   92063,
+		},
+		{
+			name:             "unsorted dictionary keys",
+			filename:         "testdata/unsorted_keys.torrent",
+			expectedInfoHash: "87f87bde1d23a59391223d331d37ff6775b0c25e",
+			expectedLength:   20,
+		},
+		{
+			name:             "empty strings",
+			filename:         "testdata/empty_strings.torrent",
+			expectedInfoHash: "c73fd5f4c7dff2a92b04634bdd242b3166eb9569",
+			expectedLength:   20,
+		},
+		{
+			name:             "zero prefixed string lengths",
+			filename:         "testdata/zero_prefixed_lengths.torrent",
+			expectedInfoHash: "cbded959ea3385bbadec669f64d2c4ea8d3afe46",
+			expectedLength:   20,
+		},
+		{
+			name:             "duplicate dictionary keys",
+			filename:         "testdata/duplicate_keys.torrent",
+			expectedInfoHash: "28d50102b7afb599e05a50bcc97d00c3ba1a509f",
+			expectedLength:   20,
+		},
+		{
+			name:             "unknown keys",
+			filename:         "testdata/unknown_keys.torrent",
+			expectedInfoHash: "cf5e263a8f88aa318c309a0d102a5fd080a95524",
+			expectedLength:   20,
+		},
+		{
+			name:             "multi-file",
+			filename:         "testdata/multi_file.torrent",
+			expectedInfoHash: "ed4045fddf1da8d333a6b799abbac9718cd781c8",
+			expectedLength:   35,
+		},
+		{
+			name:             "v2 only, truncated v2 hash",
+			filename:         "testdata/v2.torrent",
+			expectedInfoHash: "b8b0e4deca7e3fcd73c072aebca8b769e752970e",
+			expectedLength:   200000,
+		},
+		{
+			name:             "hybrid, v1 hash",
+			filename:         "testdata/hybrid.torrent",
+			expectedInfoHash: "158b2a64c5b862e62da121c4df939f4ccb5798a3",
+			expectedLength:   200000,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			torrent, err := ParseTorrent(tc.filename)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			infoHash := fmt.Sprintf("%x", torrent.Info.InfoHash())
+			if infoHash != tc.expectedInfoHash {
+				t.Fatalf("expected info hash %s, got %s", tc.expectedInfoHash, infoHash)
+			}
+			if torrent.Info.Length != tc.expectedLength {
+				t.Fatalf("expected length %d, got %d", tc.expectedLength, torrent.Info.Length)
+			}
+		})
+	}
+}
+
+func TestParseTorrentFiles(t *testing.T) {
+	single, err := ParseTorrent("../../sample.torrent")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedSingle := []FileEntry{{Path: []string{"sample.txt"}, Length: 92063, Offset: 0}}
+	if single.Info.MultiFile || !reflect.DeepEqual(single.Info.Files, expectedSingle) {
+		t.Fatalf("expected single file %+v, got %+v", expectedSingle, single.Info.Files)
+	}
+
+	multi, err := ParseTorrent("testdata/multi_file.torrent")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedMulti := []FileEntry{
+		{Path: []string{"dir", "a.txt"}, Length: 10, Offset: 0},
+		{Path: []string{"dir", "empty"}, Length: 0, Offset: 10},
+		{Path: []string{"dir", "sub", "b.bin"}, Length: 25, MD5Sum: "0123456789abcdef0123456789abcdef", Offset: 10},
+	}
+	if !multi.Info.MultiFile || !reflect.DeepEqual(multi.Info.Files, expectedMulti) {
+		t.Fatalf("expected files %+v, got %+v", expectedMulti, multi.Info.Files)
+	}
+	if multi.Info.Name != "dir" || multi.Info.NumPieces != 2 {
+		t.Fatalf("unexpected name %q or piece count %d", multi.Info.Name, multi.Info.NumPieces)
+	}
+	if multi.Info.Files[2].End() != multi.Info.Length {
+		t.Fatalf("expected last file to end at %d, got %d", multi.Info.Length, multi.Info.Files[2].End())
+	}
+}
+
+func TestOpenStorage(t *testing.T) {
+	multi, err := ParseTorrent("testdata/multi_file.torrent")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	outputDir := filepath.Join(t.TempDir(), "out")
+	store, err := multi.Info.OpenStorage(outputDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer store.Close()
+
+	// the first piece spans a.txt and the start of sub/b.bin
+	if err := store.WriteBlock(0, 0, []byte("0123456789abcdef")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectedContents := map[string]string{
+		"a.txt":                       "0123456789",
+		"empty":                       "",
+		filepath.Join("sub", "b.bin"): "abcdef" + strings.Repeat("\x00", 19),
+	}
+	for path, expected := range expectedContents {
+		contents, err := os.ReadFile(filepath.Join(outputDir, path))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(contents) != expected {
+			t.Fatalf("expected %s to contain %q, got %q", path, expected, contents)
+		}
+	}
+}
+
+type pieceCountTestCase struct {
+	name        string
+	length      int
+	numHashes   int
+	expectedErr error
+}
+
+func TestParseTorrentPieceCount(t *testing.T) {
+	testCases := []*pieceCountTestCase{
+		{name: "one hash per piece", length: 40000, numHashes: 3},
+		{name: "more hashes than pieces", length: 10, numHashes: 2, expectedErr: ErrPieceCountMismatch},
+		{name: "fewer hashes than pieces", length: 40000, numHashes: 2, expectedErr: ErrPieceCountMismatch},
+		{name: "empty torrent", length: 0, numHashes: 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rawInfo, err := bencode.Marshal(map[string]any{
+				"name":         "test.bin",
+				"piece length": 16384,
+				"pieces":       strings.Repeat("x", 20*tc.numHashes),
+				"length":       tc.length,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if _, err := parseTorrentInfo(rawInfo); !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+// v2 files are hashed in blocks of this size, the leaves of their merkle trees
+const v2BlockSize = 16 << 10
+
+var ErrUnsupportedMetaVersion = errors.New("unsupported meta version")
+var ErrInvalidFileTree = errors.New("invalid file tree")
+var ErrHybridMismatch = errors.New("v1 and v2 metadata of hybrid torrent describe different files")
+var ErrInvalidPieceLayers = errors.New("invalid piece layers")
+var ErrMissingPieceLayers = errors.New("piece layers are needed to verify this piece")
+
+// Sha256Sum returns the v2 info hash, computed over the info dictionary bytes
+// exactly as they appear in the .torrent file.
+func (info *TorrentInfo) Sha256Sum() []byte {
+	hash := sha256.Sum256(info.rawInfo)
+	return hash[:]
+}
+
+// TruncatedSha256Sum returns the first 20 bytes of the v2 info hash, which
+// stand in for it wherever the protocol has room for a SHA-1 hash only.
+func (info *TorrentInfo) TruncatedSha256Sum() []byte {
+	return info.Sha256Sum()[:20]
+}
+
+// parseFileTree reads the v2 file tree. For v2-only torrents it provides
+// the files, each aligned to start on a piece boundary; for hybrid torrents
+// it must describe the same files as the v1 layout and only adds their
+// pieces roots.
+func (info *TorrentInfo) parseFileTree(tree bencode.Dict, hasV1 bool) error {
+	if info.PieceLength < v2BlockSize || info.PieceLength&(info.PieceLength-1) != 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidPieceLength, info.PieceLength)
+	}
+
+	treeFiles := []FileEntry{}
+	if err := walkFileTree(tree, nil, &treeFiles); err != nil {
+		return err
+	}
+	if len(treeFiles) == 0 {
+		return fmt.Errorf("%w: no files", ErrInvalidFileTree)
+	}
+
+	// a single file sits at the root of the tree, named like the torrent
+	singleFile := len(treeFiles) == 1 && len(treeFiles[0].Path) == 1
+	if !singleFile {
+		for i := range treeFiles {
+			treeFiles[i].Path = append([]string{info.Name}, treeFiles[i].Path...)
+		}
+	}
+
+	if !hasV1 {
+		offset := 0
+		for i := range treeFiles {
+			// empty files take up no pieces, so need no alignment
+			if treeFiles[i].Length > 0 {
+				offset = (offset + info.PieceLength - 1) / info.PieceLength * info.PieceLength
+			}
+			treeFiles[i].Offset = offset
+			offset += treeFiles[i].Length
+		}
+		info.Files = treeFiles
+		info.MultiFile = !singleFile
+		info.streamLength = offset
+		return nil
+	}
+
+	if len(treeFiles) != len(info.Files) {
+		return fmt.Errorf("%w: %d v1 files, %d v2 files", ErrHybridMismatch, len(info.Files), len(treeFiles))
+	}
+	for i, f := range treeFiles {
+		v1File := &info.Files[i]
+		if !slices.Equal(v1File.Path, f.Path) || v1File.Length != f.Length {
+			return fmt.Errorf("%w: %s (%d bytes) in v1, %s (%d bytes) in v2", ErrHybridMismatch, v1File.DisplayPath(), v1File.Length, f.DisplayPath(), f.Length)
+		}
+		if f.Length > 0 && v1File.Offset%info.PieceLength != 0 {
+			return fmt.Errorf("%w: %s is not aligned to a piece boundary", ErrHybridMismatch, f.DisplayPath())
+		}
+		v1File.PiecesRoot = f.PiecesRoot
+	}
+	return nil
+}
+
+// walkFileTree appends the files under node in tree order, which sorts the
+// names in every directory. A file is a node with a single "" key.
+func walkFileTree(node bencode.Dict, path []string, files *[]FileEntry) error {
+	names := make([]string, 0, len(node))
+	for name := range node {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		child, isDict := node[name].(bencode.Dict)
+		if name == "" || !isDict {
+			return fmt.Errorf("%w: unexpected entry %q in %s", ErrInvalidFileTree, name, strings.Join(path, "/"))
+		}
+		childPath := append(append([]string{}, path...), name)
+
+		leaf, isFile := child[""]
+		if !isFile {
+			if err := walkFileTree(child, childPath, files); err != nil {
+				return err
+			}
+			continue
+		}
+
+		leafDict, isDict := leaf.(bencode.Dict)
+		length, hasLength := leafDict["length"].(int)
+		piecesRoot, _ := leafDict["pieces root"].(string)
+		if !isDict || !hasLength || length < 0 || (length > 0 && len(piecesRoot) != sha256.Size) {
+			return fmt.Errorf("%w: invalid file %s", ErrInvalidFileTree, strings.Join(childPath, "/"))
+		}
+
+		file := FileEntry{Path: childPath, Length: length}
+		if length > 0 {
+			file.PiecesRoot = []byte(piecesRoot)
+		}
+		*files = append(*files, file)
+	}
+	return nil
+}
+
+// setPieceLayers checks the piece layer of every file longer than one piece
+// against its pieces root and keeps it for verifying pieces.
+func (info *TorrentInfo) setPieceLayers(layers map[string]string) error {
+	info.pieceLayers = map[string][]byte{}
+	for _, f := range info.Files {
+		if f.Length <= info.PieceLength {
+			continue
+		}
+
+		layer, found := layers[string(f.PiecesRoot)]
+		if !found {
+			return fmt.Errorf("%w: none for %s", ErrInvalidPieceLayers, f.DisplayPath())
+		}
+		numPieces := (f.Length + info.PieceLength - 1) / info.PieceLength
+		if len(layer) != numPieces*sha256.Size {
+			return fmt.Errorf("%w: %d bytes for %d pieces of %s", ErrInvalidPieceLayers, len(layer), numPieces, f.DisplayPath())
+		}
+
+		pieceHashes := [][]byte{}
+		for i := 0; i < len(layer); i += sha256.Size {
+			pieceHashes = append(pieceHashes, []byte(layer[i:i+sha256.Size]))
+		}
+		root := merkleRoot(pieceHashes, nextPowerOfTwo(numPieces), info.zeroPieceHash())
+		if !bytes.Equal(root, f.PiecesRoot) {
+			return fmt.Errorf("%w: layer of %s does not match its pieces root", ErrInvalidPieceLayers, f.DisplayPath())
+		}
+
+		info.pieceLayers[string(f.PiecesRoot)] = []byte(layer)
+	}
+	return nil
+}
+
+// verifyPieceV2 checks the part of a piece that belongs to the file it
+// starts in against the file's merkle tree.
+func (info *TorrentInfo) verifyPieceV2(pieceIndex int, piece []byte) error {
+	start := pieceIndex * info.PieceLength
+	f := info.fileAt(start)
+	if f == nil {
+		return fmt.Errorf("%w: piece %d is not part of any file", ErrPieceHashMismatch, pieceIndex)
+	}
+	fileData := piece
+	if end := f.End() - start; end < len(fileData) {
+		fileData = fileData[:end]
+	}
+
+	blockHashes := [][]byte{}
+	for i := 0; i < len(fileData); i += v2BlockSize {
+		end := i + v2BlockSize
+		if end > len(fileData) {
+			end = len(fileData)
+		}
+		hash := sha256.Sum256(fileData[i:end])
+		blockHashes = append(blockHashes, hash[:])
+	}
+
+	var expected, actual []byte
+	if f.Length <= info.PieceLength {
+		// the whole file is one piece, hashed up to its pieces root
+		expected = f.PiecesRoot
+		actual = merkleRoot(blockHashes, nextPowerOfTwo(len(blockHashes)), make([]byte, sha256.Size))
+	} else {
+		layer, found := info.pieceLayers[string(f.PiecesRoot)]
+		if !found {
+			return fmt.Errorf("%w: %s", ErrMissingPieceLayers, f.DisplayPath())
+		}
+		pieceInFile := (start - f.Offset) / info.PieceLength
+		expected = layer[pieceInFile*sha256.Size : (pieceInFile+1)*sha256.Size]
+		actual = merkleRoot(blockHashes, info.PieceLength/v2BlockSize, make([]byte, sha256.Size))
+	}
+
+	if !bytes.Equal(expected, actual) {
+		return fmt.Errorf("%w: piece %d, wanted v2 hash %x, obtained %x", ErrPieceHashMismatch, pieceIndex, expected, actual)
+	}
+	return nil
+}
+
+// zeroPieceHash is the hash of a piece of zero blocks, which pads a piece
+// layer up to a power of two.
+func (info *TorrentInfo) zeroPieceHash() []byte {
+	return merkleRoot(nil, info.PieceLength/v2BlockSize, make([]byte, sha256.Size))
+}
+
+// merkleRoot hashes pairs of nodes up to the root of a tree with width
+// leaves, filling the leaves beyond hashes with padding.
+func merkleRoot(hashes [][]byte, width int, padding []byte) []byte {
+	level := make([][]byte, width)
+	for i := range level {
+		if i < len(hashes) {
+			level[i] = hashes[i]
+		} else {
+			level[i] = padding
+		}
+	}
+
+	for len(level) > 1 {
+		next := make([][]byte, len(level)/2)
+		for i := range next {
+			hasher := sha256.New()
+			hasher.Write(level[2*i])
+			hasher.Write(level[2*i+1])
+			next[i] = hasher.Sum(nil)
+		}
+		level = next
+	}
+	return level[0]
+}
+
+func nextPowerOfTwo(n int) int {
+	power := 1
+	for power < n {
+		power *= 2
+	}
+	return power
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+// the content of file i of testdata/v2.torrent and testdata/hybrid.torrent
+func v2TestFileContent(index int, length int) []byte {
+	content := make([]byte, length)
+	for i := range content {
+		content[i] = byte((i*7 + index*13) % 251)
+	}
+	return content
+}
+
+// v2TestPieces lays the test files out over the piece stream, zero-filling
+// the gaps between them, and cuts it into pieces.
+func v2TestPieces(info *TorrentInfo) [][]byte {
+	stream := make([]byte, (info.NumPieces-1)*info.PieceLength+info.PieceSize(info.NumPieces-1))
+	for i, f := range info.Files {
+		copy(stream[f.Offset:], v2TestFileContent(i, f.Length))
+	}
+
+	pieces := [][]byte{}
+	for i := 0; i < info.NumPieces; i++ {
+		start := i * info.PieceLength
+		pieces = append(pieces, stream[start:start+info.PieceSize(i)])
+	}
+	return pieces
+}
+
+type parseV2TestCase struct {
+	name               string
+	filename           string
+	expectedV1         bool
+	expectedSha256Sum  string
+	expectedOffsets    []int
+	expectedPieceSizes []int
+}
+
+func TestParseTorrentV2(t *testing.T) {
+	testCases := []*parseV2TestCase{
+		{
+			name:               "v2 only",
+			filename:           "testdata/v2.torrent",
+			expectedSha256Sum:  "b8b0e4deca7e3fcd73c072aebca8b769e752970e13fd4037b5dad2b4b8d95da7",
+			expectedOffsets:    []int{0, 196608, 262144, 272144},
+			expectedPieceSizes: []int{65536, 65536, 18928, 40000, 10000},
+		},
+		{
+			name:               "hybrid",
+			filename:           "testdata/hybrid.torrent",
+			expectedV1:         true,
+			expectedSha256Sum:  "71f359e51d9aa6964b9d2a7e2c0b0da62bc47c4c3cec22d18f1754d414ad79db",
+			expectedOffsets:    []int{0, 196608, 262144, 272144},
+			expectedPieceSizes: []int{65536, 65536, 65536, 65536, 10000},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			torrent, err := ParseTorrent(tc.filename)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			info := torrent.Info
+
+			if !info.V2 || info.V1 != tc.expectedV1 || !info.MultiFile {
+				t.Fatalf("unexpected versions v1 %t, v2 %t or multi-file %t", info.V1, info.V2, info.MultiFile)
+			}
+			if sha256Sum := fmt.Sprintf("%x", info.Sha256Sum()); sha256Sum != tc.expectedSha256Sum {
+				t.Fatalf("expected v2 info hash %s, got %s", tc.expectedSha256Sum, sha256Sum)
+			}
+
+			expectedPaths := [][]string{{"v2test", "a.bin"}, {"v2test", "c.bin"}, {"v2test", "dir", "b.bin"}, {"v2test", "dir", "empty"}}
+			paths := [][]string{}
+			offsets := []int{}
+			for _, f := range info.Files {
+				paths = append(paths, f.Path)
+				offsets = append(offsets, f.Offset)
+				if (f.Length > 0) != (len(f.PiecesRoot) == 32) {
+					t.Fatalf("unexpected pieces root %x for %s", f.PiecesRoot, f.DisplayPath())
+				}
+			}
+			if !reflect.DeepEqual(paths, expectedPaths) || !reflect.DeepEqual(offsets, tc.expectedOffsets) {
+				t.Fatalf("expected paths %v at %v, got %v at %v", expectedPaths, tc.expectedOffsets, paths, offsets)
+			}
+
+			pieceSizes := []int{}
+			for i := 0; i < info.NumPieces; i++ {
+				pieceSizes = append(pieceSizes, info.PieceSize(i))
+			}
+			if !reflect.DeepEqual(pieceSizes, tc.expectedPieceSizes) {
+				t.Fatalf("expected piece sizes %v, got %v", tc.expectedPieceSizes, pieceSizes)
+			}
+
+			for i, piece := range v2TestPieces(info) {
+				if err := info.VerifyPiece(i, piece); err != nil {
+					t.Fatalf("piece %d: unexpected error: %s", i, err)
+				}
+
+				// corrupt the last byte, which is padding in some hybrid pieces
+				corrupted := append([]byte{}, piece...)
+				corrupted[len(corrupted)-1] ^= 0xff
+				if err := info.VerifyPiece(i, corrupted); !errors.Is(err, ErrPieceHashMismatch) {
+					t.Fatalf("piece %d: expected ErrPieceHashMismatch for a corrupted piece, got %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+type invalidV2TestCase struct {
+	name        string
+	filename    string
+	mutate      func(torrent bencode.Dict)
+	expectedErr error
+}
+
+func TestParseTorrentV2Invalid(t *testing.T) {
+	info := func(torrent bencode.Dict) bencode.Dict {
+		return torrent["info"].(bencode.Dict)
+	}
+
+	testCases := []*invalidV2TestCase{
+		{
+			name:     "unknown meta version",
+			filename: "testdata/v2.torrent",
+			mutate: func(torrent bencode.Dict) {
+				info(torrent)["meta version"] = 3
+			},
+			expectedErr: ErrUnsupportedMetaVersion,
+		},
+		{
+			name:     "tampered piece layer",
+			filename: "testdata/v2.torrent",
+			mutate: func(torrent bencode.Dict) {
+				for root, layer := range torrent["piece layers"].(bencode.Dict) {
+					tampered := []byte(layer.(string))
+					tampered[0] ^= 0xff
+					torrent["piece layers"].(bencode.Dict)[root] = string(tampered)
+				}
+			},
+			expectedErr: ErrInvalidPieceLayers,
+		},
+		{
+			name:     "missing piece layers",
+			filename: "testdata/v2.torrent",
+			mutate: func(torrent bencode.Dict) {
+				delete(torrent, "piece layers")
+			},
+			expectedErr: ErrInvalidPieceLayers,
+		},
+		{
+			name:     "piece length below the block size",
+			filename: "testdata/v2.torrent",
+			mutate: func(torrent bencode.Dict) {
+				info(torrent)["piece length"] = 8192
+			},
+			expectedErr: ErrInvalidPieceLength,
+		},
+		{
+			name:     "file without length",
+			filename: "testdata/v2.torrent",
+			mutate: func(torrent bencode.Dict) {
+				fileTree := info(torrent)["file tree"].(bencode.Dict)
+				fileTree["v2test"] = bencode.Dict{"broken": bencode.Dict{"": bencode.Dict{}}}
+			},
+			expectedErr: ErrInvalidFileTree,
+		},
+		{
+			name:     "hybrid with a renamed v1 file",
+			filename: "testdata/hybrid.torrent",
+			mutate: func(torrent bencode.Dict) {
+				firstFile := info(torrent)["files"].(bencode.List)[0].(bencode.Dict)
+				firstFile["path"] = bencode.List{"renamed.bin"}
+			},
+			expectedErr: ErrHybridMismatch,
+		},
+		{
+			name:     "hybrid without padding files",
+			filename: "testdata/hybrid.torrent",
+			mutate: func(torrent bencode.Dict) {
+				files := bencode.List{}
+				for _, f := range info(torrent)["files"].(bencode.List) {
+					if _, isPadding := f.(bencode.Dict)["attr"]; !isPadding {
+						files = append(files, f)
+					}
+				}
+				info(torrent)["files"] = files
+			},
+			expectedErr: ErrHybridMismatch,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			contents, err := os.ReadFile(tc.filename)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var torrent any
+			if err := bencode.Unmarshal(contents, &torrent); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			tc.mutate(torrent.(bencode.Dict))
+			mutated, err := bencode.Marshal(torrent)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			mutatedPath := filepath.Join(t.TempDir(), "mutated.torrent")
+			if err := os.WriteFile(mutatedPath, mutated, 0644); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if _, err := ParseTorrent(mutatedPath); !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/tracker"
+	"github.com/spf13/cobra"
+)
+
+var trackerHTTPAddr string
+var trackerUDPAddr string
+var trackerAllowlistPath string
+var trackerInterval time.Duration
+var trackerMinInterval time.Duration
+var trackerPeerTimeout time.Duration
+var trackerListSwarms bool
+
+// slow clients may not hold a connection open by trickling in headers
+const trackerReadHeaderTimeout = 10 * time.Second
+
+func init() {
+	defaults := tracker.DefaultConfig()
+	trackerCmd.Flags().StringVar(&trackerHTTPAddr, "http", ":6969", "--http host:port to serve /announce, /scrape and /stats on, empty to disable")
+	trackerCmd.Flags().StringVar(&trackerUDPAddr, "udp", ":6969", "--udp host:port to serve the UDP tracker protocol on, empty to disable")
+	trackerCmd.Flags().StringVar(&trackerAllowlistPath, "allowlist", "", "--allowlist path/to/info_hashes, one hex info hash per line; any torrent if omitted")
+	trackerCmd.Flags().DurationVar(&trackerInterval, "interval", defaults.Interval, "--interval duration between announces")
+	trackerCmd.Flags().DurationVar(&trackerMinInterval, "min-interval", defaults.MinInterval, "--min-interval duration peers must wait between announces")
+	trackerCmd.Flags().DurationVar(&trackerPeerTimeout, "peer-timeout", defaults.PeerTimeout, "--peer-timeout duration after which silent peers are dropped")
+	trackerCmd.Flags().BoolVar(&trackerListSwarms, "stats-swarms", false, "--stats-swarms, to list every torrent by info hash on /stats")
+	rootCmd.AddCommand(trackerCmd)
+}
+
+var trackerCmd = &cobra.Command{
+	Use:   "tracker",
+	Short: "Run a tracker over HTTP and UDP",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := tracker.DefaultConfig()
+		config.Interval = trackerInterval
+		config.MinInterval = trackerMinInterval
+		config.PeerTimeout = trackerPeerTimeout
+		config.ListSwarms = trackerListSwarms
+
+		if trackerAllowlistPath != "" {
+			file, err := os.Open(trackerAllowlistPath)
+			if err != nil {
+				fmt.Println(err.Error())
+				return
+			}
+			config.Allowlist, err = tracker.ParseAllowlist(file)
+			file.Close()
+			if err != nil {
+				fmt.Println(err.Error())
+				return
+			}
+		}
+
+		if err := runTracker(config, trackerHTTPAddr, trackerUDPAddr); err != nil {
+			fmt.Println(err.Error())
+		}
+	},
+}
+
+// runTracker serves the tracker on the given addresses until one of the
+// servers fails.
+func runTracker(config tracker.Config, httpAddr string, udpAddr string) error {
+	if httpAddr == "" && udpAddr == "" {
+		return errors.New("nothing to serve, both --http and --udp are empty")
+	}
+
+	store := tracker.NewStore(config)
+	go func() {
+		for range time.Tick(config.PeerTimeout / 4) {
+			store.Expire()
+		}
+	}()
+
+	errs := make(chan error, 2)
+	if httpAddr != "" {
+		listener, err := net.Listen("tcp", httpAddr)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("HTTP tracker listening on http://%s/announce\n", listener.Addr())
+		server := &http.Server{
+			Handler:           tracker.NewHTTPHandler(store),
+			ReadHeaderTimeout: trackerReadHeaderTimeout,
+		}
+		go func() {
+			errs <- server.Serve(listener)
+		}()
+	}
+	if udpAddr != "" {
+		conn, err := net.ListenPacket("udp", udpAddr)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("UDP tracker listening on udp://%s\n", conn.LocalAddr())
+		go func() {
+			errs <- tracker.NewUDPServer(store).Serve(conn)
+		}()
+	}
+	return <-errs
+}
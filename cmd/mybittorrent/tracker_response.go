@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+// trackerResponseLimits keeps a hostile tracker from making us decode more
+// than a few megabytes of peers.
+var trackerResponseLimits = bencode.Limits{
+	MaxDepth:         16,
+	MaxStringLength:  1 << 20,
+	MaxIntegerDigits: 32,
+	MaxAllocBytes:    4 << 20,
+	MaxElements:      1 << 16,
+}
+
+var ErrTrackerFailure = errors.New("tracker returned an error")
+var ErrInvalidPeerList = errors.New("invalid peer list")
+
+// TrackerFailureError is a request the tracker refused, with the reason it
+// gave: the failure reason of an HTTP tracker, or the message of a UDP
+// tracker's error action.
+type TrackerFailureError struct {
+	Reason string
+}
+
+func (e *TrackerFailureError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrTrackerFailure, e.Reason)
+}
+
+func (e *TrackerFailureError) Unwrap() error {
+	return ErrTrackerFailure
+}
+
+// decodeTrackerResponse decodes the bencoded dictionary an HTTP tracker
+// responds with, turning a failure reason into a *TrackerFailureError.
+func decodeTrackerResponse(body io.Reader) (BencodeMap, error) {
+	decoder := bencode.NewDecoder(body)
+	decoder.SetLimits(trackerResponseLimits)
+
+	var decodedBody any
+	if err := decoder.Decode(&decodedBody); err != nil {
+		return nil, fmt.Errorf("error decoding tracker response body: %s", err.Error())
+	}
+
+	responseMap, isMap := decodedBody.(BencodeMap)
+	if !isMap {
+		return nil, fmt.Errorf("failed to obtain map from tracker response")
+	}
+
+	if _, failed := responseMap["failure reason"]; failed {
+		failureReason, err := GetStringValue(responseMap, "failure reason")
+		if err != nil {
+			return nil, fmt.Errorf("failure reason: %w", err)
+		}
+		return nil, &TrackerFailureError{Reason: failureReason}
+	}
+	return responseMap, nil
+}
+
+// parseTrackerResponse reads an HTTP announce response. Only the interval
+// is required; peers may come in peers, peers6 or both.
+func parseTrackerResponse(responseMap BencodeMap) (*TrackerInfo, error) {
+	interval, err := GetIntValue(responseMap, "interval")
+	if err != nil {
+		return nil, fmt.Errorf("interval: %w", err)
+	}
+
+	trackerInfo := &TrackerInfo{Interval: interval}
+	optionalInts := map[string]*int{
+		"complete":     &trackerInfo.Complete,
+		"incomplete":   &trackerInfo.Incomplete,
+		"min interval": &trackerInfo.MinInterval,
+	}
+	for key, value := range optionalInts {
+		if *value, err = GetOptionalIntValue(responseMap, key); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	if trackerInfo.TrackerID, err = GetOptionalStringValue(responseMap, "tracker id"); err != nil {
+		return nil, fmt.Errorf("tracker id: %w", err)
+	}
+	warning, err := GetOptionalStringValue(responseMap, "warning message")
+	if err != nil {
+		return nil, fmt.Errorf("warning message: %w", err)
+	}
+	if warning != "" {
+		trackerInfo.Warnings = []string{warning}
+	}
+
+	if trackerInfo.Peers, err = parsePeers(responseMap); err != nil {
+		return nil, err
+	}
+	return trackerInfo, nil
+}
+
+// parsePeers reads the peers of an announce response, which come as compact
+// IPv4 peers or a list of dictionaries in peers, and as compact IPv6 peers
+// in peers6 (BEP 7).
+func parsePeers(trackerResponseMap BencodeMap) ([]netip.AddrPort, error) {
+	peers := []netip.AddrPort{}
+
+	switch peerList := trackerResponseMap["peers"].(type) {
+	case nil:
+	case string:
+		compactPeers, err := parseCompactPeers([]byte(peerList))
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, compactPeers...)
+	case BencodeList:
+		dictPeers, err := parseDictPeers(peerList)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, dictPeers...)
+	default:
+		return nil, fmt.Errorf("%w: peers is neither a string nor a list", ErrInvalidPeerList)
+	}
+
+	peerList6, err := GetOptionalStringValue(trackerResponseMap, "peers6")
+	if err != nil {
+		return nil, fmt.Errorf("peers6: %w", err)
+	}
+	compactPeers6, err := parseCompactPeers6([]byte(peerList6))
+	if err != nil {
+		return nil, err
+	}
+	return append(peers, compactPeers6...), nil
+}
+
+// parseCompactPeers parses peers packed as 4-byte IPv4 addresses followed by
+// 2-byte ports.
+func parseCompactPeers(peerListBytes []byte) ([]netip.AddrPort, error) {
+	return parsePackedPeers(peerListBytes, 4)
+}
+
+// parseCompactPeers6 parses peers packed as 16-byte IPv6 addresses followed
+// by 2-byte ports.
+func parseCompactPeers6(peerListBytes []byte) ([]netip.AddrPort, error) {
+	return parsePackedPeers(peerListBytes, 16)
+}
+
+func parsePackedPeers(peerListBytes []byte, addrLength int) ([]netip.AddrPort, error) {
+	entryLength := addrLength + 2
+	if len(peerListBytes)%entryLength != 0 {
+		return nil, fmt.Errorf("%w: compact peer list of %d bytes", ErrInvalidPeerList, len(peerListBytes))
+	}
+
+	peers := []netip.AddrPort{}
+	for i := 0; i < len(peerListBytes); i += entryLength {
+		addr, _ := netip.AddrFromSlice(peerListBytes[i : i+addrLength])
+		port := binary.BigEndian.Uint16(peerListBytes[i+addrLength : i+entryLength])
+		peers = append(peers, netip.AddrPortFrom(addr, port))
+	}
+	return peers, nil
+}
+
+// parseDictPeers parses the original, non-compact peer list of dictionaries
+// with an ip and a port. Their peer ids are not needed, as the handshake
+// tells them anyway, and peers given by hostname rather than address are
+// skipped.
+func parseDictPeers(peerList BencodeList) ([]netip.AddrPort, error) {
+	peers := []netip.AddrPort{}
+	for i, entry := range peerList {
+		peerMap, isMap := entry.(BencodeMap)
+		if !isMap {
+			return nil, fmt.Errorf("%w: peer %d is not a dictionary", ErrInvalidPeerList, i)
+		}
+
+		ip, err := GetStringValue(peerMap, "ip")
+		if err != nil {
+			return nil, fmt.Errorf("%w: ip of peer %d: %s", ErrInvalidPeerList, i, err)
+		}
+		port, err := GetIntValue(peerMap, "port")
+		if err != nil || port <= 0 || port > 0xffff {
+			return nil, fmt.Errorf("%w: port of peer %d", ErrInvalidPeerList, i)
+		}
+
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		peers = append(peers, netip.AddrPortFrom(addr.Unmap(), uint16(port)))
+	}
+	return peers, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type trackerResponseTestCase struct {
+	name          string
+	body          string
+	expected      *TrackerInfo
+	expectedPeers []string
+	expectedErr   error
+}
+
+func TestParseTrackerResponse(t *testing.T) {
+	compactPeer := "\x0a\x00\x00\x01\x1a\xe1"
+	compactPeer6 := "\x20\x01\x0d\xb8" + strings.Repeat("\x00", 11) + "\x01\x1a\xe2"
+
+	testCases := []*trackerResponseTestCase{
+		{
+			name:          "compact peers",
+			body:          "d8:completei2e10:incompletei3e8:intervali1800e12:min intervali60e5:peers6:" + compactPeer + "e",
+			expected:      &TrackerInfo{Complete: 2, Incomplete: 3, Interval: 1800, MinInterval: 60},
+			expectedPeers: []string{"10.0.0.1:6881"},
+		},
+		{
+			name:          "optional fields missing",
+			body:          "d8:intervali900e5:peers0:e",
+			expected:      &TrackerInfo{Interval: 900},
+			expectedPeers: []string{},
+		},
+		{
+			name: "dictionary peers",
+			body: "d8:intervali900e5:peersl" +
+				"d2:ip8:10.0.0.27:peer id20:aaaaaaaaaaaaaaaaaaaa4:porti6881ee" +
+				"d2:ip7:2001::54:porti6882ee" +
+				"d2:ip16:peer.example.com4:porti6883ee" +
+				"ee",
+			expected:      &TrackerInfo{Interval: 900},
+			expectedPeers: []string{"10.0.0.2:6881", "[2001::5]:6882"},
+		},
+		{
+			name:          "compact IPv4 and IPv6 peers",
+			body:          "d8:intervali900e5:peers6:" + compactPeer + "6:peers618:" + compactPeer6 + "e",
+			expected:      &TrackerInfo{Interval: 900},
+			expectedPeers: []string{"10.0.0.1:6881", "[2001:db8::1]:6882"},
+		},
+		{
+			name:          "IPv6 peers only",
+			body:          "d8:intervali900e6:peers618:" + compactPeer6 + "e",
+			expected:      &TrackerInfo{Interval: 900},
+			expectedPeers: []string{"[2001:db8::1]:6882"},
+		},
+		{
+			name:          "warning and tracker id",
+			body:          "d8:intervali900e5:peers0:10:tracker id3:abc15:warning message4:slowe",
+			expected:      &TrackerInfo{Interval: 900, TrackerID: "abc", Warnings: []string{"slow"}},
+			expectedPeers: []string{},
+		},
+		{
+			name:        "failure reason",
+			body:        "d14:failure reason9:forbiddene",
+			expectedErr: ErrTrackerFailure,
+		},
+		{
+			name:        "missing interval",
+			body:        "d5:peers0:e",
+			expectedErr: ErrMissingMapKey,
+		},
+		{
+			name:        "truncated compact peers",
+			body:        "d8:intervali900e5:peers5:" + compactPeer[:5] + "e",
+			expectedErr: ErrInvalidPeerList,
+		},
+		{
+			name:        "dictionary peer without port",
+			body:        "d8:intervali900e5:peersld2:ip8:10.0.0.2eee",
+			expectedErr: ErrInvalidPeerList,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			trackerInfo, err := parseTrackerResponseBody(tc.body)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if !slices.Equal(trackerInfo.Peers, parsePeerAddresses(tc.expectedPeers)) {
+				t.Fatalf("expected peers %v, got %v", tc.expectedPeers, trackerInfo.Peers)
+			}
+			trackerInfo.Peers = nil
+			if trackerInfo.Complete != tc.expected.Complete ||
+				trackerInfo.Incomplete != tc.expected.Incomplete ||
+				trackerInfo.Interval != tc.expected.Interval ||
+				trackerInfo.MinInterval != tc.expected.MinInterval ||
+				trackerInfo.TrackerID != tc.expected.TrackerID ||
+				!slices.Equal(trackerInfo.Warnings, tc.expected.Warnings) {
+				t.Fatalf("expected %+v, got %+v", tc.expected, trackerInfo)
+			}
+		})
+	}
+}
+
+func TestTrackerFailureError(t *testing.T) {
+	_, err := parseTrackerResponseBody("d14:failure reason17:torrent not founde")
+
+	var failure *TrackerFailureError
+	if !errors.As(err, &failure) || failure.Reason != "torrent not found" {
+		t.Fatalf("expected a failure with the tracker's reason, got %v", err)
+	}
+}
+
+func parseTrackerResponseBody(body string) (*TrackerInfo, error) {
+	responseMap, err := decodeTrackerResponse(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return parseTrackerResponse(responseMap)
+}
+
+func TestParseCompactPeers6(t *testing.T) {
+	peers, err := parseCompactPeers6([]byte("\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x50"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(peers) != 1 || peers[0] != netip.MustParseAddrPort("[::1]:80") {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+}
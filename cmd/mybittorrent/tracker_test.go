@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/tracker"
+)
+
+// TestTrackerClients announces and scrapes with our own clients against the
+// built-in tracker, over both HTTP and UDP.
+func TestTrackerClients(t *testing.T) {
+	store := tracker.NewStore(tracker.DefaultConfig())
+	httpServer := httptest.NewServer(tracker.NewHTTPHandler(store))
+	defer httpServer.Close()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	go tracker.NewUDPServer(store).Serve(conn)
+	udpTrackerClient = newTestUDPTrackerClient()
+	defer func() { udpTrackerClient = NewUDPTrackerClient() }()
+
+	httpURL := httpServer.URL + "/announce"
+	udpURL := (&url.URL{Scheme: "udp", Host: conn.LocalAddr().String()}).String()
+
+	// both announces come from the same peer id and port, so the second
+	// replaces the first
+	trackerInfo, err := announce(httpURL, &AnnounceParams{InfoHash: testInfoHash, Left: 100, Event: EventStarted})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if trackerInfo.Incomplete != 1 || len(trackerInfo.Peers) != 0 {
+		t.Fatalf("unexpected tracker info %+v", trackerInfo)
+	}
+	trackerInfo, err = announce(udpURL, &AnnounceParams{InfoHash: testInfoHash, Left: 0, Event: EventCompleted})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if trackerInfo.Complete != 1 || trackerInfo.Incomplete != 0 || trackerInfo.Interval != 1800 {
+		t.Fatalf("unexpected tracker info %+v", trackerInfo)
+	}
+
+	for _, trackerURL := range []string{httpURL, udpURL} {
+		scrapes, err := scrape(trackerURL, [][]byte{testInfoHash, make([]byte, 20)})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		expected := []ScrapeInfo{{Complete: 1, Downloaded: 1}, {}}
+		if len(scrapes) != 2 || *scrapes[0] != expected[0] || *scrapes[1] != expected[1] {
+			t.Fatalf("unexpected scrape of %s: %+v %+v", trackerURL, scrapes[0], scrapes[1])
+		}
+	}
+
+	if _, err := announce(httpURL, &AnnounceParams{InfoHash: []byte("short")}); err == nil {
+		t.Fatalf("expected the tracker to refuse a short info hash")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var ErrNoTrackers = errors.New("torrent has no trackers")
+
+// TrackerTiers holds the trackers of a torrent grouped in tiers, as in an
+// announce-list (BEP 12). Trackers are shuffled within their tier once, and a
+// tracker that responds moves to the front of its tier so it is tried first
+// next time. It is safe for concurrent use.
+type TrackerTiers struct {
+	mu    sync.Mutex
+	tiers [][]string
+}
+
+// NewTrackerTiers builds the tiers from an announce-list, or from the single
+// announce URL when the list is empty. Blank URLs and empty tiers are dropped.
+func NewTrackerTiers(announce string, announceList [][]string) *TrackerTiers {
+	t := &TrackerTiers{}
+	t.addTiers(announceList)
+	if len(t.tiers) == 0 {
+		t.addTiers([][]string{{announce}})
+	}
+	return t
+}
+
+func (t *TrackerTiers) addTiers(announceList [][]string) {
+	for _, tier := range announceList {
+		urls := []string{}
+		for _, url := range tier {
+			if url = strings.TrimSpace(url); url != "" {
+				urls = append(urls, url)
+			}
+		}
+		if len(urls) == 0 {
+			continue
+		}
+		rand.Shuffle(len(urls), func(i, j int) {
+			urls[i], urls[j] = urls[j], urls[i]
+		})
+		t.tiers = append(t.tiers, urls)
+	}
+}
+
+// Tiers returns a copy of the tiers in the order they will be tried.
+func (t *TrackerTiers) Tiers() [][]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tiers := [][]string{}
+	for _, tier := range t.tiers {
+		tiers = append(tiers, append([]string{}, tier...))
+	}
+	return tiers
+}
+
+// Len returns the number of trackers across all tiers.
+func (t *TrackerTiers) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, tier := range t.tiers {
+		n += len(tier)
+	}
+	return n
+}
+
+// Announce calls announce with the trackers of each tier in turn until one
+// of them responds, and merges the peers of the responding tracker of every
+// tier. Counts and intervals are those of the first tracker that responded.
+// An error is returned only when no tracker in any tier responded.
+func (t *TrackerTiers) Announce(announce func(url string) (*TrackerInfo, error)) (*TrackerInfo, error) {
+	if t.Len() == 0 {
+		return nil, ErrNoTrackers
+	}
+
+	var merged *TrackerInfo
+	seenPeers := map[netip.AddrPort]bool{}
+	errs := []error{}
+	// the lock is not held while announcing, which may take a while
+	for n, tier := range t.Tiers() {
+		for _, url := range tier {
+			trackerInfo, err := announce(url)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", url, err))
+				continue
+			}
+			t.promote(n, url)
+
+			if merged == nil {
+				merged = &TrackerInfo{
+					Complete:    trackerInfo.Complete,
+					Incomplete:  trackerInfo.Incomplete,
+					Interval:    trackerInfo.Interval,
+					MinInterval: trackerInfo.MinInterval,
+					Peers:       []netip.AddrPort{},
+				}
+			}
+			for _, warning := range trackerInfo.Warnings {
+				merged.Warnings = append(merged.Warnings, fmt.Sprintf("%s: %s", url, warning))
+			}
+			for _, peer := range trackerInfo.Peers {
+				if !seenPeers[peer] {
+					seenPeers[peer] = true
+					merged.Peers = append(merged.Peers, peer)
+				}
+			}
+			break
+		}
+	}
+
+	if merged == nil {
+		return nil, fmt.Errorf("all %d trackers failed: %w", t.Len(), errors.Join(errs...))
+	}
+	return merged, nil
+}
+
+// promote moves a tracker that responded to the front of its tier.
+func (t *TrackerTiers) promote(n int, url string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tier := t.tiers[n]
+	if i := slices.Index(tier, url); i > 0 {
+		copy(tier[1:i+1], tier[:i])
+		tier[0] = url
+	}
+}
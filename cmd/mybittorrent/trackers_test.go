@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+var errTrackerDown = errors.New("tracker down")
+
+// fakeAnnounce answers for the trackers in responses and fails for the rest,
+// recording the order in which trackers were asked.
+func fakeAnnounce(responses map[string][]string, asked *[]string) func(url string) (*TrackerInfo, error) {
+	return func(url string) (*TrackerInfo, error) {
+		*asked = append(*asked, url)
+		peers, up := responses[url]
+		if !up {
+			return nil, errTrackerDown
+		}
+		return &TrackerInfo{Interval: 1800, Peers: parsePeerAddresses(peers)}, nil
+	}
+}
+
+func parsePeerAddresses(addresses []string) []netip.AddrPort {
+	peers := []netip.AddrPort{}
+	for _, address := range addresses {
+		peers = append(peers, netip.MustParseAddrPort(address))
+	}
+	return peers
+}
+
+type trackerTiersTestCase struct {
+	name          string
+	tiers         [][]string
+	responses     map[string][]string
+	expectedPeers []string
+	expectedErr   error
+}
+
+func TestTrackerTiersAnnounce(t *testing.T) {
+	testCases := []*trackerTiersTestCase{
+		{
+			name:          "first tier down, fails over to second",
+			tiers:         [][]string{{"http://a1", "http://a2"}, {"http://b1"}},
+			responses:     map[string][]string{"http://b1": {"10.0.0.1:6881"}},
+			expectedPeers: []string{"10.0.0.1:6881"},
+		},
+		{
+			name:  "peers merged across tiers without duplicates",
+			tiers: [][]string{{"http://a1"}, {"http://b1"}, {"http://c1"}},
+			responses: map[string][]string{
+				"http://a1": {"10.0.0.1:6881", "10.0.0.2:6881"},
+				"http://c1": {"10.0.0.2:6881", "10.0.0.3:6881"},
+			},
+			expectedPeers: []string{"10.0.0.1:6881", "10.0.0.2:6881", "10.0.0.3:6881"},
+		},
+		{
+			name:        "every tracker down",
+			tiers:       [][]string{{"http://a1", "http://a2"}, {"http://b1"}},
+			responses:   map[string][]string{},
+			expectedErr: errTrackerDown,
+		},
+		{
+			name:        "no trackers",
+			tiers:       [][]string{{" "}, {}},
+			expectedErr: ErrNoTrackers,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			trackers := NewTrackerTiers("", tc.tiers)
+			asked := []string{}
+			trackerInfo, err := trackers.Announce(fakeAnnounce(tc.responses, &asked))
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if !slices.Equal(trackerInfo.Peers, parsePeerAddresses(tc.expectedPeers)) {
+				t.Fatalf("expected peers %v, got %v", tc.expectedPeers, trackerInfo.Peers)
+			}
+		})
+	}
+}
+
+func TestTrackerTiersPromotion(t *testing.T) {
+	trackers := NewTrackerTiers("", [][]string{{"http://a1", "http://a2", "http://a3"}, {"http://b1", "http://b2"}})
+	responses := map[string][]string{"http://a2": {"10.0.0.1:6881"}}
+
+	asked := []string{}
+	if _, err := trackers.Announce(fakeAnnounce(responses, &asked)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if tiers := trackers.Tiers(); tiers[0][0] != "http://a2" || len(tiers[0]) != 3 {
+		t.Fatalf("expected the responding tracker at the front of its tier, got %v", tiers)
+	}
+
+	// the promoted tracker is asked first and the rest of its tier is skipped
+	asked = []string{}
+	if _, err := trackers.Announce(fakeAnnounce(responses, &asked)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if asked[0] != "http://a2" || slices.Contains(asked, "http://a1") || slices.Contains(asked, "http://a3") {
+		t.Fatalf("unexpected announce order %v", asked)
+	}
+}
+
+func TestTrackerTiersHungTracker(t *testing.T) {
+	hung := make(chan struct{})
+	hungTracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer hungTracker.Close()
+	defer close(hung)
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali1800e5:peers6:\x0a\x00\x00\x01\x1a\xe1e"))
+	}))
+	defer tracker.Close()
+
+	timeout := trackerHTTPClient.Timeout
+	trackerHTTPClient.Timeout = 100 * time.Millisecond
+	defer func() { trackerHTTPClient.Timeout = timeout }()
+
+	trackers := NewTrackerTiers("", [][]string{{hungTracker.URL + "/announce"}, {tracker.URL + "/announce"}})
+	params := &AnnounceParams{InfoHash: testInfoHash}
+	trackerInfo, err := trackers.Announce(func(url string) (*TrackerInfo, error) {
+		return announce(url, params)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !slices.Equal(trackerInfo.Peers, parsePeerAddresses([]string{"10.0.0.1:6881"})) {
+		t.Fatalf("expected the peers of the second tier, got %v", trackerInfo.Peers)
+	}
+}
+
+func TestTrackerTiersConcurrentUse(t *testing.T) {
+	trackers := NewTrackerTiers("", [][]string{{"http://a1", "http://a2", "http://a3"}, {"http://b1"}})
+	responses := map[string][]string{"http://a3": {"10.0.0.1:6881"}, "http://b1": {}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				trackers.Announce(fakeAnnounce(responses, &[]string{}))
+				trackers.Tiers()
+			}
+		}()
+	}
+	wg.Wait()
+	if tiers := trackers.Tiers(); tiers[0][0] != "http://a3" || len(tiers[0]) != 3 {
+		t.Fatalf("expected the responding tracker at the front of its tier, got %v", tiers)
+	}
+}
+
+func TestNewTrackerTiers(t *testing.T) {
+	// trackers are shuffled within, but never across, tiers
+	announceList := [][]string{{"http://a1", "http://a2", "http://a3"}, {"", "http://b1"}, {}}
+	tiers := NewTrackerTiers("http://ignored", announceList).Tiers()
+	if len(tiers) != 2 {
+		t.Fatalf("expected 2 tiers, got %v", tiers)
+	}
+	firstTier := slices.Clone(tiers[0])
+	slices.Sort(firstTier)
+	if !reflect.DeepEqual(firstTier, announceList[0]) || !reflect.DeepEqual(tiers[1], []string{"http://b1"}) {
+		t.Fatalf("unexpected tiers %v", tiers)
+	}
+
+	tiers = NewTrackerTiers("http://only", nil).Tiers()
+	if !reflect.DeepEqual(tiers, [][]string{{"http://only"}}) {
+		t.Fatalf("expected the announce URL as the only tier, got %v", tiers)
+	}
+}
+
+func TestParseTorrentAnnounceList(t *testing.T) {
+	contents, err := os.ReadFile("../../sample.torrent")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var torrent any
+	if err := bencode.Unmarshal(contents, &torrent); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	torrent.(bencode.Dict)["announce-list"] = bencode.List{
+		bencode.List{"http://tier1.example/announce"},
+		bencode.List{"udp://tier2.example:80", "http://tier2.example/announce"},
+	}
+	withList, err := bencode.Marshal(torrent)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	torrentPath := filepath.Join(t.TempDir(), "announce_list.torrent")
+	if err := os.WriteFile(torrentPath, withList, 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	parsed, err := ParseTorrent(torrentPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tiers := parsed.Trackers.Tiers()
+	if len(tiers) != 2 || !reflect.DeepEqual(tiers[0], []string{"http://tier1.example/announce"}) || len(tiers[1]) != 2 {
+		t.Fatalf("unexpected tiers %v", tiers)
+	}
+	if parsed.Announce != "http://bittorrent-test-tracker.codecrafters.io/announce" {
+		t.Fatalf("expected announce to be kept, got %q", parsed.Announce)
+	}
+}
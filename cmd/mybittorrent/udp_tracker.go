@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// magic constant that opens every connect request
+const udpProtocolID = 0x41727101980
+
+// BEP 15 actions
+const (
+	udpActionConnect uint32 = iota
+	udpActionAnnounce
+	udpActionScrape
+	udpActionError
+)
+
+// event codes of the announce request
+var udpEvents = map[string]uint32{
+	EventNone:      0,
+	EventCompleted: 1,
+	EventStarted:   2,
+	EventStopped:   3,
+}
+
+const (
+	// connection ids may be used for a minute after they are handed out
+	udpConnectionIDLifetime = time.Minute
+	// retransmissions wait 15·2^n seconds. BEP 15 goes on up to n = 8, over
+	// two hours in all, so we give up after 15 and 30 seconds instead
+	udpBaseTimeout = 15 * time.Second
+	udpMaxRetries  = 1
+	// info hashes per scrape request that fit in one packet
+	udpMaxScrapeHashes = 74
+	udpMaxPacketLength = 65507
+)
+
+var ErrInvalidUDPResponse = errors.New("invalid UDP tracker response")
+var ErrUDPTrackerTimeout = errors.New("UDP tracker did not respond")
+
+var udpTrackerClient = NewUDPTrackerClient()
+
+// UDPTrackerClient speaks the UDP tracker protocol (BEP 15), caching the
+// connection id of every tracker for as long as it stays valid.
+type UDPTrackerClient struct {
+	// first retransmission timeout, doubled after every retransmission
+	BaseTimeout          time.Duration
+	MaxRetries           int
+	ConnectionIDLifetime time.Duration
+
+	// identifies us to trackers across IP address changes
+	key uint32
+
+	mu            sync.Mutex
+	connectionIDs map[string]udpConnectionID
+}
+
+type udpConnectionID struct {
+	id       uint64
+	obtained time.Time
+}
+
+func NewUDPTrackerClient() *UDPTrackerClient {
+	return &UDPTrackerClient{
+		BaseTimeout:          udpBaseTimeout,
+		MaxRetries:           udpMaxRetries,
+		ConnectionIDLifetime: udpConnectionIDLifetime,
+		key:                  rand.Uint32(),
+		connectionIDs:        map[string]udpConnectionID{},
+	}
+}
+
+// Announce asks the tracker for peers of a torrent, reporting our progress
+// as in params. UDP trackers hand out no tracker ids.
+func (c *UDPTrackerClient) Announce(trackerURL *url.URL, params *AnnounceParams) (*TrackerInfo, error) {
+	request := make([]byte, 0, 82)
+	request = append(request, params.InfoHash...)
+	request = append(request, PeerID...)
+	request = binary.BigEndian.AppendUint64(request, uint64(params.Downloaded))
+	request = binary.BigEndian.AppendUint64(request, uint64(params.Left))
+	request = binary.BigEndian.AppendUint64(request, uint64(params.Uploaded))
+	request = binary.BigEndian.AppendUint32(request, udpEvents[params.Event])
+	request = binary.BigEndian.AppendUint32(request, 0) // ip: the sender's
+	request = binary.BigEndian.AppendUint32(request, c.key)
+	request = binary.BigEndian.AppendUint32(request, ^uint32(0)) // num_want: default
+	request = binary.BigEndian.AppendUint16(request, 6881)
+
+	response, overIPv6, err := c.request(trackerURL, udpActionAnnounce, request)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 12 {
+		return nil, fmt.Errorf("%w: announce response of %d bytes", ErrInvalidUDPResponse, len(response))
+	}
+
+	// trackers reached over IPv6 list IPv6 peers
+	parsePeers := parseCompactPeers
+	if overIPv6 {
+		parsePeers = parseCompactPeers6
+	}
+	peers, err := parsePeers(response[12:])
+	if err != nil {
+		return nil, err
+	}
+	return &TrackerInfo{
+		Interval:   int(binary.BigEndian.Uint32(response[0:4])),
+		Incomplete: int(binary.BigEndian.Uint32(response[4:8])),
+		Complete:   int(binary.BigEndian.Uint32(response[8:12])),
+		Peers:      peers,
+	}, nil
+}
+
+// Scrape asks the tracker about the swarms of several torrents at once,
+// returning their stats in the order of infoHashes.
+func (c *UDPTrackerClient) Scrape(trackerURL *url.URL, infoHashes [][]byte) ([]*ScrapeInfo, error) {
+	scrapes := []*ScrapeInfo{}
+	for start := 0; start < len(infoHashes); start += udpMaxScrapeHashes {
+		end := start + udpMaxScrapeHashes
+		if end > len(infoHashes) {
+			end = len(infoHashes)
+		}
+
+		request := []byte{}
+		for _, infoHash := range infoHashes[start:end] {
+			request = append(request, infoHash...)
+		}
+		response, _, err := c.request(trackerURL, udpActionScrape, request)
+		if err != nil {
+			return nil, err
+		}
+		if len(response) < 12*(end-start) {
+			return nil, fmt.Errorf("%w: scrape response of %d bytes for %d torrents", ErrInvalidUDPResponse, len(response), end-start)
+		}
+
+		for i := 0; i < end-start; i++ {
+			entry := response[i*12 : (i+1)*12]
+			scrapes = append(scrapes, &ScrapeInfo{
+				Complete:   int(binary.BigEndian.Uint32(entry[0:4])),
+				Downloaded: int(binary.BigEndian.Uint32(entry[4:8])),
+				Incomplete: int(binary.BigEndian.Uint32(entry[8:12])),
+			})
+		}
+	}
+	return scrapes, nil
+}
+
+// request sends an announce or scrape request, connecting first if there is
+// no valid connection id, and retransmits both on the BEP 15 schedule. It
+// returns the response after its action and transaction id, and whether the
+// tracker was reached over IPv6.
+func (c *UDPTrackerClient) request(trackerURL *url.URL, action uint32, body []byte) ([]byte, bool, error) {
+	if trackerURL.Port() == "" {
+		return nil, false, fmt.Errorf("UDP tracker URL without a port: %s", trackerURL.Redacted())
+	}
+	host := trackerURL.Host
+
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+	overIPv6 := conn.RemoteAddr().(*net.UDPAddr).IP.To4() == nil
+
+	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
+		timeout := c.BaseTimeout << attempt
+
+		connectionID, valid := c.connectionID(host)
+		if !valid {
+			transactionID := rand.Uint32()
+			request := binary.BigEndian.AppendUint64(nil, udpProtocolID)
+			request = binary.BigEndian.AppendUint32(request, udpActionConnect)
+			request = binary.BigEndian.AppendUint32(request, transactionID)
+
+			response, err := c.roundTrip(conn, request, udpActionConnect, transactionID, timeout)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
+			if err != nil {
+				return nil, false, err
+			}
+			if len(response) < 8 {
+				return nil, false, fmt.Errorf("%w: connect response of %d bytes", ErrInvalidUDPResponse, len(response))
+			}
+			connectionID = binary.BigEndian.Uint64(response)
+			c.setConnectionID(host, connectionID)
+		}
+
+		transactionID := rand.Uint32()
+		request := binary.BigEndian.AppendUint64(nil, connectionID)
+		request = binary.BigEndian.AppendUint32(request, action)
+		request = binary.BigEndian.AppendUint32(request, transactionID)
+		request = append(request, body...)
+
+		response, err := c.roundTrip(conn, request, action, transactionID, timeout)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if errors.Is(err, ErrTrackerFailure) {
+			// the tracker may have rejected our connection id
+			c.setConnectionID(host, 0)
+		}
+		return response, overIPv6, err
+	}
+	return nil, false, fmt.Errorf("%w after %d attempts: %s", ErrUDPTrackerTimeout, c.MaxRetries+1, trackerURL.Redacted())
+}
+
+// roundTrip sends request and waits up to timeout for the response carrying
+// the same transaction id, skipping stale responses to earlier requests.
+func (c *UDPTrackerClient) roundTrip(conn net.Conn, request []byte, action uint32, transactionID uint32, timeout time.Duration) ([]byte, error) {
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	packet := make([]byte, udpMaxPacketLength)
+	for {
+		n, err := conn.Read(packet)
+		if err != nil {
+			return nil, err
+		}
+		if n < 8 || binary.BigEndian.Uint32(packet[4:8]) != transactionID {
+			continue
+		}
+
+		responseAction := binary.BigEndian.Uint32(packet[0:4])
+		response := append([]byte{}, packet[8:n]...)
+		switch responseAction {
+		case action:
+			return response, nil
+		case udpActionError:
+			return nil, &TrackerFailureError{Reason: string(response)}
+		}
+		return nil, fmt.Errorf("%w: action %d in response to action %d", ErrInvalidUDPResponse, responseAction, action)
+	}
+}
+
+func (c *UDPTrackerClient) connectionID(host string) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	connectionID, found := c.connectionIDs[host]
+	if !found || connectionID.id == 0 || time.Since(connectionID.obtained) >= c.ConnectionIDLifetime {
+		return 0, false
+	}
+	return connectionID.id, true
+}
+
+func (c *UDPTrackerClient) setConnectionID(host string, id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id == 0 {
+		delete(c.connectionIDs, host)
+		return
+	}
+	c.connectionIDs[host] = udpConnectionID{id: id, obtained: time.Now()}
+}
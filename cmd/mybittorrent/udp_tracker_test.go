@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/url"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUDPTracker answers BEP 15 requests on a local UDP socket. It can drop
+// the first few packets it receives, or fail every request with an error.
+type fakeUDPTracker struct {
+	conn net.PacketConn
+
+	connectionID uint64
+	peers        []byte
+	failure      string
+
+	mu       sync.Mutex
+	drop     int
+	connects int
+	requests int
+	lastLeft uint64
+}
+
+func newFakeUDPTracker(t *testing.T) *fakeUDPTracker {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return serveFakeUDPTracker(t, conn)
+}
+
+func serveFakeUDPTracker(t *testing.T, conn net.PacketConn) *fakeUDPTracker {
+	tracker := &fakeUDPTracker{
+		conn:         conn,
+		connectionID: 0x1122334455667788,
+		peers:        []byte{10, 0, 0, 1, 0x1a, 0xe1, 10, 0, 0, 2, 0x1a, 0xe2},
+	}
+	t.Cleanup(func() { conn.Close() })
+	go tracker.serve()
+	return tracker
+}
+
+func (f *fakeUDPTracker) url() *url.URL {
+	return &url.URL{Scheme: "udp", Host: f.conn.LocalAddr().String()}
+}
+
+func (f *fakeUDPTracker) serve() {
+	packet := make([]byte, udpMaxPacketLength)
+	for {
+		n, addr, err := f.conn.ReadFrom(packet)
+		if err != nil {
+			return
+		}
+		if response := f.handle(packet[:n]); response != nil {
+			f.conn.WriteTo(response, addr)
+		}
+	}
+}
+
+func (f *fakeUDPTracker) handle(request []byte) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.drop > 0 {
+		f.drop--
+		return nil
+	}
+	if len(request) < 16 {
+		return nil
+	}
+
+	connectionID := binary.BigEndian.Uint64(request[0:8])
+	action := binary.BigEndian.Uint32(request[8:12])
+	transactionID := binary.BigEndian.Uint32(request[12:16])
+	response := binary.BigEndian.AppendUint32(nil, action)
+	response = binary.BigEndian.AppendUint32(response, transactionID)
+
+	if action == udpActionConnect {
+		if connectionID != udpProtocolID {
+			return nil
+		}
+		f.connects++
+		return binary.BigEndian.AppendUint64(response, f.connectionID)
+	}
+
+	f.requests++
+	if connectionID != f.connectionID {
+		return errorResponse(transactionID, "unknown connection id")
+	}
+	if f.failure != "" {
+		return errorResponse(transactionID, f.failure)
+	}
+
+	switch action {
+	case udpActionAnnounce:
+		f.lastLeft = binary.BigEndian.Uint64(request[64:72])
+		response = binary.BigEndian.AppendUint32(response, 1800)
+		response = binary.BigEndian.AppendUint32(response, 3) // leechers
+		response = binary.BigEndian.AppendUint32(response, 5) // seeders
+		response = append(response, f.peers...)
+	case udpActionScrape:
+		for i := 16; i+20 <= len(request); i += 20 {
+			// stats derived from the first byte of each info hash
+			seeders := uint32(request[i])
+			response = binary.BigEndian.AppendUint32(response, seeders)
+			response = binary.BigEndian.AppendUint32(response, seeders*10)
+			response = binary.BigEndian.AppendUint32(response, seeders+1)
+		}
+	}
+	return response
+}
+
+// stats returns the number of connect and other requests answered, and the
+// bytes left reported by the last announce.
+func (f *fakeUDPTracker) stats() (connects, requests int, lastLeft uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.connects, f.requests, f.lastLeft
+}
+
+func errorResponse(transactionID uint32, message string) []byte {
+	response := binary.BigEndian.AppendUint32(nil, udpActionError)
+	response = binary.BigEndian.AppendUint32(response, transactionID)
+	return append(response, message...)
+}
+
+func newTestUDPTrackerClient() *UDPTrackerClient {
+	client := NewUDPTrackerClient()
+	client.BaseTimeout = 50 * time.Millisecond
+	client.MaxRetries = 3
+	return client
+}
+
+var testInfoHash = bytes.Repeat([]byte{0xab}, 20)
+
+func TestUDPTrackerAnnounce(t *testing.T) {
+	tracker := newFakeUDPTracker(t)
+	client := newTestUDPTrackerClient()
+
+	trackerInfo, err := client.Announce(tracker.url(), &AnnounceParams{InfoHash: testInfoHash, Left: 12345})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedPeers := parsePeerAddresses([]string{"10.0.0.1:6881", "10.0.0.2:6882"})
+	if trackerInfo.Complete != 5 || trackerInfo.Incomplete != 3 || trackerInfo.Interval != 1800 || !slices.Equal(trackerInfo.Peers, expectedPeers) {
+		t.Fatalf("unexpected tracker info %+v", trackerInfo)
+	}
+	if _, _, lastLeft := tracker.stats(); lastLeft != 12345 {
+		t.Fatalf("expected left 12345 to be announced, got %d", lastLeft)
+	}
+
+	// the connection id is reused while it is valid
+	if _, err := client.Announce(tracker.url(), &AnnounceParams{InfoHash: testInfoHash}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if connects, _, _ := tracker.stats(); connects != 1 {
+		t.Fatalf("expected 1 connect, got %d", connects)
+	}
+
+	// and renewed once it expires
+	client.ConnectionIDLifetime = 0
+	if _, err := client.Announce(tracker.url(), &AnnounceParams{InfoHash: testInfoHash}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if connects, _, _ := tracker.stats(); connects != 2 {
+		t.Fatalf("expected 2 connects, got %d", connects)
+	}
+}
+
+func TestUDPTrackerAnnounceIPv6(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %s", err)
+	}
+	tracker := serveFakeUDPTracker(t, conn)
+	tracker.mu.Lock()
+	tracker.peers = append(net.ParseIP("2001:db8::1"), 0x1a, 0xe1)
+	tracker.mu.Unlock()
+
+	trackerInfo, err := newTestUDPTrackerClient().Announce(tracker.url(), &AnnounceParams{InfoHash: testInfoHash})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedPeers := parsePeerAddresses([]string{"[2001:db8::1]:6881"})
+	if !slices.Equal(trackerInfo.Peers, expectedPeers) {
+		t.Fatalf("expected peers %v, got %v", expectedPeers, trackerInfo.Peers)
+	}
+}
+
+type udpTrackerTestCase struct {
+	name        string
+	setup       func(tracker *fakeUDPTracker, client *UDPTrackerClient)
+	expectedErr error
+}
+
+func TestUDPTrackerFailures(t *testing.T) {
+	testCases := []*udpTrackerTestCase{
+		{
+			name: "dropped packets are retransmitted",
+			setup: func(tracker *fakeUDPTracker, client *UDPTrackerClient) {
+				tracker.drop = 2
+			},
+		},
+		{
+			name: "every packet dropped",
+			setup: func(tracker *fakeUDPTracker, client *UDPTrackerClient) {
+				tracker.drop = 100
+			},
+			expectedErr: ErrUDPTrackerTimeout,
+		},
+		{
+			name: "error action",
+			setup: func(tracker *fakeUDPTracker, client *UDPTrackerClient) {
+				tracker.failure = "torrent not registered"
+			},
+			expectedErr: ErrTrackerFailure,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := newFakeUDPTracker(t)
+			client := newTestUDPTrackerClient()
+			tracker.mu.Lock()
+			tc.setup(tracker, client)
+			tracker.mu.Unlock()
+
+			_, err := client.Announce(tracker.url(), &AnnounceParams{InfoHash: testInfoHash})
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestUDPTrackerStaleConnectionIDDropped(t *testing.T) {
+	tracker := newFakeUDPTracker(t)
+	client := newTestUDPTrackerClient()
+	host := tracker.conn.LocalAddr().String()
+	client.setConnectionID(host, 42)
+
+	if _, err := client.Announce(tracker.url(), &AnnounceParams{InfoHash: testInfoHash}); !errors.Is(err, ErrTrackerFailure) {
+		t.Fatalf("expected error %v, got %v", ErrTrackerFailure, err)
+	}
+	// the rejected id is forgotten, so the next announce connects afresh
+	if _, err := client.Announce(tracker.url(), &AnnounceParams{InfoHash: testInfoHash}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestUDPTrackerIgnoresOtherTransactions(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	// answer the connect request twice: first for another transaction, with
+	// a connection id the client must not use, then for the real one
+	go func() {
+		packet := make([]byte, udpMaxPacketLength)
+		n, addr, err := conn.ReadFrom(packet)
+		if err != nil || n < 16 {
+			return
+		}
+		transactionID := binary.BigEndian.Uint32(packet[12:16])
+		for i, id := range []uint64{1, 2} {
+			response := binary.BigEndian.AppendUint32(nil, udpActionConnect)
+			response = binary.BigEndian.AppendUint32(response, transactionID+uint32(1-i))
+			response = binary.BigEndian.AppendUint64(response, id)
+			conn.WriteTo(response, addr)
+		}
+	}()
+
+	client := newTestUDPTrackerClient()
+	client.MaxRetries = 0
+	trackerURL := &url.URL{Scheme: "udp", Host: conn.LocalAddr().String()}
+	// the announce itself goes unanswered, but the connection id is kept
+	client.Announce(trackerURL, &AnnounceParams{InfoHash: testInfoHash})
+	if id, valid := client.connectionID(trackerURL.Host); !valid || id != 2 {
+		t.Fatalf("expected connection id 2, got %d", id)
+	}
+}
+
+func TestUDPTrackerScrape(t *testing.T) {
+	tracker := newFakeUDPTracker(t)
+	client := newTestUDPTrackerClient()
+
+	// more hashes than fit in one request
+	infoHashes := [][]byte{}
+	expected := []*ScrapeInfo{}
+	for i := 0; i < udpMaxScrapeHashes+3; i++ {
+		infoHashes = append(infoHashes, bytes.Repeat([]byte{byte(i)}, 20))
+		expected = append(expected, &ScrapeInfo{Complete: i, Downloaded: i * 10, Incomplete: i + 1})
+	}
+
+	scrapes, err := client.Scrape(tracker.url(), infoHashes)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(scrapes, expected) {
+		t.Fatalf("unexpected scrape results %v", scrapes)
+	}
+	if _, requests, _ := tracker.stats(); requests != 2 {
+		t.Fatalf("expected 2 scrape requests, got %d", requests)
+	}
+}
+
+func TestAnnounceScheme(t *testing.T) {
+	tracker := newFakeUDPTracker(t)
+	udpTrackerClient = newTestUDPTrackerClient()
+	defer func() { udpTrackerClient = NewUDPTrackerClient() }()
+
+	trackerInfo, err := announce(tracker.url().String(), &AnnounceParams{InfoHash: testInfoHash, Left: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(trackerInfo.Peers) != 2 {
+		t.Fatalf("expected 2 peers, got %v", trackerInfo.Peers)
+	}
+
+	if _, err := announce("wss://tracker.example/announce", &AnnounceParams{InfoHash: testInfoHash}); !errors.Is(err, ErrUnsupportedTrackerScheme) {
+		t.Fatalf("expected error %v, got %v", ErrUnsupportedTrackerScheme, err)
+	}
+}
@@ -0,0 +1,55 @@
+// Package bencode implements the encoding used by BitTorrent metainfo files,
+// tracker responses and extension-protocol messages.
+package bencode
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnsupported = errors.New("only strings and integers are supported at the moment")
+var ErrUnterminatedList = errors.New("found incomplete list")
+var ErrNegativeZero = errors.New("cannot have negative zero")
+var ErrZeroPrefixedInteger = errors.New("invalid integer, cannot have zero-prefixed integers")
+var ErrInvalidInteger = errors.New("unparseable integer")
+var ErrIntegerOutOfRange = errors.New("integer out of range")
+var ErrUnterminatedDictionary = errors.New("found incomplete dictionary")
+var ErrInvalidDictionaryKey = errors.New("invalid dictionary key, must be string")
+var ErrInvalidStringLength = errors.New("invalid string length")
+var ErrUnexpectedTerminator = errors.New("found terminator outside of a list or dictionary")
+var ErrInvalidDecodeTarget = errors.New("decode target must be a non-nil pointer")
+var ErrTypeMismatch = errors.New("bencode value does not fit decode target")
+var ErrTrailingData = errors.New("unexpected data after bencode value")
+var ErrUnsupportedType = errors.New("type is unsupported by bencode")
+var ErrUnsortedKeys = errors.New("dictionary keys are not sorted")
+var ErrDuplicateKey = errors.New("duplicate dictionary key")
+var ErrPlusSignedInteger = errors.New("integer has a plus sign")
+var ErrZeroPrefixedLength = errors.New("string length has leading zeros")
+
+// List is a decoded bencode list.
+type List []any
+
+// Dict is a decoded bencode dictionary.
+type Dict map[string]any
+
+// SyntaxError describes malformed bencode input: where it was found and what
+// was wrong with it. Err is one of the Err* sentinels of this package, or
+// io.ErrUnexpectedEOF for truncated input.
+type SyntaxError struct {
+	// byte offset of the start of the offending token
+	Offset int64
+	// position in the enclosing containers, e.g. info.files[3].path
+	Path string
+	Err  error
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("bencode: %s at offset %d", e.Err, e.Offset)
+	}
+	return fmt.Sprintf("bencode: %s at offset %d in %s", e.Err, e.Offset, e.Path)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
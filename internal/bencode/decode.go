@@ -0,0 +1,754 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Token holds a value of one of these types:
+//
+//	Delim, for the start and end of lists and dictionaries
+//	string, for bencode byte strings
+//	int, for bencode integers
+//	*big.Int, for integers beyond the range of int, see UseBigInt
+type Token any
+
+// Delim is a list or dictionary delimiter: 'l' and 'd' open a container, 'e'
+// closes the innermost open one.
+type Delim byte
+
+func (d Delim) String() string {
+	return string(d)
+}
+
+type frame struct {
+	kind Delim
+	// only meaningful in dictionaries: the next token must be a key or 'e'
+	wantKey bool
+	// the last key read in a dictionary, and every key read so far
+	key  string
+	seen map[string]bool
+	// the number of keys read in a dictionary, or elements read in a list
+	index int
+}
+
+// A Decoder reads bencode values from an input stream one token at a time,
+// so callers never need to buffer a whole metainfo file or tracker response.
+type Decoder struct {
+	r      *bufio.Reader
+	offset int64
+	stack  []frame
+	// while non-nil, every consumed byte is appended to it
+	capture *bytes.Buffer
+	// sticky error from the underlying reader
+	readErr error
+
+	strict    bool
+	useBigInt bool
+	limits    Limits
+	// bytes of strings and integers in the current top-level value
+	allocated  int64
+	warnings   []*SyntaxError
+	tokenStart int64
+}
+
+// NewDecoder returns a decoder reading from r. The decoder may buffer data
+// beyond the values it returns; see Buffered.
+func NewDecoder(r io.Reader) *Decoder {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Decoder{r: br, limits: DefaultLimits}
+}
+
+// InputOffset returns the number of input bytes consumed so far.
+func (d *Decoder) InputOffset() int64 {
+	return d.offset
+}
+
+// Buffered returns a reader over the data read from the underlying reader
+// but not yet consumed by the decoder.
+func (d *Decoder) Buffered() io.Reader {
+	buffered, _ := d.r.Peek(d.r.Buffered())
+	return bytes.NewReader(buffered)
+}
+
+// Token returns the next token in the input stream. At the end of the input,
+// outside of any container, it returns nil, io.EOF. Malformed input is
+// reported as a *SyntaxError, errors from the underlying reader are returned
+// as they are.
+func (d *Decoder) Token() (Token, error) {
+	d.tokenStart = d.offset
+
+	tok, err := d.token()
+	if err == nil || err == io.EOF {
+		return tok, err
+	}
+	if d.readErr != nil {
+		return nil, d.readErr
+	}
+	switch err.(type) {
+	case *SyntaxError, *LimitError:
+		return nil, err
+	}
+	// token leaves the stack untouched on failure, so the path still
+	// points at the offending token
+	return nil, &SyntaxError{Offset: d.tokenStart, Path: d.path(), Err: err}
+}
+
+// Strict makes the decoder reject input that is valid but not in canonical
+// form, as Marshal would write it: dictionary keys that are unsorted or
+// repeated, integers with a plus sign and string lengths with leading zeros.
+// By default such input is accepted and each deviation is recorded; see
+// Warnings.
+func (d *Decoder) Strict() {
+	d.strict = true
+}
+
+// UseBigInt makes the decoder return integers that do not fit in an int as
+// *big.Int values instead of failing with ErrIntegerOutOfRange.
+func (d *Decoder) UseBigInt() {
+	d.useBigInt = true
+}
+
+// Warnings returns every deviation from canonical form seen so far by a
+// decoder that is not in strict mode.
+func (d *Decoder) Warnings() []*SyntaxError {
+	return d.warnings
+}
+
+// nonCanonical reports a deviation from canonical form in the current token:
+// in strict mode it is returned as an error, otherwise it is recorded as a
+// warning and decoding carries on.
+func (d *Decoder) nonCanonical(err error) error {
+	syntaxErr := &SyntaxError{Offset: d.tokenStart, Path: d.path(), Err: err}
+	if d.strict {
+		return syntaxErr
+	}
+	d.warnings = append(d.warnings, syntaxErr)
+	return nil
+}
+
+func (d *Decoder) token() (Token, error) {
+	if d.readErr != nil {
+		return nil, d.readErr
+	}
+
+	c, err := d.readByte()
+	if err == io.EOF {
+		if len(d.stack) == 0 {
+			return nil, io.EOF
+		}
+		return nil, d.unterminatedError()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.checkElementLimits(c); err != nil {
+		return nil, err
+	}
+
+	if top := d.top(); top != nil && top.kind == 'd' {
+		if top.wantKey && c != 'e' && !isDigit(c) {
+			return nil, ErrInvalidDictionaryKey
+		}
+		if !top.wantKey && c == 'e' {
+			return nil, ErrUnterminatedDictionary
+		}
+	}
+
+	switch {
+	case c == 'e':
+		if len(d.stack) == 0 {
+			return nil, ErrUnexpectedTerminator
+		}
+		d.stack = d.stack[:len(d.stack)-1]
+		d.valueDone()
+		return Delim('e'), nil
+	case c == 'l' || c == 'd':
+		d.stack = append(d.stack, frame{kind: Delim(c), wantKey: true})
+		return Delim(c), nil
+	case c == 'i':
+		integer, err := d.readInteger()
+		if err != nil {
+			return nil, err
+		}
+		d.valueDone()
+		return integer, nil
+	case isDigit(c):
+		str, err := d.readString(c)
+		if err != nil {
+			return nil, err
+		}
+		if top := d.top(); top != nil && top.kind == 'd' && top.wantKey {
+			if top.seen[str] {
+				if err := d.nonCanonical(ErrDuplicateKey); err != nil {
+					return nil, err
+				}
+			} else if top.index > 0 && str < top.key {
+				if err := d.nonCanonical(ErrUnsortedKeys); err != nil {
+					return nil, err
+				}
+			}
+			if top.seen == nil {
+				top.seen = map[string]bool{}
+			}
+			top.seen[str] = true
+			top.key = str
+			top.index++
+		}
+		d.valueDone()
+		return str, nil
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// Unmarshal decodes the single bencode value in data into v. See Decode.
+func Unmarshal(data []byte, v any) error {
+	decoder := NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(v); err != nil {
+		if err == io.EOF {
+			return &SyntaxError{Offset: 0, Err: io.ErrUnexpectedEOF}
+		}
+		return err
+	}
+	if decoder.InputOffset() != int64(len(data)) {
+		return ErrTrailingData
+	}
+	return nil
+}
+
+// Decode reads the next complete bencode value from the input and stores it
+// in the value pointed to by v.
+//
+// Byte strings decode into strings and byte slices, integers into any
+// integer type, *big.Int, or bools when they are 0 or 1, lists into slices
+// and dictionaries into structs or maps with string keys. Struct fields are
+// matched by their `bencode:"name"` tag, or the field name when untagged,
+// and unknown keys are skipped. Pointers are allocated as needed. Decoding
+// into an empty interface produces string, int, *big.Int, List and Dict
+// values, and decoding into a RawMessage keeps the raw input bytes.
+func (d *Decoder) Decode(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return ErrInvalidDecodeTarget
+	}
+	return d.decodeInto(rv.Elem())
+}
+
+func (d *Decoder) decodeInto(v reflect.Value) error {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	if v.Type() == rawMessageType {
+		raw, err := d.readRaw()
+		if err != nil {
+			return err
+		}
+		v.SetBytes(raw)
+		return nil
+	}
+
+	tok, err := d.tokenFor(v)
+	if err != nil {
+		return err
+	}
+
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		value, err := d.valueFromToken(tok)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(value))
+		return nil
+	}
+
+	switch tok := tok.(type) {
+	case string:
+		return d.storeString(tok, v)
+	case int, *big.Int:
+		return d.storeInteger(tok, v)
+	case Delim:
+		switch tok {
+		case 'l':
+			return d.decodeList(v)
+		case 'd':
+			return d.decodeDict(v)
+		}
+		return d.unexpectedTerminatorError()
+	}
+	return nil
+}
+
+// tokenFor reads the token to be stored in v. Integers beyond the range of
+// int are read as *big.Int when v can hold them, so that whatever Marshal
+// produces for a uint64 or big.Int decodes back without UseBigInt.
+func (d *Decoder) tokenFor(v reflect.Value) (Token, error) {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+	default:
+		if v.Type() != bigIntType {
+			return d.Token()
+		}
+	}
+	useBigInt := d.useBigInt
+	d.useBigInt = true
+	defer func() { d.useBigInt = useBigInt }()
+	return d.Token()
+}
+
+func (d *Decoder) storeString(str string, v reflect.Value) error {
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(str)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes([]byte(str))
+	default:
+		return fmt.Errorf("%w: cannot decode string into %s", ErrTypeMismatch, v.Type())
+	}
+	return nil
+}
+
+// storeInteger stores an int or *big.Int token.
+func (d *Decoder) storeInteger(integer any, v reflect.Value) error {
+	if v.Type() == bigIntType {
+		target := v.Addr().Interface().(*big.Int)
+		switch integer := integer.(type) {
+		case int:
+			target.SetInt64(int64(integer))
+		case *big.Int:
+			target.Set(integer)
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		n, fits := integerAsInt64(integer)
+		if !fits || (n != 0 && n != 1) {
+			return fmt.Errorf("%w: integer %v is not a bool", ErrTypeMismatch, integer)
+		}
+		v.SetBool(n == 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, fits := integerAsInt64(integer)
+		if !fits || v.OverflowInt(n) {
+			return fmt.Errorf("%w: integer %v overflows %s", ErrTypeMismatch, integer, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, fits := integerAsUint64(integer)
+		if !fits || v.OverflowUint(n) {
+			return fmt.Errorf("%w: integer %v overflows %s", ErrTypeMismatch, integer, v.Type())
+		}
+		v.SetUint(n)
+	default:
+		return fmt.Errorf("%w: cannot decode integer into %s", ErrTypeMismatch, v.Type())
+	}
+	return nil
+}
+
+func integerAsInt64(integer any) (int64, bool) {
+	switch integer := integer.(type) {
+	case int:
+		return int64(integer), true
+	case *big.Int:
+		return integer.Int64(), integer.IsInt64()
+	}
+	return 0, false
+}
+
+func integerAsUint64(integer any) (uint64, bool) {
+	switch integer := integer.(type) {
+	case int:
+		return uint64(integer), integer >= 0
+	case *big.Int:
+		return integer.Uint64(), integer.IsUint64()
+	}
+	return 0, false
+}
+
+func (d *Decoder) decodeList(v reflect.Value) error {
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("%w: cannot decode list into %s", ErrTypeMismatch, v.Type())
+	}
+
+	v.SetLen(0)
+	for i := 0; ; i++ {
+		end, err := d.atContainerEnd()
+		if err != nil {
+			return err
+		}
+		if end {
+			if v.IsNil() {
+				v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+			}
+			return nil
+		}
+
+		v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+		if err := d.decodeInto(v.Index(i)); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *Decoder) decodeDict(v reflect.Value) error {
+	var fields *structFields
+	switch {
+	case v.Kind() == reflect.Struct:
+		fields = cachedFields(v.Type())
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	default:
+		return fmt.Errorf("%w: cannot decode dictionary into %s", ErrTypeMismatch, v.Type())
+	}
+
+	for {
+		end, err := d.atContainerEnd()
+		if err != nil {
+			return err
+		}
+		if end {
+			return nil
+		}
+
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		// Token only lets strings through in key position
+		key := tok.(string)
+
+		if fields != nil {
+			i, known := fields.byName[key]
+			if !known {
+				if err := d.skipValue(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decodeInto(v.Field(fields.list[i].index)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := d.decodeInto(elem); err != nil {
+			return err
+		}
+		v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+	}
+}
+
+// atContainerEnd reports whether the next token closes the current list or
+// dictionary, consuming it if so.
+func (d *Decoder) atContainerEnd() (bool, error) {
+	next, err := d.r.Peek(1)
+	if err == io.EOF {
+		// let Token report the unterminated container
+		_, err = d.Token()
+		return false, err
+	}
+	if err != nil {
+		d.readErr = err
+		return false, err
+	}
+	if next[0] != 'e' {
+		return false, nil
+	}
+	_, err = d.Token()
+	return err == nil, err
+}
+
+// skipValue consumes the next complete value without building it.
+func (d *Decoder) skipValue() error {
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case Delim('l'), Delim('d'):
+			depth++
+		case Delim('e'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// readRaw consumes the next complete value and returns its exact input bytes.
+func (d *Decoder) readRaw() (RawMessage, error) {
+	d.capture = &bytes.Buffer{}
+	defer func() { d.capture = nil }()
+
+	if err := d.skipValue(); err != nil {
+		return nil, err
+	}
+	return RawMessage(d.capture.Bytes()), nil
+}
+
+func (d *Decoder) decodeValue() (any, error) {
+	tok, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	return d.valueFromToken(tok)
+}
+
+func (d *Decoder) valueFromToken(tok Token) (any, error) {
+	delim, isDelim := tok.(Delim)
+	if !isDelim {
+		return tok, nil
+	}
+
+	switch delim {
+	case 'l':
+		list := List{}
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			if tok == Delim('e') {
+				return list, nil
+			}
+			elem, err := d.valueFromToken(tok)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elem)
+		}
+	case 'd':
+		dict := Dict{}
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			if tok == Delim('e') {
+				return dict, nil
+			}
+			// Token only lets strings through in key position
+			key := tok.(string)
+			value, err := d.decodeValue()
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = value
+		}
+	default:
+		return nil, d.unexpectedTerminatorError()
+	}
+}
+
+// unexpectedTerminatorError reports a value being decoded where its
+// enclosing container had already been closed by the last token.
+func (d *Decoder) unexpectedTerminatorError() error {
+	return &SyntaxError{Offset: d.offset - 1, Path: d.path(), Err: ErrUnexpectedTerminator}
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		if err != io.EOF {
+			d.readErr = err
+		}
+		return 0, err
+	}
+	d.offset++
+	if d.capture != nil {
+		d.capture.WriteByte(c)
+	}
+	return c, nil
+}
+
+func (d *Decoder) top() *frame {
+	if len(d.stack) == 0 {
+		return nil
+	}
+	return &d.stack[len(d.stack)-1]
+}
+
+// valueDone flips the key/value expectation of the enclosing dictionary, or
+// counts the element of the enclosing list, after a complete key or value
+// has been read.
+func (d *Decoder) valueDone() {
+	top := d.top()
+	if top == nil {
+		return
+	}
+	if top.kind == 'd' {
+		top.wantKey = !top.wantKey
+	} else {
+		top.index++
+	}
+}
+
+// path describes the position of the next value, e.g. info.files[3].path.
+func (d *Decoder) path() string {
+	b := strings.Builder{}
+	for _, f := range d.stack {
+		if f.kind == 'l' {
+			b.WriteString("[" + strconv.Itoa(f.index) + "]")
+			continue
+		}
+		if f.wantKey {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(f.key)
+	}
+	return b.String()
+}
+
+func (d *Decoder) unterminatedError() error {
+	if d.top().kind == 'd' {
+		return ErrUnterminatedDictionary
+	}
+	return ErrUnterminatedList
+}
+
+// readInteger reads the digits of an integer up to and including the 'e'
+// terminator and returns it as an int, or a *big.Int if it does not fit and
+// UseBigInt was called. The leading 'i' has already been consumed.
+func (d *Decoder) readInteger() (any, error) {
+	digits := []byte{}
+	for {
+		c, err := d.readByte()
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		if c == 'e' {
+			break
+		}
+		digits = append(digits, c)
+		if d.limits.MaxIntegerDigits > 0 && len(digits) > d.limits.MaxIntegerDigits {
+			return 0, d.limitError("MaxIntegerDigits", int64(d.limits.MaxIntegerDigits))
+		}
+	}
+
+	if err := d.allocate(int64(len(digits))); err != nil {
+		return 0, err
+	}
+
+	if len(digits) >= 2 && digits[0] == '-' && digits[1] == '0' {
+		if len(digits) > 2 && isDigit(digits[2]) {
+			return 0, ErrZeroPrefixedInteger
+		}
+		return 0, ErrNegativeZero
+	}
+
+	unsigned := digits
+	if len(digits) > 0 && digits[0] == '+' {
+		if err := d.nonCanonical(ErrPlusSignedInteger); err != nil {
+			return 0, err
+		}
+		unsigned = digits[1:]
+	}
+
+	if len(unsigned) > 1 && unsigned[0] == '0' {
+		return 0, ErrZeroPrefixedInteger
+	}
+
+	integer, err := strconv.Atoi(string(digits))
+	if errors.Is(err, strconv.ErrRange) {
+		if !d.useBigInt {
+			return 0, ErrIntegerOutOfRange
+		}
+		bigInteger, ok := new(big.Int).SetString(string(digits), 10)
+		if !ok {
+			return 0, ErrInvalidInteger
+		}
+		return bigInteger, nil
+	}
+	if err != nil {
+		return 0, ErrInvalidInteger
+	}
+	return integer, nil
+}
+
+// readString reads a length-prefixed byte string whose first length digit
+// has already been consumed.
+func (d *Decoder) readString(first byte) (string, error) {
+	lengthDigits := []byte{first}
+	for {
+		c, err := d.readByte()
+		if err == io.EOF {
+			return "", io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return "", err
+		}
+		if c == ':' {
+			break
+		}
+		// more digits than any int64 has can only be garbage
+		if !isDigit(c) || len(lengthDigits) > 19 {
+			return "", ErrInvalidStringLength
+		}
+		lengthDigits = append(lengthDigits, c)
+	}
+
+	length, err := strconv.ParseInt(string(lengthDigits), 10, 64)
+	if err != nil {
+		return "", ErrInvalidStringLength
+	}
+
+	if len(lengthDigits) > 1 && lengthDigits[0] == '0' {
+		if err := d.nonCanonical(ErrZeroPrefixedLength); err != nil {
+			return "", err
+		}
+	}
+
+	if err := d.allocate(length); err != nil {
+		return "", err
+	}
+
+	// copy rather than allocate up front, a bogus length must not be
+	// trusted before the bytes actually arrive
+	buf := bytes.Buffer{}
+	var dst io.Writer = &buf
+	if d.capture != nil {
+		dst = io.MultiWriter(&buf, d.capture)
+	}
+	n, err := io.CopyN(dst, d.r, length)
+	d.offset += n
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		d.readErr = err
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,442 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+type tokenTestCase struct {
+	name     string
+	input    string
+	expected []Token
+	err      error
+}
+
+func TestDecoderToken(t *testing.T) {
+	testCases := []*tokenTestCase{
+		{
+			name:     "string",
+			input:    "5:hello",
+			expected: []Token{"hello"},
+		},
+		{
+			name:     "empty string",
+			input:    "0:",
+			expected: []Token{""},
+		},
+		{
+			name:     "integer",
+			input:    "i-42e",
+			expected: []Token{-42},
+		},
+		{
+			name:     "list",
+			input:    "li1e3:abce",
+			expected: []Token{Delim('l'), 1, "abc", Delim('e')},
+		},
+		{
+			name:     "nested dictionary",
+			input:    "d1:ad1:bi2eee",
+			expected: []Token{Delim('d'), "a", Delim('d'), "b", 2, Delim('e'), Delim('e')},
+		},
+		{
+			name:     "several top level values",
+			input:    "i1ei2e",
+			expected: []Token{1, 2},
+		},
+		{
+			name:     "dictionary key must be a string",
+			input:    "di1ei2ee",
+			expected: []Token{Delim('d')},
+			err:      ErrInvalidDictionaryKey,
+		},
+		{
+			name:     "dictionary ending after a key",
+			input:    "d1:ae",
+			expected: []Token{Delim('d'), "a"},
+			err:      ErrUnterminatedDictionary,
+		},
+		{
+			name:     "unterminated list",
+			input:    "li1e",
+			expected: []Token{Delim('l'), 1},
+			err:      ErrUnterminatedList,
+		},
+		{
+			name:     "terminator at top level",
+			input:    "e",
+			expected: []Token{},
+			err:      ErrUnexpectedTerminator,
+		},
+		{
+			name:     "truncated string",
+			input:    "5:ab",
+			expected: []Token{},
+			err:      io.ErrUnexpectedEOF,
+		},
+		{
+			name:     "invalid string length",
+			input:    "5x:abcde",
+			expected: []Token{},
+			err:      ErrInvalidStringLength,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			decoder := NewDecoder(iotest.OneByteReader(strings.NewReader(tc.input)))
+			actual := []Token{}
+			var err error
+			for {
+				var tok Token
+				tok, err = decoder.Token()
+				if err != nil {
+					break
+				}
+				actual = append(actual, tok)
+			}
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expected error %q, got %v", tc.err, err)
+				}
+			} else if err != io.EOF {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected tokens %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDecoderDecode(t *testing.T) {
+	input := "d4:infod6:lengthi10e4:name3:abce5:peersl2:p12:p2ee" + "trailing"
+	decoder := NewDecoder(strings.NewReader(input))
+
+	var decoded any
+	if err := decoder.Decode(&decoded); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := Dict{
+		"info":  Dict{"length": 10, "name": "abc"},
+		"peers": List{"p1", "p2"},
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Fatalf("expected %v, got %v", expected, decoded)
+	}
+
+	if decoder.InputOffset() != int64(len(input)-len("trailing")) {
+		t.Fatalf("expected input offset %d, got %d", len(input)-len("trailing"), decoder.InputOffset())
+	}
+
+	rest, err := io.ReadAll(decoder.Buffered())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(rest) != "trailing" {
+		t.Fatalf("expected buffered data %q, got %q", "trailing", rest)
+	}
+}
+
+func TestDecoderDecodeAfterToken(t *testing.T) {
+	decoder := NewDecoder(strings.NewReader("ld1:ai1eeli2eee"))
+
+	if tok, err := decoder.Token(); err != nil || tok != Delim('l') {
+		t.Fatalf("expected list start, got %v, %v", tok, err)
+	}
+
+	var dict Dict
+	if err := decoder.Decode(&dict); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(dict, Dict{"a": 1}) {
+		t.Fatalf("unexpected dict %v", dict)
+	}
+
+	var list List
+	if err := decoder.Decode(&list); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(list, List{2}) {
+		t.Fatalf("unexpected list %v", list)
+	}
+
+	if tok, err := decoder.Token(); err != nil || tok != Delim('e') {
+		t.Fatalf("expected list end, got %v, %v", tok, err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestDecoderDecodeTargetMismatch(t *testing.T) {
+	var str string
+	err := NewDecoder(strings.NewReader("i1e")).Decode(&str)
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("expected %q, got %v", ErrTypeMismatch, err)
+	}
+
+	err = NewDecoder(strings.NewReader("i1e")).Decode(str)
+	if err != ErrInvalidDecodeTarget {
+		t.Fatalf("expected %q, got %v", ErrInvalidDecodeTarget, err)
+	}
+}
+
+type syntaxErrorTestCase struct {
+	name           string
+	input          string
+	expectedOffset int64
+	expectedPath   string
+	err            error
+}
+
+func TestSyntaxError(t *testing.T) {
+	testCases := []*syntaxErrorTestCase{
+		{
+			name:           "empty input",
+			input:          "",
+			expectedOffset: 0,
+			expectedPath:   "",
+			err:            io.ErrUnexpectedEOF,
+		},
+		{
+			name:           "truncated top level string",
+			input:          "5:ab",
+			expectedOffset: 0,
+			expectedPath:   "",
+			err:            io.ErrUnexpectedEOF,
+		},
+		{
+			name:           "bad integer inside dictionary",
+			input:          "d1:ai1xee",
+			expectedOffset: 4,
+			expectedPath:   "a",
+			err:            ErrInvalidInteger,
+		},
+		{
+			name:           "nested list and dictionary path",
+			input:          "d4:infod5:filesld4:pathl1:aee" + "d4:pathl1:b2:ccee" + "d4:pathl1:xi-0eeeeee",
+			expectedOffset: 57,
+			expectedPath:   "info.files[2].path[1]",
+			err:            ErrNegativeZero,
+		},
+		{
+			name:           "plus signed integer with leading zero",
+			input:          "li+05ee",
+			expectedOffset: 1,
+			expectedPath:   "[0]",
+			err:            ErrZeroPrefixedInteger,
+		},
+		{
+			name:           "invalid key reports the dictionary",
+			input:          "d1:ai1e1:bdi1eee",
+			expectedOffset: 11,
+			expectedPath:   "b",
+			err:            ErrInvalidDictionaryKey,
+		},
+		{
+			name:           "unterminated list at end of input",
+			input:          "d1:ali1ei2e",
+			expectedOffset: 11,
+			expectedPath:   "a[2]",
+			err:            ErrUnterminatedList,
+		},
+		{
+			name:           "unsupported token",
+			input:          "lx",
+			expectedOffset: 1,
+			expectedPath:   "[0]",
+			err:            ErrUnsupported,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var decoded any
+			err := Unmarshal([]byte(tc.input), &decoded)
+
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("expected syntax error, got %v", err)
+			}
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %q, got %q", tc.err, syntaxErr.Err)
+			}
+			if syntaxErr.Offset != tc.expectedOffset {
+				t.Fatalf("expected offset %d, got %d", tc.expectedOffset, syntaxErr.Offset)
+			}
+			if syntaxErr.Path != tc.expectedPath {
+				t.Fatalf("expected path %q, got %q", tc.expectedPath, syntaxErr.Path)
+			}
+		})
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	seeds := []string{
+		"", "e", "5:ab", "i-0e", "i01e", "i9999999999999999999999e", "le", "de",
+		"d3:keyi23ee", "d3:keye", "li-22e5:helloe", "d4:infod5:filesld4:pathl1:aeeeee",
+		"d8:announce3:url4:infod6:lengthi1e4:name1:a12:piece lengthi1e6:pieces0:ee",
+		"10000000000000000:000000000",
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var decoded any
+		err := Unmarshal(data, &decoded)
+		if err != nil {
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) && err != ErrTrailingData && !errors.Is(err, ErrLimitExceeded) {
+				t.Fatalf("unexpected error type %T: %v", err, err)
+			}
+		} else if _, err := Marshal(decoded); err != nil {
+			t.Fatalf("failed to re-encode decoded value: %s", err)
+		}
+
+		var typed testMetainfo
+		_ = Unmarshal(data, &typed)
+
+		decoder := NewDecoder(bytes.NewReader(data))
+		for {
+			if _, err := decoder.Token(); err != nil {
+				break
+			}
+		}
+	})
+}
+
+type strictTestCase struct {
+	name             string
+	input            string
+	err              error
+	expectedWarnings []string
+}
+
+func TestDecoderStrict(t *testing.T) {
+	testCases := []*strictTestCase{
+		{
+			name:  "canonical input",
+			input: "d1:ai1e1:bli2e0:ee",
+		},
+		{
+			name:             "unsorted keys",
+			input:            "d1:bi1e1:ai2ee",
+			err:              ErrUnsortedKeys,
+			expectedWarnings: []string{"bencode: dictionary keys are not sorted at offset 7"},
+		},
+		{
+			name:             "duplicate keys",
+			input:            "d4:infod1:ai1e1:ai2eee",
+			err:              ErrDuplicateKey,
+			expectedWarnings: []string{"bencode: duplicate dictionary key at offset 14 in info"},
+		},
+		{
+			name:             "duplicate keys apart",
+			input:            "d1:bi1e1:ai2e1:bi3ee",
+			err:              ErrUnsortedKeys,
+			expectedWarnings: []string{"bencode: dictionary keys are not sorted at offset 7", "bencode: duplicate dictionary key at offset 13"},
+		},
+		{
+			name:             "plus signed integer",
+			input:            "li+5ee",
+			err:              ErrPlusSignedInteger,
+			expectedWarnings: []string{"bencode: integer has a plus sign at offset 1 in [0]"},
+		},
+		{
+			name:             "zero prefixed string length",
+			input:            "d01:ai1e1:b004:spame",
+			err:              ErrZeroPrefixedLength,
+			expectedWarnings: []string{"bencode: string length has leading zeros at offset 1", "bencode: string length has leading zeros at offset 11 in b"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			strict := NewDecoder(strings.NewReader(tc.input))
+			strict.Strict()
+			var decoded any
+			err := strict.Decode(&decoded)
+			if tc.err == nil && err != nil {
+				t.Fatalf("unexpected error in strict mode: %s", err)
+			}
+			if tc.err != nil && !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %q in strict mode, got %v", tc.err, err)
+			}
+
+			lenient := NewDecoder(strings.NewReader(tc.input))
+			if err := lenient.Decode(&decoded); err != nil {
+				t.Fatalf("unexpected error in lenient mode: %s", err)
+			}
+			warnings := []string{}
+			for _, warning := range lenient.Warnings() {
+				warnings = append(warnings, warning.Error())
+			}
+			if len(warnings) != len(tc.expectedWarnings) {
+				t.Fatalf("expected warnings %q, got %q", tc.expectedWarnings, warnings)
+			}
+			for i := range warnings {
+				if warnings[i] != tc.expectedWarnings[i] {
+					t.Fatalf("expected warnings %q, got %q", tc.expectedWarnings, warnings)
+				}
+			}
+		})
+	}
+}
+
+func TestDecoderBigInt(t *testing.T) {
+	input := "li9223372036854775807ei18446744073709551615ei-99999999999999999999999ee"
+
+	var decoded any
+	err := NewDecoder(strings.NewReader(input)).Decode(&decoded)
+	if !errors.Is(err, ErrIntegerOutOfRange) {
+		t.Fatalf("expected %q without UseBigInt, got %v", ErrIntegerOutOfRange, err)
+	}
+
+	decoder := NewDecoder(strings.NewReader(input))
+	decoder.UseBigInt()
+	if err := decoder.Decode(&decoded); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	list := decoded.(List)
+	if list[0] != 9223372036854775807 {
+		t.Fatalf("expected values that fit to stay ints, got %T %v", list[0], list[0])
+	}
+	if list[1].(*big.Int).String() != "18446744073709551615" || list[2].(*big.Int).String() != "-99999999999999999999999" {
+		t.Fatalf("unexpected big integers %v", list[1:])
+	}
+
+	reencoded, err := Marshal(decoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(reencoded) != input {
+		t.Fatalf("expected round trip to %q, got %q", input, reencoded)
+	}
+
+	var typed struct {
+		Max  uint64   `bencode:"max"`
+		Huge *big.Int `bencode:"huge"`
+		Big  big.Int  `bencode:"big"`
+	}
+	decoder = NewDecoder(strings.NewReader("d3:bigi7e4:hugei-99999999999999999999999e3:maxi18446744073709551615ee"))
+	decoder.UseBigInt()
+	if err := decoder.Decode(&typed); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if typed.Max != 18446744073709551615 || typed.Huge.String() != "-99999999999999999999999" || typed.Big.Int64() != 7 {
+		t.Fatalf("unexpected typed values %+v", typed)
+	}
+
+	var tooSmall int64
+	decoder = NewDecoder(strings.NewReader("i18446744073709551615e"))
+	decoder.UseBigInt()
+	if err := decoder.Decode(&tooSmall); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("expected %q, got %v", ErrTypeMismatch, err)
+	}
+}
@@ -0,0 +1,296 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"slices"
+	"strconv"
+)
+
+var bigIntType = reflect.TypeOf(big.Int{})
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+var binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+
+// Marshaler is implemented by types that encode themselves. MarshalBencode
+// must return a single valid bencode value, which is written verbatim.
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+// An Encoder writes bencode values straight to an output stream.
+type Encoder struct {
+	w  encodeWriter
+	bw *bufio.Writer
+	// while set, values are only checked, and nothing is written
+	dryRun bool
+	// outputs of the marshalers called by the dry run, in the order the
+	// encoding needs them
+	marshaled [][]byte
+}
+
+type encodeWriter interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+}
+
+// NewEncoder returns an encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	bw := bufio.NewWriter(w)
+	return &Encoder{w: bw, bw: bw}
+}
+
+// Encode writes the bencode encoding of v.
+//
+// Strings and byte slices encode as byte strings, integers of any kind and
+// *big.Int values as integers, bools as i1e or i0e, slices and arrays as
+// lists, and maps with string keys and structs as dictionaries with sorted
+// keys. Struct fields are named by their `bencode:"name,omitempty"` tag, or
+// the field name when untagged. Values implementing Marshaler encode
+// themselves, and encoding.BinaryMarshaler values encode as byte strings. Nil
+// pointers, interfaces and RawMessages have no bencode representation and are
+// left out of dictionaries.
+//
+// Output is written as it is produced. The whole value is checked first, so
+// a value that cannot be encoded writes nothing; only an error of the
+// underlying writer can leave part of a value written.
+func (e *Encoder) Encode(v any) error {
+	e.dryRun = true
+	e.marshaled = nil
+	err := e.encodeValue(reflect.ValueOf(v))
+	e.dryRun = false
+	if err != nil {
+		return err
+	}
+	if err := e.encodeValue(reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	return e.bw.Flush()
+}
+
+// Marshal returns the bencode encoding of v. See Encoder.Encode.
+func Marshal(v any) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	e := &Encoder{w: buf}
+	if err := e.encodeValue(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *Encoder) encodeValue(v reflect.Value) error {
+	if !v.IsValid() {
+		return fmt.Errorf("%w: nil", ErrUnsupportedType)
+	}
+
+	if handled, err := e.encodeMarshaler(v); handled {
+		return err
+	}
+
+	if v.Type() == rawMessageType {
+		if v.Len() == 0 {
+			return fmt.Errorf("%w: empty %s", ErrUnsupportedType, v.Type())
+		}
+		e.write(v.Bytes())
+		return nil
+	}
+
+	if v.Type() == bigIntType {
+		var integer *big.Int
+		if v.CanAddr() {
+			integer = v.Addr().Interface().(*big.Int)
+		} else {
+			value := v.Interface().(big.Int)
+			integer = &value
+		}
+		e.encodeInteger(integer.String())
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Bool:
+		if v.Bool() {
+			e.encodeInteger("1")
+		} else {
+			e.encodeInteger("0")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInteger(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeInteger(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeBytes(v)
+			return nil
+		}
+		e.writeByte('l')
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encodeValue(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		e.writeByte('e')
+	case reflect.Map:
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return fmt.Errorf("%w: nil %s", ErrUnsupportedType, v.Type())
+		}
+		return e.encodeValue(v.Elem())
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedType, v.Type())
+	}
+	return nil
+}
+
+// encodeMarshaler encodes v if it, or a pointer to it, implements Marshaler
+// or encoding.BinaryMarshaler, and reports whether it did.
+func (e *Encoder) encodeMarshaler(v reflect.Value) (bool, error) {
+	if v.Kind() != reflect.Pointer && v.CanAddr() {
+		pointerType := reflect.PointerTo(v.Type())
+		if pointerType.Implements(marshalerType) || pointerType.Implements(binaryMarshalerType) {
+			v = v.Addr()
+		}
+	}
+	if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+		return false, nil
+	}
+
+	switch {
+	case v.Type().Implements(marshalerType):
+		encoded, err := e.marshal(func() ([]byte, error) {
+			return v.Interface().(Marshaler).MarshalBencode()
+		})
+		if err != nil {
+			return true, err
+		}
+		e.write(encoded)
+		return true, nil
+	case v.Type().Implements(binaryMarshalerType):
+		encoded, err := e.marshal(v.Interface().(encoding.BinaryMarshaler).MarshalBinary)
+		if err != nil {
+			return true, err
+		}
+		e.encodeString(string(encoded))
+		return true, nil
+	}
+	return false, nil
+}
+
+// marshal calls a marshaler once: the dry run keeps its output for the
+// encoding that follows. Without a dry run, as in Marshal, it is just called.
+func (e *Encoder) marshal(marshal func() ([]byte, error)) ([]byte, error) {
+	if e.dryRun {
+		encoded, err := marshal()
+		e.marshaled = append(e.marshaled, encoded)
+		return encoded, err
+	}
+	if e.bw == nil {
+		return marshal()
+	}
+	encoded := e.marshaled[0]
+	e.marshaled = e.marshaled[1:]
+	return encoded, nil
+}
+
+func (e *Encoder) write(b []byte) {
+	if !e.dryRun {
+		e.w.Write(b)
+	}
+}
+
+func (e *Encoder) writeByte(c byte) {
+	if !e.dryRun {
+		e.w.WriteByte(c)
+	}
+}
+
+func (e *Encoder) writeString(str string) {
+	if !e.dryRun {
+		e.w.WriteString(str)
+	}
+}
+
+func (e *Encoder) encodeInteger(digits string) {
+	e.writeByte('i')
+	e.writeString(digits)
+	e.writeByte('e')
+}
+
+func (e *Encoder) encodeString(str string) {
+	e.writeString(strconv.Itoa(len(str)))
+	e.writeByte(':')
+	e.writeString(str)
+}
+
+func (e *Encoder) encodeBytes(v reflect.Value) {
+	e.writeString(strconv.Itoa(v.Len()))
+	e.writeByte(':')
+	if v.Kind() == reflect.Slice {
+		e.write(v.Bytes())
+		return
+	}
+	for i := 0; i < v.Len(); i++ {
+		e.writeByte(byte(v.Index(i).Uint()))
+	}
+}
+
+func (e *Encoder) encodeMap(v reflect.Value) error {
+	if v.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("%w: %s", ErrUnsupportedType, v.Type())
+	}
+
+	keys := v.MapKeys()
+	slices.SortFunc(keys, func(a, b reflect.Value) int {
+		return bytes.Compare([]byte(a.String()), []byte(b.String()))
+	})
+
+	e.writeByte('d')
+	for _, key := range keys {
+		value := v.MapIndex(key)
+		if isAbsent(value) {
+			continue
+		}
+		e.encodeString(key.String())
+		if err := e.encodeValue(value); err != nil {
+			return err
+		}
+	}
+	e.writeByte('e')
+	return nil
+}
+
+func (e *Encoder) encodeStruct(v reflect.Value) error {
+	e.writeByte('d')
+	for _, f := range cachedFields(v.Type()).list {
+		value := v.Field(f.index)
+		if isAbsent(value) || (f.omitEmpty && isEmptyValue(value)) {
+			continue
+		}
+		e.encodeString(f.name)
+		if err := e.encodeValue(value); err != nil {
+			return err
+		}
+	}
+	e.writeByte('e')
+	return nil
+}
+
+// isAbsent reports whether v has no bencode representation and must be
+// left out of its enclosing dictionary.
+func isAbsent(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.Type() == rawMessageType && v.Len() == 0
+}
@@ -0,0 +1,118 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+type testCompactPeer struct {
+	addr netip.AddrPort
+}
+
+func (p testCompactPeer) MarshalBinary() ([]byte, error) {
+	return binary.BigEndian.AppendUint16(p.addr.Addr().AsSlice(), p.addr.Port()), nil
+}
+
+type testSelfEncoding struct {
+	fail  bool
+	calls int
+}
+
+func (s *testSelfEncoding) MarshalBencode() ([]byte, error) {
+	s.calls++
+	if s.fail {
+		return nil, errors.New("refusing to encode")
+	}
+	return []byte("l4:selfe"), nil
+}
+
+type testPeerMessage struct {
+	Peer     testCompactPeer  `bencode:"peer"`
+	Self     testSelfEncoding `bencode:"self"`
+	Seeding  bool             `bencode:"seeding"`
+	Upload   bool             `bencode:"upload_only,omitempty"`
+	Checksum [4]byte          `bencode:"checksum"`
+}
+
+func TestEncoderEncode(t *testing.T) {
+	message := testPeerMessage{
+		Peer:     testCompactPeer{addr: netip.MustParseAddrPort("10.0.0.1:6881")},
+		Seeding:  true,
+		Checksum: [4]byte{'a', 'b', 'c', 'd'},
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := NewEncoder(buf)
+	if err := encoder.Encode(&message); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := encoder.Encode([]bool{false}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := "d8:checksum4:abcd4:peer6:\x0a\x00\x00\x01\x1a\xe17:seedingi1e4:selfl4:selfee" + "li0ee"
+	if buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+
+	var decoded struct {
+		Seeding bool `bencode:"seeding"`
+	}
+	if err := NewDecoder(buf).Decode(&decoded); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !decoded.Seeding {
+		t.Fatalf("expected seeding to decode as true")
+	}
+}
+
+func TestEncoderEncodeMarshalerError(t *testing.T) {
+	_, err := Marshal(&testSelfEncoding{fail: true})
+	if err == nil || err.Error() != "refusing to encode" {
+		t.Fatalf("expected marshaler error, got %v", err)
+	}
+}
+
+func TestEncoderEncodeErrorWritesNothing(t *testing.T) {
+	buf := &bytes.Buffer{}
+	encoder := NewEncoder(buf)
+	if err := encoder.Encode([]any{1, make(chan int)}); !errors.Is(err, ErrUnsupportedType) {
+		t.Fatalf("expected error %v, got %v", ErrUnsupportedType, err)
+	}
+	if err := encoder.Encode([]any{"a", &testSelfEncoding{fail: true}}); err == nil {
+		t.Fatalf("expected marshaler error")
+	}
+	if err := encoder.Encode("ok"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if buf.String() != "2:ok" {
+		t.Fatalf("expected %q, got %q", "2:ok", buf.String())
+	}
+}
+
+func TestEncoderEncodeCallsMarshalersOnce(t *testing.T) {
+	self := &testSelfEncoding{}
+	buf := &bytes.Buffer{}
+	if err := NewEncoder(buf).Encode([]any{self, "x", self}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if buf.String() != "ll4:selfe1:xl4:selfee" || self.calls != 2 {
+		t.Fatalf("expected two marshaler calls, got %d writing %q", self.calls, buf.String())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestEncoderEncodeWriteError(t *testing.T) {
+	err := NewEncoder(failingWriter{}).Encode("spam")
+	if err == nil || err.Error() != "connection reset" {
+		t.Fatalf("expected write error, got %v", err)
+	}
+}
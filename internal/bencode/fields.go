@@ -0,0 +1,114 @@
+package bencode
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// field describes how a struct field maps onto a dictionary key.
+type field struct {
+	name      string
+	index     int
+	omitEmpty bool
+	// named by its tag rather than the field name
+	tagged bool
+}
+
+type structFields struct {
+	// sorted by name, which is the order keys are encoded in
+	list   []field
+	byName map[string]int
+}
+
+var fieldCache sync.Map // map[reflect.Type]*structFields
+
+// cachedFields returns the dictionary keys of the exported fields of t,
+// honouring `bencode:"name,omitempty"` tags and skipping fields tagged "-".
+// Fields sharing a name follow the encoding/json rules: a tagged field wins
+// over untagged ones, and when that leaves more than one they are all left
+// out.
+func cachedFields(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+
+	fields := &structFields{byName: map[string]int{}}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		tag := sf.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		f := field{name: name, index: i, tagged: name != ""}
+		if name == "" {
+			f.name = sf.Name
+		}
+		for _, option := range strings.Split(options, ",") {
+			if option == "omitempty" {
+				f.omitEmpty = true
+			}
+		}
+		fields.list = append(fields.list, f)
+	}
+
+	slices.SortStableFunc(fields.list, func(a, b field) int {
+		return strings.Compare(a.name, b.name)
+	})
+	fields.list = dominantFields(fields.list)
+	for i, f := range fields.list {
+		fields.byName[f.name] = i
+	}
+
+	actual, _ := fieldCache.LoadOrStore(t, fields)
+	return actual.(*structFields)
+}
+
+// dominantFields keeps a single field of every name in list, which is sorted
+// by name.
+func dominantFields(list []field) []field {
+	dominant := []field{}
+	for start := 0; start < len(list); {
+		end := start + 1
+		for end < len(list) && list[end].name == list[start].name {
+			end++
+		}
+		candidates := []field{}
+		for _, f := range list[start:end] {
+			if f.tagged {
+				candidates = append(candidates, f)
+			}
+		}
+		if len(candidates) == 0 {
+			candidates = list[start:end]
+		}
+		if len(candidates) == 1 {
+			dominant = append(dominant, candidates[0])
+		}
+		start = end
+	}
+	return dominant
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrInvalidJSON = errors.New("JSON value has no bencode representation")
+
+// BinaryFormat selects how byte strings that are not valid UTF-8 are written
+// in JSON.
+type BinaryFormat int
+
+const (
+	BinaryHex BinaryFormat = iota
+	BinaryBase64
+)
+
+// ToJSON converts a decoded bencode value (string, int, *big.Int, List or
+// Dict) to JSON in a form that FromJSON turns back into the same value.
+//
+// UTF-8 strings become JSON strings and other byte strings become
+// {"$hex": "..."} or {"$base64": "..."} objects. Dictionary keys that are
+// not UTF-8 are written as "$hex:..." or "$base64:...", and keys that start
+// with "$" get a second "$" so they cannot be mistaken for either.
+func ToJSON(v any, format BinaryFormat) ([]byte, error) {
+	jsonValue, err := toJSONValue(v, format)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonValue)
+}
+
+func toJSONValue(v any, format BinaryFormat) (any, error) {
+	switch v := v.(type) {
+	case string:
+		if utf8.ValidString(v) {
+			return v, nil
+		}
+		if format == BinaryBase64 {
+			return map[string]string{"$base64": base64.StdEncoding.EncodeToString([]byte(v))}, nil
+		}
+		return map[string]string{"$hex": hex.EncodeToString([]byte(v))}, nil
+	case int, *big.Int:
+		return v, nil
+	case List:
+		list := make([]any, 0, len(v))
+		for _, elem := range v {
+			jsonElem, err := toJSONValue(elem, format)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, jsonElem)
+		}
+		return list, nil
+	case Dict:
+		dict := make(map[string]any, len(v))
+		for key, value := range v {
+			jsonValue, err := toJSONValue(value, format)
+			if err != nil {
+				return nil, err
+			}
+			dict[toJSONKey(key, format)] = jsonValue
+		}
+		return dict, nil
+	}
+	return nil, ErrUnsupportedType
+}
+
+func toJSONKey(key string, format BinaryFormat) string {
+	switch {
+	case !utf8.ValidString(key) && format == BinaryBase64:
+		return "$base64:" + base64.StdEncoding.EncodeToString([]byte(key))
+	case !utf8.ValidString(key):
+		return "$hex:" + hex.EncodeToString([]byte(key))
+	case strings.HasPrefix(key, "$"):
+		return "$" + key
+	}
+	return key
+}
+
+// FromJSON converts JSON produced by ToJSON, or written by hand in the same
+// form, back into a value that Marshal encodes as bencode. Numbers must be
+// integers; booleans and null have no bencode representation.
+func FromJSON(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var jsonValue any
+	if err := decoder.Decode(&jsonValue); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, ErrTrailingData
+	}
+	return fromJSONValue(jsonValue)
+}
+
+func fromJSONValue(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		if integer, ok := new(big.Int).SetString(v.String(), 10); ok {
+			if integer.IsInt64() {
+				return int(integer.Int64()), nil
+			}
+			return integer, nil
+		}
+		return nil, fmt.Errorf("%w: %s is not an integer", ErrInvalidJSON, v)
+	case []any:
+		list := make(List, 0, len(v))
+		for _, elem := range v {
+			bencodeElem, err := fromJSONValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, bencodeElem)
+		}
+		return list, nil
+	case map[string]any:
+		if str, isBinary, err := fromJSONBinary(v); isBinary {
+			return str, err
+		}
+		dict := make(Dict, len(v))
+		for key, value := range v {
+			bencodeKey, err := fromJSONKey(key)
+			if err != nil {
+				return nil, err
+			}
+			bencodeValue, err := fromJSONValue(value)
+			if err != nil {
+				return nil, err
+			}
+			dict[bencodeKey] = bencodeValue
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrInvalidJSON, v)
+}
+
+// fromJSONBinary decodes a {"$hex": ...} or {"$base64": ...} object, and
+// reports whether v was one.
+func fromJSONBinary(v map[string]any) (string, bool, error) {
+	if len(v) != 1 {
+		return "", false, nil
+	}
+	for key, value := range v {
+		encoded, isString := value.(string)
+		if !isString || (key != "$hex" && key != "$base64") {
+			return "", false, nil
+		}
+		decoded, err := decodeBinary(key[1:], encoded)
+		return decoded, true, err
+	}
+	return "", false, nil
+}
+
+func fromJSONKey(key string) (string, error) {
+	switch {
+	case strings.HasPrefix(key, "$$"):
+		return key[1:], nil
+	case strings.HasPrefix(key, "$hex:"):
+		return decodeBinary("hex", key[len("$hex:"):])
+	case strings.HasPrefix(key, "$base64:"):
+		return decodeBinary("base64", key[len("$base64:"):])
+	}
+	return key, nil
+}
+
+func decodeBinary(format string, encoded string) (string, error) {
+	var decoded []byte
+	var err error
+	if format == "hex" {
+		decoded, err = hex.DecodeString(encoded)
+	} else {
+		decoded, err = base64.StdEncoding.DecodeString(encoded)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid %s string: %s", ErrInvalidJSON, format, err.Error())
+	}
+	return string(decoded), nil
+}
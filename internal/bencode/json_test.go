@@ -0,0 +1,119 @@
+package bencode
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type jsonTestCase struct {
+	name         string
+	input        string
+	format       BinaryFormat
+	expectedJSON string
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	testCases := []*jsonTestCase{
+		{
+			name:         "utf-8 strings stay strings",
+			input:        "d3:foo3:bar5:hello4:wöre",
+			expectedJSON: `{"foo":"bar","hello":"wör"}`,
+		},
+		{
+			name:         "binary string as hex",
+			input:        "l3:\x00\x01\xffi-5ee",
+			expectedJSON: `[{"$hex":"0001ff"},-5]`,
+		},
+		{
+			name:         "binary string as base64",
+			input:        "l3:\x00\x01\xffe",
+			format:       BinaryBase64,
+			expectedJSON: `[{"$base64":"AAH/"}]`,
+		},
+		{
+			name:         "binary and dollar prefixed keys",
+			input:        "d4:$hex1:a2:\xff\xfe1:be",
+			expectedJSON: `{"$$hex":"a","$hex:fffe":"b"}`,
+		},
+		{
+			name:         "big integers",
+			input:        "i123456789012345678901234567890e",
+			expectedJSON: `123456789012345678901234567890`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			decoder := NewDecoder(strings.NewReader(tc.input))
+			decoder.UseBigInt()
+			var decoded any
+			if err := decoder.Decode(&decoded); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			jsonOutput, err := ToJSON(decoded, tc.format)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(jsonOutput) != tc.expectedJSON {
+				t.Fatalf("expected JSON %s, got %s", tc.expectedJSON, jsonOutput)
+			}
+
+			value, err := FromJSON(jsonOutput)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			encoded, err := Marshal(value)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(encoded) != tc.input {
+				t.Fatalf("expected round trip to %q, got %q", tc.input, encoded)
+			}
+		})
+	}
+}
+
+type fromJSONErrorTestCase struct {
+	name  string
+	input string
+	err   error
+}
+
+func TestFromJSONErrors(t *testing.T) {
+	testCases := []*fromJSONErrorTestCase{
+		{
+			name:  "floats",
+			input: `[1.5]`,
+			err:   ErrInvalidJSON,
+		},
+		{
+			name:  "booleans",
+			input: `{"private":true}`,
+			err:   ErrInvalidJSON,
+		},
+		{
+			name:  "null",
+			input: `null`,
+			err:   ErrInvalidJSON,
+		},
+		{
+			name:  "invalid hex",
+			input: `{"$hex":"zz"}`,
+			err:   ErrInvalidJSON,
+		},
+		{
+			name:  "trailing values",
+			input: `1 2`,
+			err:   ErrTrailingData,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := FromJSON([]byte(tc.input))
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrLimitExceeded = errors.New("decoder limit exceeded")
+
+// Limits bound the resources a Decoder spends on a single top-level value,
+// so that hostile input such as a tracker response or peer extension message
+// cannot exhaust the stack or memory. A zero field means no limit.
+type Limits struct {
+	// MaxDepth caps how deeply lists and dictionaries may nest.
+	MaxDepth int
+	// MaxStringLength caps the declared length of a single string.
+	MaxStringLength int64
+	// MaxIntegerDigits caps the number of digits in a single integer, which
+	// matters with UseBigInt, where parsing costs grow with the length.
+	MaxIntegerDigits int
+	// MaxAllocBytes caps the combined size of all strings and integers.
+	MaxAllocBytes int64
+	// MaxElements caps the number of items in one list, or keys in one
+	// dictionary.
+	MaxElements int
+}
+
+// DefaultLimits are used by NewDecoder. They are generous enough for any
+// real metainfo file while keeping nesting from overflowing the stack.
+var DefaultLimits = Limits{
+	MaxDepth:         512,
+	MaxStringLength:  256 << 20,
+	MaxIntegerDigits: 1024,
+	MaxAllocBytes:    1 << 30,
+	MaxElements:      1 << 22,
+}
+
+// LimitError reports input that exceeds one of a decoder's Limits. It
+// matches ErrLimitExceeded with errors.Is.
+type LimitError struct {
+	// byte offset of the start of the offending token
+	Offset int64
+	// position in the enclosing containers, e.g. info.files[3].path
+	Path string
+	// name of the exceeded Limits field, e.g. MaxDepth
+	Limit string
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("bencode: %s of %d exceeded at offset %d", e.Limit, e.Max, e.Offset)
+	}
+	return fmt.Sprintf("bencode: %s of %d exceeded at offset %d in %s", e.Limit, e.Max, e.Offset, e.Path)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// SetLimits replaces the decoder's limits.
+func (d *Decoder) SetLimits(limits Limits) {
+	d.limits = limits
+}
+
+func (d *Decoder) limitError(limit string, max int64) error {
+	return &LimitError{Offset: d.tokenStart, Path: d.path(), Limit: limit, Max: max}
+}
+
+// checkElementLimits is called with the first byte of the next token, before
+// anything is pushed or read.
+func (d *Decoder) checkElementLimits(c byte) error {
+	top := d.top()
+	if top == nil {
+		// a new top-level value starts with a fresh allocation budget
+		d.allocated = 0
+	} else if c != 'e' && (top.kind == 'l' || top.wantKey) {
+		if d.limits.MaxElements > 0 && top.index >= d.limits.MaxElements {
+			return d.limitError("MaxElements", int64(d.limits.MaxElements))
+		}
+	}
+
+	if (c == 'l' || c == 'd') && d.limits.MaxDepth > 0 && len(d.stack) >= d.limits.MaxDepth {
+		return d.limitError("MaxDepth", int64(d.limits.MaxDepth))
+	}
+	return nil
+}
+
+// allocate accounts for n bytes of string or integer data about to be read.
+func (d *Decoder) allocate(n int64) error {
+	if d.limits.MaxStringLength > 0 && n > d.limits.MaxStringLength {
+		return d.limitError("MaxStringLength", d.limits.MaxStringLength)
+	}
+	d.allocated += n
+	if d.limits.MaxAllocBytes > 0 && d.allocated > d.limits.MaxAllocBytes {
+		return d.limitError("MaxAllocBytes", d.limits.MaxAllocBytes)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package bencode
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+type limitTestCase struct {
+	name          string
+	input         io.Reader
+	limits        Limits
+	expectedLimit string
+}
+
+// repeatReader yields prefix, then body repeated n times, then suffix,
+// without materializing the whole input.
+func repeatReader(prefix string, body string, n int, suffix string) io.Reader {
+	readers := []io.Reader{strings.NewReader(prefix)}
+	chunk := strings.Repeat(body, 1024)
+	for ; n >= 1024; n -= 1024 {
+		readers = append(readers, strings.NewReader(chunk))
+	}
+	readers = append(readers, strings.NewReader(strings.Repeat(body, n)), strings.NewReader(suffix))
+	return io.MultiReader(readers...)
+}
+
+func TestDecoderLimits(t *testing.T) {
+	testCases := []*limitTestCase{
+		{
+			name:          "deep nesting with default limits",
+			input:         repeatReader("", "l", 1_000_000, ""),
+			limits:        DefaultLimits,
+			expectedLimit: "MaxDepth",
+		},
+		{
+			name:          "deeply nested dictionaries",
+			input:         repeatReader("", "d1:a", 100, ""),
+			limits:        Limits{MaxDepth: 10},
+			expectedLimit: "MaxDepth",
+		},
+		{
+			name:          "declared string length far beyond the input",
+			input:         strings.NewReader("9999999999999:spam"),
+			limits:        DefaultLimits,
+			expectedLimit: "MaxStringLength",
+		},
+		{
+			name:          "huge integer",
+			input:         repeatReader("i", "9", 1<<20, "e"),
+			limits:        DefaultLimits,
+			expectedLimit: "MaxIntegerDigits",
+		},
+		{
+			name:          "many small strings",
+			input:         repeatReader("l", "4:spam", 10_000, "e"),
+			limits:        Limits{MaxAllocBytes: 1000},
+			expectedLimit: "MaxAllocBytes",
+		},
+		{
+			name:          "too many list elements",
+			input:         repeatReader("l", "i0e", 100_000, "e"),
+			limits:        Limits{MaxElements: 1000},
+			expectedLimit: "MaxElements",
+		},
+		{
+			name:          "too many dictionary keys",
+			input:         repeatReader("d", "0:0:", 2, "e"),
+			limits:        Limits{MaxElements: 1},
+			expectedLimit: "MaxElements",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			decoder := NewDecoder(tc.input)
+			decoder.SetLimits(tc.limits)
+
+			var decoded any
+			err := decoder.Decode(&decoded)
+			if !errors.Is(err, ErrLimitExceeded) {
+				t.Fatalf("expected limit error, got %v", err)
+			}
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) || limitErr.Limit != tc.expectedLimit {
+				t.Fatalf("expected %s to be exceeded, got %v", tc.expectedLimit, err)
+			}
+		})
+	}
+}
+
+func TestDecoderLimitsAllowInputWithinBounds(t *testing.T) {
+	limits := Limits{MaxDepth: 2, MaxStringLength: 4, MaxAllocBytes: 9, MaxElements: 2}
+
+	decoder := NewDecoder(strings.NewReader("d1:ali1e4:spame1:bi12ee" + "d1:ali1e4:spame1:bi12ee"))
+	decoder.SetLimits(limits)
+	for i := 0; i < 2; i++ {
+		var decoded any
+		if err := decoder.Decode(&decoded); err != nil {
+			t.Fatalf("unexpected error decoding value %d: %s", i, err)
+		}
+	}
+}
+
+func TestDecoderLimitErrorPosition(t *testing.T) {
+	decoder := NewDecoder(strings.NewReader("d4:infod4:filel3:abc5:abcdeee"))
+	decoder.SetLimits(Limits{MaxStringLength: 4})
+
+	var decoded any
+	err := decoder.Decode(&decoded)
+	expected := "bencode: MaxStringLength of 4 exceeded at offset 20 in info.file[1]"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+}
@@ -0,0 +1,291 @@
+package bencode
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+type testFileEntry struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+	MD5Sum string   `bencode:"md5sum,omitempty"`
+}
+
+type testInfo struct {
+	Name        string          `bencode:"name"`
+	PieceLength int64           `bencode:"piece length"`
+	Pieces      []byte          `bencode:"pieces"`
+	Private     *uint8          `bencode:"private,omitempty"`
+	Files       []testFileEntry `bencode:"files,omitempty"`
+	ignored     int
+	Skipped     string `bencode:"-"`
+}
+
+type testMetainfo struct {
+	Announce string                `bencode:"announce"`
+	Info     *testInfo             `bencode:"info"`
+	Extra    map[string]RawMessage `bencode:"extra,omitempty"`
+	Comment  string                `bencode:"comment,omitempty"`
+}
+
+type marshalTestCase struct {
+	name     string
+	input    any
+	expected string
+	err      error
+}
+
+func TestMarshal(t *testing.T) {
+	private := uint8(1)
+	testCases := []*marshalTestCase{
+		{
+			name:     "string",
+			input:    "spam",
+			expected: "4:spam",
+		},
+		{
+			name:     "empty string",
+			input:    "",
+			expected: "0:",
+		},
+		{
+			name:     "byte slice",
+			input:    []byte{0, 1, 2},
+			expected: "3:\x00\x01\x02",
+		},
+		{
+			name:     "unsigned integer",
+			input:    uint64(1 << 63),
+			expected: "i9223372036854775808e",
+		},
+		{
+			name:     "typed list",
+			input:    []int32{1, -2},
+			expected: "li1ei-2ee",
+		},
+		{
+			name:     "map with sorted keys",
+			input:    map[string]int{"b": 2, "a": 1},
+			expected: "d1:ai1e1:bi2ee",
+		},
+		{
+			name: "struct with tags, sorted keys and omitted fields",
+			input: testMetainfo{
+				Announce: "http://tracker",
+				Info: &testInfo{
+					Name:        "a",
+					PieceLength: 2,
+					Pieces:      []byte("xx"),
+					Private:     &private,
+					Skipped:     "nope",
+				},
+			},
+			expected: "d8:announce14:http://tracker4:infod4:name1:a12:piece lengthi2e6:pieces2:xx7:privatei1eee",
+		},
+		{
+			name:     "raw message written verbatim",
+			input:    map[string]RawMessage{"k": RawMessage("li1ee")},
+			expected: "d1:kli1eee",
+		},
+		{
+			name: "tagged field wins over an untagged one of the same name",
+			input: struct {
+				A int
+				B int `bencode:"A"`
+			}{A: 1, B: 2},
+			expected: "d1:Ai2ee",
+		},
+		{
+			name: "fields tagged with the same name left out",
+			input: struct {
+				A int `bencode:"a"`
+				B int `bencode:"a"`
+				C int `bencode:"c"`
+			}{A: 1, B: 2, C: 3},
+			expected: "d1:ci3ee",
+		},
+		{
+			name: "omitempty among other options",
+			input: struct {
+				A int `bencode:"a,string,omitempty"`
+				B int `bencode:"b,omitempty,string"`
+			}{},
+			expected: "de",
+		},
+		{
+			name:     "nil pointer left out of dictionary",
+			input:    testMetainfo{Announce: "x"},
+			expected: "d8:announce1:xe",
+		},
+		{
+			name:  "floats are unsupported",
+			input: 1.5,
+			err:   ErrUnsupportedType,
+		},
+		{
+			name:  "maps need string keys",
+			input: map[int]int{1: 1},
+			err:   ErrUnsupportedType,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := Marshal(tc.input)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(actual) != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestMarshalUnsupportedTypeDetail(t *testing.T) {
+	_, err := Marshal(map[string]any{"a": []any{1, 1.5}})
+	if !errors.Is(err, ErrUnsupportedType) || err.Error() != "type is unsupported by bencode: float64" {
+		t.Fatalf("expected the unsupported type named, got %v", err)
+	}
+}
+
+func TestUnmarshalStruct(t *testing.T) {
+	input := "d8:announce3:url5:extrad1:ali1eee4:infod5:filesld6:lengthi5e4:pathl1:a1:beee4:name1:n12:piece lengthi16384e6:pieces2:\x00\xff7:privatei1e7:unknownd1:xi1eeee"
+
+	var actual testMetainfo
+	if err := Unmarshal([]byte(input), &actual); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	private := uint8(1)
+	expected := testMetainfo{
+		Announce: "url",
+		Extra:    map[string]RawMessage{"a": RawMessage("li1ee")},
+		Info: &testInfo{
+			Name:        "n",
+			PieceLength: 16384,
+			Pieces:      []byte{0, 0xff},
+			Private:     &private,
+			Files:       []testFileEntry{{Length: 5, Path: []string{"a", "b"}}},
+		},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, actual)
+	}
+}
+
+func TestUnmarshalRoundTrip(t *testing.T) {
+	input := testMetainfo{
+		Announce: "http://tracker",
+		Comment:  "hello",
+		Info: &testInfo{
+			Name:        "file",
+			PieceLength: 1 << 18,
+			Pieces:      []byte("0123456789abcdefghij"),
+			Files:       []testFileEntry{{Length: 1, Path: []string{"dir", "f"}, MD5Sum: "abc"}},
+		},
+	}
+
+	encoded, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var decoded testMetainfo
+	if err := Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(input, decoded) {
+		t.Fatalf("expected %+v, got %+v", input, decoded)
+	}
+}
+
+func TestUnmarshalBigIntegersRoundTrip(t *testing.T) {
+	type bigIntegers struct {
+		Max  uint64   `bencode:"max"`
+		Huge *big.Int `bencode:"huge"`
+		Big  big.Int  `bencode:"big"`
+	}
+	huge, _ := new(big.Int).SetString("-99999999999999999999999", 10)
+	input := bigIntegers{Max: math.MaxUint64, Huge: huge}
+	input.Big.Lsh(big.NewInt(1), 100)
+
+	encoded, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var decoded bigIntegers
+	if err := Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if decoded.Max != input.Max || decoded.Huge.Cmp(input.Huge) != 0 || decoded.Big.Cmp(&input.Big) != 0 {
+		t.Fatalf("expected %+v, got %+v", input, decoded)
+	}
+
+	var max uint64
+	encoded, err = Marshal(uint64(math.MaxUint64))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := Unmarshal(encoded, &max); err != nil || max != math.MaxUint64 {
+		t.Fatalf("expected %d, got %d and error %v", uint64(math.MaxUint64), max, err)
+	}
+}
+
+type unmarshalErrorTestCase struct {
+	name   string
+	input  string
+	target any
+	err    error
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	testCases := []*unmarshalErrorTestCase{
+		{
+			name:   "integer overflows field",
+			input:  "i300e",
+			target: new(uint8),
+			err:    ErrTypeMismatch,
+		},
+		{
+			name:   "negative integer into unsigned field",
+			input:  "i-1e",
+			target: new(uint),
+			err:    ErrTypeMismatch,
+		},
+		{
+			name:   "list into struct",
+			input:  "le",
+			target: new(testInfo),
+			err:    ErrTypeMismatch,
+		},
+		{
+			name:   "trailing data",
+			input:  "i1ei2e",
+			target: new(int),
+			err:    ErrTrailingData,
+		},
+		{
+			name:   "unterminated struct",
+			input:  "d4:name1:n",
+			target: new(testInfo),
+			err:    ErrUnterminatedDictionary,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Unmarshal([]byte(tc.input), tc.target)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
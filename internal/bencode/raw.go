@@ -0,0 +1,9 @@
+package bencode
+
+import "reflect"
+
+// RawMessage is a raw encoded bencode value. Decoding into a RawMessage keeps
+// the exact input bytes of the value, and encoding one writes it verbatim.
+type RawMessage []byte
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
@@ -0,0 +1,180 @@
+// Package magnet parses magnet links identifying a torrent by its info hash.
+package magnet
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const btihPrefix = "urn:btih:"
+
+var ErrNotMagnet = errors.New("not a magnet link")
+var ErrMissingInfoHash = errors.New("magnet link has no urn:btih info hash")
+var ErrInvalidInfoHash = errors.New("invalid info hash in magnet link")
+var ErrInvalidSelectOnly = errors.New("invalid so file selection in magnet link")
+
+// Link is a parsed magnet link.
+type Link struct {
+	InfoHash [20]byte
+	// dn, a name to show until the metadata has been fetched
+	DisplayName string
+	// tr, tracker announce URLs
+	Trackers []string
+	// x.pe, peer addresses as host:port
+	Peers []string
+	// so, ranges of indices of the files to download, or nil for all of them
+	SelectOnly []FileRange
+}
+
+// FileRange is an inclusive range of file indices, kept unexpanded since the
+// number of files is unknown until the metadata has been fetched.
+type FileRange struct {
+	First int
+	Last  int
+}
+
+// SelectedFiles returns the indices of the files to download out of numFiles,
+// in increasing order and without duplicates.
+func (l *Link) SelectedFiles(numFiles int) []int {
+	if l.SelectOnly == nil {
+		indices := make([]int, numFiles)
+		for i := range indices {
+			indices[i] = i
+		}
+		return indices
+	}
+	selected := make([]bool, numFiles)
+	for _, r := range l.SelectOnly {
+		for i := r.First; i <= r.Last && i < numFiles; i++ {
+			selected[i] = true
+		}
+	}
+	indices := []int{}
+	for i, isSelected := range selected {
+		if isSelected {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// Parse parses a magnet:?xt=urn:btih:... link. The info hash may be written
+// as 40 hex digits or 32 base32 characters.
+func Parse(uri string) (*Link, error) {
+	scheme, rawQuery, found := strings.Cut(uri, ":?")
+	if !found || !strings.EqualFold(scheme, "magnet") {
+		return nil, ErrNotMagnet
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotMagnet, err.Error())
+	}
+
+	link := &Link{
+		DisplayName: query.Get("dn"),
+		Trackers:    query["tr"],
+		Peers:       query["x.pe"],
+	}
+
+	foundInfoHash := false
+	for _, xt := range query["xt"] {
+		// other hash types, such as v2 urn:btmh:, may be listed alongside
+		if !strings.HasPrefix(strings.ToLower(xt), btihPrefix) {
+			continue
+		}
+		infoHash, err := parseInfoHash(xt[len(btihPrefix):])
+		if err != nil {
+			return nil, err
+		}
+		link.InfoHash = infoHash
+		foundInfoHash = true
+	}
+	if !foundInfoHash {
+		return nil, ErrMissingInfoHash
+	}
+
+	if so := query.Get("so"); so != "" {
+		link.SelectOnly, err = parseSelectOnly(so)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return link, nil
+}
+
+func parseInfoHash(encoded string) ([20]byte, error) {
+	var infoHash [20]byte
+	var decoded []byte
+	var err error
+	switch len(encoded) {
+	case 40:
+		decoded, err = hex.DecodeString(encoded)
+	case 32:
+		decoded, err = base32.StdEncoding.DecodeString(strings.ToUpper(encoded))
+	default:
+		return infoHash, fmt.Errorf("%w: %q", ErrInvalidInfoHash, encoded)
+	}
+	if err != nil {
+		return infoHash, fmt.Errorf("%w: %q", ErrInvalidInfoHash, encoded)
+	}
+	copy(infoHash[:], decoded)
+	return infoHash, nil
+}
+
+// parseSelectOnly parses a BEP 53 file selection such as "0,2,4-6".
+func parseSelectOnly(so string) ([]FileRange, error) {
+	ranges := []FileRange{}
+	for _, part := range strings.Split(so, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSelectOnly, so)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(last)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidSelectOnly, so)
+			}
+		}
+		ranges = append(ranges, FileRange{First: start, Last: end})
+	}
+	return ranges, nil
+}
+
+// HexInfoHash returns the info hash as 40 lowercase hex digits.
+func (l *Link) HexInfoHash() string {
+	return hex.EncodeToString(l.InfoHash[:])
+}
+
+// String formats the link as a magnet URI with a hex info hash.
+func (l *Link) String() string {
+	params := []string{"xt=" + btihPrefix + l.HexInfoHash()}
+	if l.DisplayName != "" {
+		params = append(params, "dn="+url.QueryEscape(l.DisplayName))
+	}
+	for _, tracker := range l.Trackers {
+		params = append(params, "tr="+url.QueryEscape(tracker))
+	}
+	for _, peer := range l.Peers {
+		params = append(params, "x.pe="+url.QueryEscape(peer))
+	}
+	if len(l.SelectOnly) > 0 {
+		ranges := []string{}
+		for _, r := range l.SelectOnly {
+			if r.First == r.Last {
+				ranges = append(ranges, strconv.Itoa(r.First))
+			} else {
+				ranges = append(ranges, strconv.Itoa(r.First)+"-"+strconv.Itoa(r.Last))
+			}
+		}
+		params = append(params, "so="+strings.Join(ranges, ","))
+	}
+	return "magnet:?" + strings.Join(params, "&")
+}
@@ -0,0 +1,166 @@
+package magnet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type parseTestCase struct {
+	name        string
+	input       string
+	expected    *Link
+	expectedErr error
+}
+
+const sampleHexHash = "d69f91e6b2ae4c542468d1073a71d4ea13879a7f"
+
+var sampleInfoHash = [20]byte{
+	0xd6, 0x9f, 0x91, 0xe6, 0xb2, 0xae, 0x4c, 0x54, 0x24, 0x68,
+	0xd1, 0x07, 0x3a, 0x71, 0xd4, 0xea, 0x13, 0x87, 0x9a, 0x7f,
+}
+
+func TestParse(t *testing.T) {
+	testCases := []*parseTestCase{
+		{
+			name:     "hex info hash",
+			input:    "magnet:?xt=urn:btih:" + sampleHexHash,
+			expected: &Link{InfoHash: sampleInfoHash},
+		},
+		{
+			name:     "uppercase hex info hash",
+			input:    "magnet:?xt=urn:btih:D69F91E6B2AE4C542468D1073A71D4EA13879A7F",
+			expected: &Link{InfoHash: sampleInfoHash},
+		},
+		{
+			name:     "base32 info hash",
+			input:    "magnet:?xt=urn:btih:22PZDZVSVZGFIJDI2EDTU4OU5IJYPGT7",
+			expected: &Link{InfoHash: sampleInfoHash},
+		},
+		{
+			name:     "lowercase base32 info hash",
+			input:    "magnet:?xt=urn:btih:22pzdzvsvzgfijdi2edtu4ou5ijypgt7",
+			expected: &Link{InfoHash: sampleInfoHash},
+		},
+		{
+			name: "all parameters",
+			input: "magnet:?xt=urn:btih:" + sampleHexHash + "&dn=sample.txt" +
+				"&tr=http%3A%2F%2Fbittorrent-test-tracker.codecrafters.io%2Fannounce&tr=udp%3A%2F%2Ftracker.example%3A80" +
+				"&x.pe=127.0.0.1:6881&x.pe=%5B::1%5D:6882&so=0,2,4-6",
+			expected: &Link{
+				InfoHash:    sampleInfoHash,
+				DisplayName: "sample.txt",
+				Trackers:    []string{"http://bittorrent-test-tracker.codecrafters.io/announce", "udp://tracker.example:80"},
+				Peers:       []string{"127.0.0.1:6881", "[::1]:6882"},
+				SelectOnly:  []FileRange{{First: 0, Last: 0}, {First: 2, Last: 2}, {First: 4, Last: 6}},
+			},
+		},
+		{
+			name:     "v2 hash listed alongside",
+			input:    "magnet:?xt=urn:btmh:1220caf1e1c30e81cb361b9ee167c4aa64228a7fa4fa9f6105232b28ad099f3a302e&xt=urn:btih:" + sampleHexHash,
+			expected: &Link{InfoHash: sampleInfoHash},
+		},
+		{
+			name:        "not a magnet link",
+			input:       "sample.torrent",
+			expectedErr: ErrNotMagnet,
+		},
+		{
+			name:        "no info hash",
+			input:       "magnet:?dn=sample.txt",
+			expectedErr: ErrMissingInfoHash,
+		},
+		{
+			name:        "short info hash",
+			input:       "magnet:?xt=urn:btih:d69f91e6",
+			expectedErr: ErrInvalidInfoHash,
+		},
+		{
+			name:        "invalid hex",
+			input:       "magnet:?xt=urn:btih:z69f91e6b2ae4c542468d1073a71d4ea13879a7f",
+			expectedErr: ErrInvalidInfoHash,
+		},
+		{
+			name:  "huge so range kept unexpanded",
+			input: "magnet:?xt=urn:btih:" + sampleHexHash + "&so=0-4000000000",
+			expected: &Link{
+				InfoHash:   sampleInfoHash,
+				SelectOnly: []FileRange{{First: 0, Last: 4000000000}},
+			},
+		},
+		{
+			name:        "backwards so range",
+			input:       "magnet:?xt=urn:btih:" + sampleHexHash + "&so=4-2",
+			expectedErr: ErrInvalidSelectOnly,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			link, err := Parse(tc.input)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if !reflect.DeepEqual(link, tc.expected) {
+				t.Fatalf("expected %+v, got %+v", tc.expected, link)
+			}
+		})
+	}
+}
+
+func TestLinkStringRoundTrip(t *testing.T) {
+	link := &Link{
+		InfoHash:    sampleInfoHash,
+		DisplayName: "a name & more",
+		Trackers:    []string{"http://tracker.example/announce?key=1&x=2"},
+		Peers:       []string{"127.0.0.1:6881"},
+		SelectOnly:  []FileRange{{First: 1, Last: 1}, {First: 3, Last: 4000000000}},
+	}
+
+	parsed, err := Parse(link.String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(parsed, link) {
+		t.Fatalf("expected %+v, got %+v", link, parsed)
+	}
+}
+
+type selectedFilesTestCase struct {
+	name       string
+	selectOnly []FileRange
+	numFiles   int
+	expected   []int
+}
+
+func TestLinkSelectedFiles(t *testing.T) {
+	testCases := []*selectedFilesTestCase{
+		{
+			name:     "all files without so",
+			numFiles: 3,
+			expected: []int{0, 1, 2},
+		},
+		{
+			name:       "ranges clamped to the file count",
+			selectOnly: []FileRange{{First: 1, Last: 4000000000}},
+			numFiles:   3,
+			expected:   []int{1, 2},
+		},
+		{
+			name:       "overlapping ranges and missing files",
+			selectOnly: []FileRange{{First: 2, Last: 2}, {First: 0, Last: 2}, {First: 7, Last: 9}},
+			numFiles:   4,
+			expected:   []int{0, 1, 2},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			link := &Link{SelectOnly: tc.selectOnly}
+			actual := link.SelectedFiles(tc.numFiles)
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
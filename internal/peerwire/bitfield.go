@@ -0,0 +1,38 @@
+package peerwire
+
+// PieceBitfield has a bit set for every piece a peer has, the high bit of the
+// first byte standing for piece 0.
+type PieceBitfield []byte
+
+func NewPieceBitfield(numPieces int) PieceBitfield {
+	return make(PieceBitfield, (numPieces+7)/8)
+}
+
+// Has reports whether the bit of a piece is set. Pieces out of range are
+// never set.
+func (b PieceBitfield) Has(index int) bool {
+	if index < 0 || index/8 >= len(b) {
+		return false
+	}
+	return b[index/8]&(0x80>>(index%8)) != 0
+}
+
+// Set sets the bit of a piece, ignoring pieces out of range.
+func (b PieceBitfield) Set(index int) {
+	if index < 0 || index/8 >= len(b) {
+		return
+	}
+	b[index/8] |= 0x80 >> (index % 8)
+}
+
+// Valid reports whether the bitfield is sized for numPieces and leaves the
+// spare bits of the last byte clear, as BEP 3 requires.
+func (b PieceBitfield) Valid(numPieces int) bool {
+	if len(b) != (numPieces+7)/8 {
+		return false
+	}
+	if numPieces%8 == 0 {
+		return true
+	}
+	return b[len(b)-1]&(0xff>>(numPieces%8)) == 0
+}
@@ -0,0 +1,42 @@
+package peerwire
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPieceBitfield(t *testing.T) {
+	bitfield := NewPieceBitfield(10)
+	for _, index := range []int{0, 7, 9, -1, 16} {
+		bitfield.Set(index)
+	}
+	if !bytes.Equal(bitfield, []byte{0x81, 0x40}) {
+		t.Fatalf("unexpected bitfield %08b", bitfield)
+	}
+	for index, expected := range []bool{true, false, false, false, false, false, false, true, false, true, false} {
+		if bitfield.Has(index) != expected {
+			t.Fatalf("expected piece %d set to be %t", index, expected)
+		}
+	}
+}
+
+type validBitfieldTestCase struct {
+	bitfield  PieceBitfield
+	numPieces int
+	expected  bool
+}
+
+func TestPieceBitfieldValid(t *testing.T) {
+	testCases := []*validBitfieldTestCase{
+		{bitfield: PieceBitfield{0xff, 0xc0}, numPieces: 10, expected: true},
+		{bitfield: PieceBitfield{0xff, 0xe0}, numPieces: 10, expected: false},
+		{bitfield: PieceBitfield{0xff}, numPieces: 10, expected: false},
+		{bitfield: PieceBitfield{0xff, 0xff}, numPieces: 16, expected: true},
+		{bitfield: PieceBitfield{}, numPieces: 0, expected: true},
+	}
+	for _, tc := range testCases {
+		if valid := tc.bitfield.Valid(tc.numPieces); valid != tc.expected {
+			t.Fatalf("expected %08b to be valid for %d pieces: %t, got %t", tc.bitfield, tc.numPieces, tc.expected, valid)
+		}
+	}
+}
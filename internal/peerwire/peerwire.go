@@ -0,0 +1,205 @@
+// Package peerwire reads and writes the length-prefixed messages peers
+// exchange after the handshake (BEP 3).
+package peerwire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type MessageID uint8
+
+const (
+	Choke MessageID = iota
+	Unchoke
+	Interested
+	NotInterested
+	Have
+	Bitfield
+	Request
+	Piece
+	Cancel
+	// Port carries the peer's DHT port (BEP 5)
+	Port
+)
+
+// Extended carries extension protocol messages (BEP 10). The first payload
+// byte is the extended message id, 0 being the extension handshake.
+const Extended MessageID = 20
+
+// MaxLength is the longest message, length prefix excluded, that is read or
+// written. It leaves room for a 16 KiB block and for the bitfields of
+// torrents with millions of pieces.
+const MaxLength = 1 << 20
+
+var ErrMessageTooLong = errors.New("peer message too long")
+var ErrInvalidLength = errors.New("invalid peer message length")
+
+var messageNames = map[MessageID]string{
+	Choke:         "choke",
+	Unchoke:       "unchoke",
+	Interested:    "interested",
+	NotInterested: "not interested",
+	Have:          "have",
+	Bitfield:      "bitfield",
+	Request:       "request",
+	Piece:         "piece",
+	Cancel:        "cancel",
+	Port:          "port",
+	Extended:      "extended",
+}
+
+// payload lengths of the messages that have a fixed length
+var payloadLengths = map[MessageID]int{
+	Choke:         0,
+	Unchoke:       0,
+	Interested:    0,
+	NotInterested: 0,
+	Have:          4,
+	Request:       12,
+	Cancel:        12,
+	Port:          2,
+}
+
+func (id MessageID) String() string {
+	if name, known := messageNames[id]; known {
+		return name
+	}
+	return fmt.Sprintf("message %d", uint8(id))
+}
+
+// Message is a single peer message. Which fields are used depends on ID;
+// messages with an unknown id keep their whole payload in Payload.
+type Message struct {
+	ID MessageID
+	// piece index of have, request, piece and cancel
+	Index uint32
+	// offset within the piece of request, piece and cancel
+	Begin uint32
+	// block length of request and cancel
+	Length uint32
+	// bits of bitfield, block of piece, body of extended messages
+	Payload []byte
+	// DHT port of port
+	Port uint16
+}
+
+// ReadMessage reads the next message from r. A keep-alive is returned as a
+// nil message.
+func ReadMessage(r io.Reader) (*Message, error) {
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(lengthBytes)
+	if length == 0 {
+		return nil, nil
+	}
+	if length > MaxLength {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMessageTooLong, length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return parseMessage(MessageID(body[0]), body[1:])
+}
+
+func parseMessage(id MessageID, payload []byte) (*Message, error) {
+	expectedLength, fixedLength := payloadLengths[id]
+	if (fixedLength && len(payload) != expectedLength) || (id == Piece && len(payload) < 8) {
+		return nil, fmt.Errorf("%w: %d byte %s", ErrInvalidLength, len(payload), id)
+	}
+
+	m := &Message{ID: id}
+	switch id {
+	case Choke, Unchoke, Interested, NotInterested:
+	case Have:
+		m.Index = binary.BigEndian.Uint32(payload)
+	case Request, Cancel:
+		m.Index = binary.BigEndian.Uint32(payload[0:4])
+		m.Begin = binary.BigEndian.Uint32(payload[4:8])
+		m.Length = binary.BigEndian.Uint32(payload[8:12])
+	case Piece:
+		m.Index = binary.BigEndian.Uint32(payload[0:4])
+		m.Begin = binary.BigEndian.Uint32(payload[4:8])
+		m.Payload = payload[8:]
+	case Port:
+		m.Port = binary.BigEndian.Uint16(payload)
+	default:
+		m.Payload = payload
+	}
+	return m, nil
+}
+
+// MarshalBinary encodes the message along with its length prefix. A nil
+// message encodes as a keep-alive.
+func (m *Message) MarshalBinary() ([]byte, error) {
+	if m == nil {
+		return make([]byte, 4), nil
+	}
+
+	// the length prefix is filled in once the body is known
+	message := make([]byte, 4, 4+1+12+len(m.Payload))
+	message = append(message, byte(m.ID))
+	switch m.ID {
+	case Choke, Unchoke, Interested, NotInterested:
+	case Have:
+		message = binary.BigEndian.AppendUint32(message, m.Index)
+	case Request, Cancel:
+		message = binary.BigEndian.AppendUint32(message, m.Index)
+		message = binary.BigEndian.AppendUint32(message, m.Begin)
+		message = binary.BigEndian.AppendUint32(message, m.Length)
+	case Piece:
+		message = binary.BigEndian.AppendUint32(message, m.Index)
+		message = binary.BigEndian.AppendUint32(message, m.Begin)
+		message = append(message, m.Payload...)
+	case Port:
+		message = binary.BigEndian.AppendUint16(message, m.Port)
+	default:
+		message = append(message, m.Payload...)
+	}
+
+	length := len(message) - 4
+	if length > MaxLength {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMessageTooLong, length)
+	}
+	binary.BigEndian.PutUint32(message[0:4], uint32(length))
+	return message, nil
+}
+
+// WriteTo writes the message to w in a single write. A nil message is
+// written as a keep-alive.
+func (m *Message) WriteTo(w io.Writer) (int64, error) {
+	message, err := m.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(message)
+	return int64(n), err
+}
+
+func (m *Message) String() string {
+	if m == nil {
+		return "keep-alive"
+	}
+	switch m.ID {
+	case Have:
+		return fmt.Sprintf("have %d", m.Index)
+	case Request, Cancel:
+		return fmt.Sprintf("%s %d+%d length %d", m.ID, m.Index, m.Begin, m.Length)
+	case Piece:
+		return fmt.Sprintf("piece %d+%d length %d", m.Index, m.Begin, len(m.Payload))
+	case Port:
+		return fmt.Sprintf("port %d", m.Port)
+	case Choke, Unchoke, Interested, NotInterested:
+		return m.ID.String()
+	}
+	return fmt.Sprintf("%s with %d byte payload", m.ID, len(m.Payload))
+}
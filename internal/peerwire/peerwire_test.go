@@ -0,0 +1,139 @@
+package peerwire
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type roundTripTestCase struct {
+	name    string
+	message *Message
+	encoded []byte
+}
+
+func TestRoundTrip(t *testing.T) {
+	testCases := []*roundTripTestCase{
+		{name: "keep-alive", message: nil, encoded: []byte{0, 0, 0, 0}},
+		{name: "choke", message: &Message{ID: Choke}, encoded: []byte{0, 0, 0, 1, 0}},
+		{name: "unchoke", message: &Message{ID: Unchoke}, encoded: []byte{0, 0, 0, 1, 1}},
+		{name: "interested", message: &Message{ID: Interested}, encoded: []byte{0, 0, 0, 1, 2}},
+		{name: "not interested", message: &Message{ID: NotInterested}, encoded: []byte{0, 0, 0, 1, 3}},
+		{
+			name:    "have",
+			message: &Message{ID: Have, Index: 0x01020304},
+			encoded: []byte{0, 0, 0, 5, 4, 1, 2, 3, 4},
+		},
+		{
+			name:    "bitfield",
+			message: &Message{ID: Bitfield, Payload: []byte{0xff, 0x80}},
+			encoded: []byte{0, 0, 0, 3, 5, 0xff, 0x80},
+		},
+		{
+			name:    "request",
+			message: &Message{ID: Request, Index: 1, Begin: 16384, Length: 16384},
+			encoded: []byte{0, 0, 0, 13, 6, 0, 0, 0, 1, 0, 0, 0x40, 0, 0, 0, 0x40, 0},
+		},
+		{
+			name:    "piece",
+			message: &Message{ID: Piece, Index: 2, Begin: 0, Payload: []byte("block")},
+			encoded: append([]byte{0, 0, 0, 14, 7, 0, 0, 0, 2, 0, 0, 0, 0}, "block"...),
+		},
+		{
+			name:    "cancel",
+			message: &Message{ID: Cancel, Index: 1, Begin: 16384, Length: 100},
+			encoded: []byte{0, 0, 0, 13, 8, 0, 0, 0, 1, 0, 0, 0x40, 0, 0, 0, 0, 100},
+		},
+		{
+			name:    "port",
+			message: &Message{ID: Port, Port: 6881},
+			encoded: []byte{0, 0, 0, 3, 9, 0x1a, 0xe1},
+		},
+		{
+			name:    "extended",
+			message: &Message{ID: Extended, Payload: []byte{0, 'd', 'e'}},
+			encoded: []byte{0, 0, 0, 4, 20, 0, 'd', 'e'},
+		},
+		{
+			name:    "unknown",
+			message: &Message{ID: 13, Payload: []byte{1, 2, 3, 4}},
+			encoded: []byte{0, 0, 0, 5, 13, 1, 2, 3, 4},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := tc.message.WriteTo(&buf)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if n != int64(len(tc.encoded)) || !bytes.Equal(buf.Bytes(), tc.encoded) {
+				t.Fatalf("expected %v, got %v (%d bytes)", tc.encoded, buf.Bytes(), n)
+			}
+
+			decoded, err := ReadMessage(&buf)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(decoded, tc.message) {
+				t.Fatalf("expected %v, got %v", tc.message, decoded)
+			}
+			if buf.Len() != 0 {
+				t.Fatalf("%d bytes left unread", buf.Len())
+			}
+		})
+	}
+}
+
+type readErrorTestCase struct {
+	name        string
+	encoded     []byte
+	expectedErr error
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	testCases := []*readErrorTestCase{
+		{name: "empty", encoded: []byte{}, expectedErr: io.EOF},
+		{name: "truncated length", encoded: []byte{0, 0}, expectedErr: io.ErrUnexpectedEOF},
+		{name: "truncated body", encoded: []byte{0, 0, 0, 5, 4, 1}, expectedErr: io.ErrUnexpectedEOF},
+		{name: "missing body", encoded: []byte{0, 0, 0, 5}, expectedErr: io.ErrUnexpectedEOF},
+		{name: "too long", encoded: []byte{0, 0x10, 0, 1, 7}, expectedErr: ErrMessageTooLong},
+		{name: "choke with payload", encoded: []byte{0, 0, 0, 2, 0, 1}, expectedErr: ErrInvalidLength},
+		{name: "short have", encoded: []byte{0, 0, 0, 3, 4, 1, 2}, expectedErr: ErrInvalidLength},
+		{name: "long request", encoded: []byte{0, 0, 0, 14, 6, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0}, expectedErr: ErrInvalidLength},
+		{name: "short piece", encoded: []byte{0, 0, 0, 5, 7, 0, 0, 0, 1}, expectedErr: ErrInvalidLength},
+		{name: "short port", encoded: []byte{0, 0, 0, 2, 9, 1}, expectedErr: ErrInvalidLength},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ReadMessage(bytes.NewReader(tc.encoded))
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestWriteTooLong(t *testing.T) {
+	message := &Message{ID: Piece, Payload: make([]byte, MaxLength)}
+	var buf bytes.Buffer
+	if _, err := message.WriteTo(&buf); !errors.Is(err, ErrMessageTooLong) {
+		t.Fatalf("expected error %v, got %v", ErrMessageTooLong, err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing to be written, got %d bytes", buf.Len())
+	}
+}
+
+func TestMessageString(t *testing.T) {
+	if s := (&Message{ID: Request, Index: 1, Begin: 16384, Length: 16384}).String(); s != "request 1+16384 length 16384" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	if s := MessageID(42).String(); s != "message 42" {
+		t.Fatalf("unexpected string %q", s)
+	}
+}
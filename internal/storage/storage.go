@@ -0,0 +1,228 @@
+// Package storage maps the byte stream a torrent's pieces are laid out over
+// onto the files it is made of.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var ErrOutOfRange = errors.New("range is outside the torrent")
+var ErrUnsafePath = errors.New("file path escapes the download directory")
+
+// File is one file of a torrent, covering the byte range
+// [Offset, Offset+Length) of the concatenation of all files.
+type File struct {
+	Path   []string
+	Length int64
+	Offset int64
+}
+
+// Storage reads and writes pieces of a torrent across its files.
+type Storage struct {
+	files       []*storageFile
+	length      int64
+	pieceLength int64
+}
+
+type storageFile struct {
+	File
+	handle *os.File
+}
+
+// Open creates every file under root, along with the directories named by
+// its path components, and sizes it to its final length. Path components
+// are sanitized so that no file can end up outside root.
+func Open(root string, files []File, pieceLength int64) (*Storage, error) {
+	return open(root, files, pieceLength, false)
+}
+
+// OpenReadOnly opens existing files under root for reading, such as the
+// content of a torrent being created or seeded. Every file must already have
+// its final length.
+func OpenReadOnly(root string, files []File, pieceLength int64) (*Storage, error) {
+	return open(root, files, pieceLength, true)
+}
+
+func open(root string, files []File, pieceLength int64, readOnly bool) (*Storage, error) {
+	s := &Storage{pieceLength: pieceLength}
+	for _, f := range files {
+		handle, err := openFile(root, f, readOnly)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.files = append(s.files, &storageFile{File: f, handle: handle})
+
+		if end := f.Offset + f.Length; end > s.length {
+			s.length = end
+		}
+	}
+	return s, nil
+}
+
+func openFile(root string, f File, readOnly bool) (*os.File, error) {
+	relativePath, err := SanitizePath(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	fullPath := filepath.Join(root, relativePath)
+
+	if readOnly {
+		handle, err := os.Open(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := handle.Stat()
+		if err == nil && stat.Size() != f.Length {
+			err = fmt.Errorf("%s is %d bytes, expected %d", fullPath, stat.Size(), f.Length)
+		}
+		if err != nil {
+			handle.Close()
+			return nil, err
+		}
+		return handle, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return nil, err
+	}
+
+	handle, err := os.OpenFile(fullPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := handle.Truncate(f.Length); err != nil {
+		handle.Close()
+		return nil, fmt.Errorf("failed to truncate %s: %w", fullPath, err)
+	}
+	return handle, nil
+}
+
+// SanitizePath turns torrent path components into a relative path that
+// stays inside the download directory: empty and "." components are
+// dropped, ".." becomes "_", and separators inside a component, which would
+// make it absolute or nested, are replaced with "_".
+func SanitizePath(components []string) (string, error) {
+	cleaned := []string{}
+	for _, component := range components {
+		component = strings.Map(func(r rune) rune {
+			if r == '/' || r == '\\' || r == 0 {
+				return '_'
+			}
+			return r
+		}, component)
+
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			component = "_"
+		}
+		cleaned = append(cleaned, component)
+	}
+
+	relativePath := filepath.Join(cleaned...)
+	if len(cleaned) == 0 || !filepath.IsLocal(relativePath) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, strings.Join(components, "/"))
+	}
+	return relativePath, nil
+}
+
+// Length returns the total length of all files.
+func (s *Storage) Length() int64 {
+	return s.length
+}
+
+// WriteAt writes p at offset off of the torrent's byte stream, splitting it
+// across every file it overlaps.
+func (s *Storage) WriteAt(p []byte, off int64) (int, error) {
+	return s.forEachSpan(p, off, func(f *storageFile, span []byte, fileOffset int64) (int, error) {
+		return f.handle.WriteAt(span, fileOffset)
+	})
+}
+
+// ReadAt reads len(p) bytes at offset off of the torrent's byte stream.
+func (s *Storage) ReadAt(p []byte, off int64) (int, error) {
+	return s.forEachSpan(p, off, func(f *storageFile, span []byte, fileOffset int64) (int, error) {
+		return f.handle.ReadAt(span, fileOffset)
+	})
+}
+
+// WriteBlock writes a block received for a piece, begin bytes into it.
+func (s *Storage) WriteBlock(pieceIndex int, begin int, block []byte) error {
+	_, err := s.WriteAt(block, int64(pieceIndex)*s.pieceLength+int64(begin))
+	return err
+}
+
+// ReadPiece reads the whole of a piece, which is shorter than the piece
+// length if it is the last one.
+func (s *Storage) ReadPiece(pieceIndex int) ([]byte, error) {
+	start := int64(pieceIndex) * s.pieceLength
+	end := start + s.pieceLength
+	if end > s.length {
+		end = s.length
+	}
+	if start < 0 || start >= end {
+		return nil, ErrOutOfRange
+	}
+
+	piece := make([]byte, end-start)
+	if _, err := s.ReadAt(piece, start); err != nil {
+		return nil, err
+	}
+	return piece, nil
+}
+
+func (s *Storage) forEachSpan(p []byte, off int64, do func(f *storageFile, span []byte, fileOffset int64) (int, error)) (int, error) {
+	end := off + int64(len(p))
+	if off < 0 || end > s.length {
+		return 0, ErrOutOfRange
+	}
+
+	// first file that ends after off
+	first := sort.Search(len(s.files), func(i int) bool {
+		return s.files[i].Offset+s.files[i].Length > off
+	})
+
+	total := 0
+	for _, f := range s.files[first:] {
+		if f.Offset >= end {
+			break
+		}
+		if f.Length == 0 {
+			continue
+		}
+
+		spanStart := off
+		if f.Offset > spanStart {
+			spanStart = f.Offset
+		}
+		spanEnd := end
+		if f.Offset+f.Length < spanEnd {
+			spanEnd = f.Offset + f.Length
+		}
+
+		n, err := do(f, p[spanStart-off:spanEnd-off], spanStart-f.Offset)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Close closes every file.
+func (s *Storage) Close() error {
+	var firstErr error
+	for _, f := range s.files {
+		if err := f.handle.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
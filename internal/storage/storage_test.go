@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type sanitizeTestCase struct {
+	name         string
+	components   []string
+	expectedPath string
+	expectedErr  error
+}
+
+func TestSanitizePath(t *testing.T) {
+	testCases := []*sanitizeTestCase{
+		{
+			name:         "plain path",
+			components:   []string{"dir", "file.txt"},
+			expectedPath: filepath.Join("dir", "file.txt"),
+		},
+		{
+			name:         "parent directory components",
+			components:   []string{"..", "..", "etc", "passwd"},
+			expectedPath: filepath.Join("_", "_", "etc", "passwd"),
+		},
+		{
+			name:         "absolute component",
+			components:   []string{"/etc", "passwd"},
+			expectedPath: filepath.Join("_etc", "passwd"),
+		},
+		{
+			name:         "separators inside a component",
+			components:   []string{"a/../../b", `c\d`},
+			expectedPath: filepath.Join("a_.._.._b", "c_d"),
+		},
+		{
+			name:         "empty and current directory components",
+			components:   []string{"", ".", "file.txt"},
+			expectedPath: "file.txt",
+		},
+		{
+			name:        "nothing left",
+			components:  []string{".", ""},
+			expectedErr: ErrUnsafePath,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path, err := SanitizePath(tc.components)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if path != tc.expectedPath {
+				t.Fatalf("expected path %q, got %q", tc.expectedPath, path)
+			}
+		})
+	}
+}
+
+func TestStorageAcrossFiles(t *testing.T) {
+	root := t.TempDir()
+	files := []File{
+		{Path: []string{"a.txt"}, Length: 5, Offset: 0},
+		{Path: []string{"sub", "dir", "empty"}, Length: 0, Offset: 5},
+		{Path: []string{"sub", "b.txt"}, Length: 3, Offset: 5},
+		{Path: []string{"..", "c.txt"}, Length: 6, Offset: 8},
+	}
+	s, err := Open(root, files, 4)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer s.Close()
+
+	if s.Length() != 14 {
+		t.Fatalf("expected length 14, got %d", s.Length())
+	}
+
+	// pieces of 4 bytes: "0123" "4567" "89ab" "cd"
+	for pieceIndex, piece := range []string{"0123", "4567", "89ab", "cd"} {
+		if err := s.WriteBlock(pieceIndex, 0, []byte(piece[:1])); err != nil {
+			t.Fatalf("write block at piece %d: %v", pieceIndex, err)
+		}
+		if err := s.WriteBlock(pieceIndex, 1, []byte(piece[1:])); err != nil {
+			t.Fatalf("write block at piece %d: %v", pieceIndex, err)
+		}
+	}
+
+	expectedContents := map[string]string{
+		"a.txt":                              "01234",
+		filepath.Join("sub", "dir", "empty"): "",
+		filepath.Join("sub", "b.txt"):        "567",
+		filepath.Join("_", "c.txt"):          "89abcd",
+	}
+	for path, expected := range expectedContents {
+		contents, err := os.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		if string(contents) != expected {
+			t.Fatalf("expected %s to contain %q, got %q", path, expected, contents)
+		}
+	}
+
+	piece, err := s.ReadPiece(1)
+	if err != nil {
+		t.Fatalf("read piece: %v", err)
+	}
+	if !bytes.Equal(piece, []byte("4567")) {
+		t.Fatalf("expected piece 1 to be %q, got %q", "4567", piece)
+	}
+
+	piece, err = s.ReadPiece(3)
+	if err != nil {
+		t.Fatalf("read last piece: %v", err)
+	}
+	if !bytes.Equal(piece, []byte("cd")) {
+		t.Fatalf("expected last piece to be %q, got %q", "cd", piece)
+	}
+
+	if err := s.WriteBlock(3, 1, []byte("xy")); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("expected ErrOutOfRange writing past the end, got %v", err)
+	}
+	if _, err := s.ReadPiece(4); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("expected ErrOutOfRange reading past the last piece, got %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package tracker
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ParseAllowlist reads info hashes written as 40 hex digits, one per line.
+// Blank lines and lines starting with # are skipped.
+func ParseAllowlist(r io.Reader) (map[[20]byte]bool, error) {
+	allowlist := map[[20]byte]bool{}
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		decoded, err := hex.DecodeString(line)
+		if err != nil || len(decoded) != 20 {
+			return nil, fmt.Errorf("%w: line %d", ErrInvalidInfoHash, lineNumber)
+		}
+		var infoHash [20]byte
+		copy(infoHash[:], decoded)
+		allowlist[infoHash] = true
+	}
+	return allowlist, scanner.Err()
+}
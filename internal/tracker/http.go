@@ -0,0 +1,219 @@
+package tracker
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+var ErrInvalidInfoHash = errors.New("info_hash must be 20 bytes")
+var ErrInvalidPeerID = errors.New("peer_id must be 20 bytes")
+var ErrInvalidPort = errors.New("invalid port")
+var ErrInvalidParameter = errors.New("invalid parameter")
+
+// HTTPHandler serves /announce, /scrape and a JSON summary at /stats.
+type HTTPHandler struct {
+	store *Store
+	mux   *http.ServeMux
+}
+
+func NewHTTPHandler(store *Store) *HTTPHandler {
+	h := &HTTPHandler{store: store, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/announce", h.announce)
+	h.mux.HandleFunc("/scrape", h.scrape)
+	h.mux.HandleFunc("/stats", h.stats)
+	return h
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *HTTPHandler) announce(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req, err := parseAnnounceQuery(query, r.RemoteAddr)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	response, err := h.store.Announce(req)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	body := bencode.Dict{
+		"interval":     int(response.Interval.Seconds()),
+		"min interval": int(response.MinInterval.Seconds()),
+		"complete":     response.Complete,
+		"incomplete":   response.Incomplete,
+	}
+	if query.Get("compact") == "0" {
+		// the original model, a dictionary per peer
+		peers := bencode.List{}
+		for _, p := range response.Peers {
+			peer := bencode.Dict{
+				"ip":   p.Addr.Addr().String(),
+				"port": int(p.Addr.Port()),
+			}
+			if query.Get("no_peer_id") != "1" {
+				peer["peer id"] = string(p.ID[:])
+			}
+			peers = append(peers, peer)
+		}
+		body["peers"] = peers
+	} else {
+		peers, peers6 := compactPeers(response.Peers)
+		body["peers"] = string(peers)
+		if len(peers6) > 0 {
+			body["peers6"] = string(peers6)
+		}
+	}
+	writeBencode(w, body)
+}
+
+func (h *HTTPHandler) scrape(w http.ResponseWriter, r *http.Request) {
+	infoHashes := [][20]byte{}
+	for _, value := range r.URL.Query()["info_hash"] {
+		infoHash, err := parseID(value, ErrInvalidInfoHash)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		infoHashes = append(infoHashes, infoHash)
+	}
+	// a scrape of nothing in particular would list every torrent, which we
+	// do not give away
+	if len(infoHashes) == 0 {
+		writeFailure(w, ErrInvalidInfoHash)
+		return
+	}
+
+	files := bencode.Dict{}
+	for i, stats := range h.store.Scrape(infoHashes) {
+		if !h.store.Allowed(infoHashes[i]) {
+			continue
+		}
+		files[string(infoHashes[i][:])] = bencode.Dict{
+			"complete":   stats.Complete,
+			"incomplete": stats.Incomplete,
+			"downloaded": stats.Downloaded,
+		}
+	}
+	writeBencode(w, bencode.Dict{"files": files})
+}
+
+func (h *HTTPHandler) stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.store.Stats())
+}
+
+// parseAnnounceQuery reads an announce from its query parameters. The
+// peer's address is the one it connected from, unless it gives an ip.
+func parseAnnounceQuery(query url.Values, remoteAddr string) (*AnnounceRequest, error) {
+	infoHash, err := parseID(query.Get("info_hash"), ErrInvalidInfoHash)
+	if err != nil {
+		return nil, err
+	}
+	peerID, err := parseID(query.Get("peer_id"), ErrInvalidPeerID)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(query.Get("port"), 10, 16)
+	if err != nil || port == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPort, query.Get("port"))
+	}
+
+	remote, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	addr := remote.Addr().Unmap()
+	if ip := query.Get("ip"); ip != "" {
+		if addr, err = netip.ParseAddr(ip); err != nil {
+			return nil, fmt.Errorf("%w: ip %q", ErrInvalidParameter, ip)
+		}
+		addr = addr.Unmap()
+	}
+
+	req := &AnnounceRequest{
+		InfoHash: infoHash,
+		PeerID:   peerID,
+		Addr:     netip.AddrPortFrom(addr, uint16(port)),
+		Event:    query.Get("event"),
+		NumWant:  -1,
+	}
+	counters := map[string]*int64{
+		"uploaded":   &req.Uploaded,
+		"downloaded": &req.Downloaded,
+		"left":       &req.Left,
+	}
+	for key, counter := range counters {
+		if *counter, err = strconv.ParseInt(query.Get(key), 10, 64); err != nil || *counter < 0 {
+			return nil, fmt.Errorf("%w: %s %q", ErrInvalidParameter, key, query.Get(key))
+		}
+	}
+	if numWant := query.Get("numwant"); numWant != "" {
+		if req.NumWant, err = strconv.Atoi(numWant); err != nil {
+			return nil, fmt.Errorf("%w: numwant %q", ErrInvalidParameter, numWant)
+		}
+	}
+
+	switch req.Event {
+	case EventNone, EventStarted, EventCompleted, EventStopped:
+	default:
+		return nil, fmt.Errorf("%w: event %q", ErrInvalidParameter, req.Event)
+	}
+	return req, nil
+}
+
+func parseID(value string, invalid error) ([20]byte, error) {
+	var id [20]byte
+	if len(value) != len(id) {
+		return id, invalid
+	}
+	copy(id[:], value)
+	return id, nil
+}
+
+// compactPeers packs peers into 6-byte IPv4 and 18-byte IPv6 entries.
+func compactPeers(peers []Peer) (peers4 []byte, peers6 []byte) {
+	peers4 = []byte{}
+	for _, p := range peers {
+		addr := p.Addr.Addr()
+		if addr.Is4() {
+			peers4 = append(peers4, addr.AsSlice()...)
+			peers4 = binary.BigEndian.AppendUint16(peers4, p.Addr.Port())
+		} else {
+			peers6 = append(peers6, addr.AsSlice()...)
+			peers6 = binary.BigEndian.AppendUint16(peers6, p.Addr.Port())
+		}
+	}
+	return peers4, peers6
+}
+
+// writeFailure tells the client why its request was refused. Trackers
+// answer with a failure reason and a 200 status, which is what clients
+// look for.
+func writeFailure(w http.ResponseWriter, err error) {
+	writeBencode(w, bencode.Dict{"failure reason": err.Error()})
+}
+
+func writeBencode(w http.ResponseWriter, body bencode.Dict) {
+	encoded, err := bencode.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(encoded)
+}
@@ -0,0 +1,203 @@
+package tracker
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/internal/bencode"
+)
+
+func newTestHTTPTracker(t *testing.T, config Config) *httptest.Server {
+	server := httptest.NewServer(NewHTTPHandler(NewStore(config)))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func announceQuery(peer int, left string, extra url.Values) url.Values {
+	peerID := testPeerID(peer)
+	query := url.Values{
+		"info_hash":  {string(testInfoHash[:])},
+		"peer_id":    {string(peerID[:])},
+		"port":       {"6881"},
+		"uploaded":   {"0"},
+		"downloaded": {"0"},
+		"left":       {left},
+		"ip":         {fmt.Sprintf("10.0.0.%d", peer)},
+	}
+	for key, values := range extra {
+		query[key] = values
+	}
+	return query
+}
+
+func getBencode(t *testing.T, requestURL string) bencode.Dict {
+	t.Helper()
+	response, err := http.Get(requestURL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer response.Body.Close()
+
+	var body any
+	if err := bencode.NewDecoder(response.Body).Decode(&body); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return body.(bencode.Dict)
+}
+
+type httpAnnounceTestCase struct {
+	name          string
+	query         url.Values
+	expectedPeers any
+	expectedErr   string
+}
+
+func TestHTTPAnnounce(t *testing.T) {
+	server := newTestHTTPTracker(t, DefaultConfig())
+	getBencode(t, server.URL+"/announce?"+announceQuery(1, "0", nil).Encode())
+	getBencode(t, server.URL+"/announce?"+announceQuery(2, "0", url.Values{"ip": {"2001:db8::2"}}).Encode())
+
+	peerID := testPeerID(1)
+	testCases := []*httpAnnounceTestCase{
+		{
+			name:          "compact",
+			query:         announceQuery(3, "100", url.Values{"compact": {"1"}}),
+			expectedPeers: "\x0a\x00\x00\x01\x1a\xe1",
+		},
+		{
+			name:  "non-compact",
+			query: announceQuery(3, "100", url.Values{"compact": {"0"}, "numwant": {"1"}, "ip": {"10.0.0.3"}}),
+		},
+		{
+			name:  "non-compact without peer ids",
+			query: announceQuery(3, "100", url.Values{"compact": {"0"}, "no_peer_id": {"1"}}),
+		},
+		{
+			name:        "short info hash",
+			query:       announceQuery(3, "100", url.Values{"info_hash": {"abc"}}),
+			expectedErr: ErrInvalidInfoHash.Error(),
+		},
+		{
+			name:        "missing port",
+			query:       announceQuery(3, "100", url.Values{"port": {""}}),
+			expectedErr: ErrInvalidPort.Error(),
+		},
+		{
+			name:        "unknown event",
+			query:       announceQuery(3, "100", url.Values{"event": {"paused"}}),
+			expectedErr: ErrInvalidParameter.Error(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := getBencode(t, server.URL+"/announce?"+tc.query.Encode())
+			if tc.expectedErr != "" {
+				reason, _ := body["failure reason"].(string)
+				if !strings.Contains(reason, tc.expectedErr) {
+					t.Fatalf("expected failure %q, got %v", tc.expectedErr, body)
+				}
+				return
+			}
+			if body["complete"] != 2 || body["interval"] != 1800 {
+				t.Fatalf("unexpected response %v", body)
+			}
+
+			switch peers := body["peers"].(type) {
+			case string:
+				if peers != tc.expectedPeers || body["peers6"] != "\x20\x01\x0d\xb8"+strings.Repeat("\x00", 11)+"\x02\x1a\xe1" {
+					t.Fatalf("unexpected compact peers %q %q", peers, body["peers6"])
+				}
+			case bencode.List:
+				if len(peers) == 0 {
+					t.Fatalf("expected peers, got none")
+				}
+				for _, p := range peers {
+					peer := p.(bencode.Dict)
+					_, hasID := peer["peer id"]
+					if tc.query.Get("no_peer_id") == "1" && hasID {
+						t.Fatalf("expected no peer ids, got %v", peer)
+					}
+					if peer["ip"] == "10.0.0.1" && hasID && peer["peer id"] != string(peerID[:]) {
+						t.Fatalf("unexpected peer %v", peer)
+					}
+				}
+				if tc.query.Get("numwant") == "1" && len(peers) != 1 {
+					t.Fatalf("expected numwant to be honoured, got %v", peers)
+				}
+			default:
+				t.Fatalf("unexpected peers %v", body["peers"])
+			}
+		})
+	}
+}
+
+func TestHTTPScrape(t *testing.T) {
+	config := DefaultConfig()
+	config.Allowlist = map[[20]byte]bool{testInfoHash: true}
+	server := newTestHTTPTracker(t, config)
+	getBencode(t, server.URL+"/announce?"+announceQuery(1, "0", nil).Encode())
+	getBencode(t, server.URL+"/announce?"+announceQuery(2, "100", nil).Encode())
+
+	notAllowed := strings.Repeat("x", 20)
+	query := url.Values{"info_hash": {string(testInfoHash[:]), notAllowed}}
+	body := getBencode(t, server.URL+"/scrape?"+query.Encode())
+
+	files := body["files"].(bencode.Dict)
+	if len(files) != 1 {
+		t.Fatalf("expected only the allowed torrent, got %v", files)
+	}
+	stats := files[string(testInfoHash[:])].(bencode.Dict)
+	if stats["complete"] != 1 || stats["incomplete"] != 1 || stats["downloaded"] != 0 {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+
+	body = getBencode(t, server.URL+"/announce?"+announceQuery(3, "0", url.Values{"info_hash": {notAllowed}}).Encode())
+	if body["failure reason"] != ErrNotAllowed.Error() {
+		t.Fatalf("expected the torrent to be refused, got %v", body)
+	}
+}
+
+func getStats(t *testing.T, config Config) (*Stats, []byte) {
+	server := newTestHTTPTracker(t, config)
+	getBencode(t, server.URL+"/announce?"+announceQuery(1, "0", nil).Encode())
+	getBencode(t, server.URL+"/announce?"+announceQuery(2, "100", nil).Encode())
+
+	response, err := http.Get(server.URL + "/stats")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer response.Body.Close()
+	contents, _ := io.ReadAll(response.Body)
+
+	var stats Stats
+	if err := json.Unmarshal(contents, &stats); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return &stats, contents
+}
+
+func TestHTTPStats(t *testing.T) {
+	stats, contents := getStats(t, DefaultConfig())
+	if stats.Torrents != 1 || stats.Seeders != 1 || stats.Leechers != 1 || stats.Announces != 2 {
+		t.Fatalf("unexpected stats %s", contents)
+	}
+	// like a scrape of everything, the torrents are not given away
+	if stats.Swarms != nil {
+		t.Fatalf("expected no torrents listed, got %s", contents)
+	}
+
+	config := DefaultConfig()
+	config.ListSwarms = true
+	stats, contents = getStats(t, config)
+	if swarm := stats.Swarms[hex.EncodeToString(testInfoHash[:])]; swarm.Complete != 1 || swarm.Incomplete != 1 {
+		t.Fatalf("expected the torrent listed, got %s", contents)
+	}
+}
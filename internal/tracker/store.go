@@ -0,0 +1,304 @@
+// Package tracker implements a BitTorrent tracker serving announces and
+// scrapes over HTTP (BEP 3, BEP 23) and UDP (BEP 15) from an in-memory
+// store of peers.
+package tracker
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// announce events; regular announces carry none
+const (
+	EventNone      = ""
+	EventStarted   = "started"
+	EventCompleted = "completed"
+	EventStopped   = "stopped"
+)
+
+var ErrNotAllowed = errors.New("torrent is not tracked here")
+
+// Config tunes a Store.
+type Config struct {
+	// how often peers are asked to announce, and at least how long they
+	// must wait between announces
+	Interval    time.Duration
+	MinInterval time.Duration
+	// peers that have not announced for this long are dropped
+	PeerTimeout time.Duration
+	// peers returned when an announce asks for none in particular, and at
+	// most
+	DefaultNumWant int
+	MaxNumWant     int
+	// info hashes of the torrents tracked, or nil to track any torrent
+	Allowlist map[[20]byte]bool
+	// list every torrent by info hash in Stats, which gives away what is
+	// tracked just like a scrape of everything would
+	ListSwarms bool
+}
+
+// DefaultConfig returns the Config of a public tracker announcing every 30
+// minutes.
+func DefaultConfig() Config {
+	return Config{
+		Interval:       30 * time.Minute,
+		MinInterval:    5 * time.Minute,
+		PeerTimeout:    45 * time.Minute,
+		DefaultNumWant: 50,
+		MaxNumWant:     200,
+	}
+}
+
+// AnnounceRequest is an announce from a peer, however it arrived.
+type AnnounceRequest struct {
+	InfoHash   [20]byte
+	PeerID     [20]byte
+	Addr       netip.AddrPort
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      string
+	// peers wanted, or negative for the default
+	NumWant int
+}
+
+// Peer is a peer of a swarm as handed out to other peers.
+type Peer struct {
+	ID   [20]byte
+	Addr netip.AddrPort
+}
+
+// SwarmStats counts the peers of a torrent.
+type SwarmStats struct {
+	Complete   int `json:"complete"`
+	Incomplete int `json:"incomplete"`
+	// completed downloads reported since the tracker started
+	Downloaded int `json:"downloaded"`
+}
+
+// AnnounceResponse is what a peer is told in response to an announce.
+type AnnounceResponse struct {
+	Interval    time.Duration
+	MinInterval time.Duration
+	SwarmStats
+	// other peers of the swarm, never including the announcing peer
+	Peers []Peer
+}
+
+type swarmPeer struct {
+	Peer
+	left     int64
+	lastSeen time.Time
+}
+
+type swarm struct {
+	peers      map[[20]byte]*swarmPeer
+	downloaded int
+}
+
+// empty reports whether the swarm has neither peers nor completed downloads
+// to remember.
+func (s *swarm) empty() bool {
+	return len(s.peers) == 0 && s.downloaded == 0
+}
+
+func (s *swarm) stats() SwarmStats {
+	stats := SwarmStats{Downloaded: s.downloaded}
+	for _, p := range s.peers {
+		if p.left == 0 {
+			stats.Complete++
+		} else {
+			stats.Incomplete++
+		}
+	}
+	return stats
+}
+
+// Store keeps the peers of every swarm in memory. It is safe for concurrent
+// use.
+type Store struct {
+	config Config
+	// swapped out in tests
+	now func() time.Time
+
+	mu        sync.Mutex
+	swarms    map[[20]byte]*swarm
+	announces int
+	scrapes   int
+}
+
+func NewStore(config Config) *Store {
+	return &Store{
+		config: config,
+		now:    time.Now,
+		swarms: map[[20]byte]*swarm{},
+	}
+}
+
+// Allowed reports whether the torrent with the given info hash is tracked.
+func (s *Store) Allowed(infoHash [20]byte) bool {
+	return s.config.Allowlist == nil || s.config.Allowlist[infoHash]
+}
+
+// Announce records the peer's announce and returns up to NumWant random
+// other peers of its swarm.
+func (s *Store) Announce(req *AnnounceRequest) (*AnnounceResponse, error) {
+	if !s.Allowed(req.InfoHash) {
+		return nil, ErrNotAllowed
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.announces++
+
+	now := s.now()
+	sw, found := s.swarms[req.InfoHash]
+	if !found {
+		sw = &swarm{peers: map[[20]byte]*swarmPeer{}}
+		s.swarms[req.InfoHash] = sw
+	}
+	s.expire(sw, now)
+
+	if req.Event == EventStopped {
+		delete(sw.peers, req.PeerID)
+	} else {
+		p, known := sw.peers[req.PeerID]
+		if !known {
+			p = &swarmPeer{}
+			sw.peers[req.PeerID] = p
+		}
+		// count a completion once, even if the peer repeats the event
+		if req.Event == EventCompleted && (!known || p.left != 0) {
+			sw.downloaded++
+		}
+		p.Peer = Peer{ID: req.PeerID, Addr: req.Addr}
+		p.left = req.Left
+		p.lastSeen = now
+	}
+
+	response := &AnnounceResponse{
+		Interval:    s.config.Interval,
+		MinInterval: s.config.MinInterval,
+		SwarmStats:  sw.stats(),
+		Peers:       s.pickPeers(sw, req),
+	}
+	if sw.empty() {
+		delete(s.swarms, req.InfoHash)
+	}
+	return response, nil
+}
+
+// pickPeers picks the peers to return to an announce. Seeders are not given
+// other seeders, which have nothing for them.
+func (s *Store) pickPeers(sw *swarm, req *AnnounceRequest) []Peer {
+	numWant := req.NumWant
+	if numWant < 0 {
+		numWant = s.config.DefaultNumWant
+	}
+	if numWant > s.config.MaxNumWant {
+		numWant = s.config.MaxNumWant
+	}
+	if req.Event == EventStopped {
+		numWant = 0
+	}
+
+	candidates := []Peer{}
+	for id, p := range sw.peers {
+		if id == req.PeerID || (req.Left == 0 && p.left == 0) {
+			continue
+		}
+		candidates = append(candidates, p.Peer)
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if len(candidates) > numWant {
+		candidates = candidates[:numWant]
+	}
+	return candidates
+}
+
+// Scrape returns the stats of the torrents with the given info hashes, zero
+// for those not tracked.
+func (s *Store) Scrape(infoHashes [][20]byte) []SwarmStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scrapes++
+
+	now := s.now()
+	stats := []SwarmStats{}
+	for _, infoHash := range infoHashes {
+		sw, found := s.swarms[infoHash]
+		if !found || !s.Allowed(infoHash) {
+			stats = append(stats, SwarmStats{})
+			continue
+		}
+		s.expire(sw, now)
+		stats = append(stats, sw.stats())
+	}
+	return stats
+}
+
+// Expire drops the peers that have not announced within the peer timeout,
+// and the swarms left with nothing to report.
+func (s *Store) Expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for infoHash, sw := range s.swarms {
+		s.expire(sw, now)
+		if sw.empty() {
+			delete(s.swarms, infoHash)
+		}
+	}
+}
+
+func (s *Store) expire(sw *swarm, now time.Time) {
+	for id, p := range sw.peers {
+		if now.Sub(p.lastSeen) > s.config.PeerTimeout {
+			delete(sw.peers, id)
+		}
+	}
+}
+
+// Stats is a summary of everything the store tracks, as served by the stats
+// endpoint.
+type Stats struct {
+	Torrents  int `json:"torrents"`
+	Peers     int `json:"peers"`
+	Seeders   int `json:"seeders"`
+	Leechers  int `json:"leechers"`
+	Announces int `json:"announces"`
+	Scrapes   int `json:"scrapes"`
+	// by hex info hash, only with Config.ListSwarms
+	Swarms map[string]SwarmStats `json:"swarms,omitempty"`
+}
+
+func (s *Store) Stats() *Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := &Stats{
+		Torrents:  len(s.swarms),
+		Announces: s.announces,
+		Scrapes:   s.scrapes,
+	}
+	if s.config.ListSwarms {
+		stats.Swarms = map[string]SwarmStats{}
+	}
+	for infoHash, sw := range s.swarms {
+		swarmStats := sw.stats()
+		if stats.Swarms != nil {
+			stats.Swarms[hex.EncodeToString(infoHash[:])] = swarmStats
+		}
+		stats.Seeders += swarmStats.Complete
+		stats.Leechers += swarmStats.Incomplete
+	}
+	stats.Peers = stats.Seeders + stats.Leechers
+	return stats
+}
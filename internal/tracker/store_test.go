@@ -0,0 +1,169 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testInfoHash = [20]byte{1, 2, 3}
+
+func testPeerID(i int) [20]byte {
+	var id [20]byte
+	copy(id[:], fmt.Sprintf("-TS0001-%012d", i))
+	return id
+}
+
+func testAnnounce(i int, left int64, event string) *AnnounceRequest {
+	return &AnnounceRequest{
+		InfoHash: testInfoHash,
+		PeerID:   testPeerID(i),
+		Addr:     netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, 0, byte(i)}), 6881),
+		Left:     left,
+		Event:    event,
+		NumWant:  -1,
+	}
+}
+
+func mustAnnounce(t *testing.T, store *Store, req *AnnounceRequest) *AnnounceResponse {
+	t.Helper()
+	response, err := store.Announce(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return response
+}
+
+func TestStoreAnnounce(t *testing.T) {
+	store := NewStore(DefaultConfig())
+	mustAnnounce(t, store, testAnnounce(1, 0, EventStarted))
+	mustAnnounce(t, store, testAnnounce(2, 0, EventStarted))
+	mustAnnounce(t, store, testAnnounce(3, 100, EventStarted))
+
+	// a leecher gets everyone but itself
+	response := mustAnnounce(t, store, testAnnounce(4, 100, EventStarted))
+	if len(response.Peers) != 3 || response.Complete != 2 || response.Incomplete != 2 {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	for _, p := range response.Peers {
+		if p.ID == testPeerID(4) {
+			t.Fatalf("announcing peer returned to itself")
+		}
+	}
+
+	// a seeder gets the leechers only
+	response = mustAnnounce(t, store, testAnnounce(1, 0, EventNone))
+	if len(response.Peers) != 2 {
+		t.Fatalf("expected 2 leechers for a seeder, got %+v", response.Peers)
+	}
+	for _, p := range response.Peers {
+		if p.ID != testPeerID(3) && p.ID != testPeerID(4) {
+			t.Fatalf("seeder given a seeder %v", p)
+		}
+	}
+}
+
+type numWantTestCase struct {
+	numWant  int
+	expected int
+}
+
+func TestStoreNumWant(t *testing.T) {
+	config := DefaultConfig()
+	config.DefaultNumWant = 5
+	config.MaxNumWant = 8
+	store := NewStore(config)
+	for i := 1; i <= 20; i++ {
+		mustAnnounce(t, store, testAnnounce(i, 100, EventStarted))
+	}
+
+	testCases := []*numWantTestCase{
+		{numWant: -1, expected: 5},
+		{numWant: 0, expected: 0},
+		{numWant: 3, expected: 3},
+		{numWant: 100, expected: 8},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.numWant), func(t *testing.T) {
+			req := testAnnounce(1, 100, EventNone)
+			req.NumWant = tc.numWant
+			if response := mustAnnounce(t, store, req); len(response.Peers) != tc.expected {
+				t.Fatalf("expected %d peers, got %d", tc.expected, len(response.Peers))
+			}
+		})
+	}
+}
+
+func TestStoreEvents(t *testing.T) {
+	store := NewStore(DefaultConfig())
+	mustAnnounce(t, store, testAnnounce(1, 100, EventStarted))
+	mustAnnounce(t, store, testAnnounce(2, 100, EventStarted))
+
+	mustAnnounce(t, store, testAnnounce(1, 0, EventCompleted))
+	response := mustAnnounce(t, store, testAnnounce(1, 0, EventCompleted))
+	if response.Downloaded != 1 || response.Complete != 1 || response.Incomplete != 1 {
+		t.Fatalf("expected a single completed download, got %+v", response.SwarmStats)
+	}
+
+	response = mustAnnounce(t, store, testAnnounce(2, 100, EventStopped))
+	if len(response.Peers) != 0 || response.Incomplete != 0 {
+		t.Fatalf("expected the stopped peer to be gone, got %+v", response)
+	}
+	if stats := store.Scrape([][20]byte{testInfoHash}); stats[0] != (SwarmStats{Complete: 1, Downloaded: 1}) {
+		t.Fatalf("unexpected scrape %+v", stats[0])
+	}
+}
+
+func TestStoreExpire(t *testing.T) {
+	config := DefaultConfig()
+	config.PeerTimeout = time.Minute
+	store := NewStore(config)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	mustAnnounce(t, store, testAnnounce(1, 100, EventStarted))
+	now = now.Add(50 * time.Second)
+	mustAnnounce(t, store, testAnnounce(2, 100, EventStarted))
+
+	now = now.Add(20 * time.Second)
+	if stats := store.Scrape([][20]byte{testInfoHash}); stats[0].Incomplete != 1 {
+		t.Fatalf("expected the silent peer to expire, got %+v", stats[0])
+	}
+
+	now = now.Add(time.Minute)
+	store.Expire()
+	if stats := store.Stats(); stats.Torrents != 0 || stats.Peers != 0 {
+		t.Fatalf("expected the empty swarm to be dropped, got %+v", stats)
+	}
+}
+
+func TestStoreAllowlist(t *testing.T) {
+	config := DefaultConfig()
+	config.Allowlist = map[[20]byte]bool{testInfoHash: true}
+	store := NewStore(config)
+
+	mustAnnounce(t, store, testAnnounce(1, 100, EventStarted))
+
+	req := testAnnounce(1, 100, EventStarted)
+	req.InfoHash = [20]byte{9}
+	if _, err := store.Announce(req); !errors.Is(err, ErrNotAllowed) {
+		t.Fatalf("expected error %v, got %v", ErrNotAllowed, err)
+	}
+}
+
+func TestParseAllowlist(t *testing.T) {
+	allowlist, err := ParseAllowlist(strings.NewReader("# tracked torrents\n\n0102030000000000000000000000000000000000\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(allowlist) != 1 || !allowlist[testInfoHash] {
+		t.Fatalf("unexpected allowlist %v", allowlist)
+	}
+
+	if _, err := ParseAllowlist(strings.NewReader("not a hash\n")); !errors.Is(err, ErrInvalidInfoHash) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidInfoHash, err)
+	}
+}
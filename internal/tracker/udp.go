@@ -0,0 +1,214 @@
+package tracker
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+)
+
+const udpProtocolID = 0x41727101980
+
+// BEP 15 actions
+const (
+	udpActionConnect uint32 = iota
+	udpActionAnnounce
+	udpActionScrape
+	udpActionError
+)
+
+const (
+	// connection ids are valid for one to two windows, clients reconnect
+	// after a minute
+	udpConnectionIDWindow = time.Minute
+	udpAnnounceLength     = 98
+	udpMaxScrapeHashes    = 74
+	udpMaxPacketLength    = 65507
+)
+
+var ErrInvalidConnectionID = errors.New("unknown connection id")
+var ErrInvalidRequest = errors.New("invalid request")
+
+// event codes of the announce request
+var udpEvents = map[uint32]string{
+	0: EventNone,
+	1: EventCompleted,
+	2: EventStarted,
+	3: EventStopped,
+}
+
+// UDPServer answers UDP tracker requests (BEP 15). Connection ids are
+// derived from the client's IP address, a secret and the time, so nothing is
+// kept per client.
+type UDPServer struct {
+	store  *Store
+	secret []byte
+	// swapped out in tests
+	now func() time.Time
+}
+
+func NewUDPServer(store *Store) *UDPServer {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return &UDPServer{store: store, secret: secret, now: time.Now}
+}
+
+// Serve answers the requests arriving on conn until it is closed.
+func (s *UDPServer) Serve(conn net.PacketConn) error {
+	packet := make([]byte, udpMaxPacketLength)
+	for {
+		n, addr, err := conn.ReadFrom(packet)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		udpAddr, isUDP := addr.(*net.UDPAddr)
+		if !isUDP {
+			continue
+		}
+		if response := s.handle(packet[:n], udpAddr.AddrPort()); response != nil {
+			conn.WriteTo(response, addr)
+		}
+	}
+}
+
+// handle returns the response to a request from addr, or nil when the
+// request is not worth answering.
+func (s *UDPServer) handle(request []byte, addr netip.AddrPort) []byte {
+	if len(request) < 16 {
+		return nil
+	}
+	connectionID := binary.BigEndian.Uint64(request[0:8])
+	action := binary.BigEndian.Uint32(request[8:12])
+	transactionID := binary.BigEndian.Uint32(request[12:16])
+	addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+
+	if action == udpActionConnect {
+		if connectionID != udpProtocolID {
+			return nil
+		}
+		response := udpHeader(udpActionConnect, transactionID)
+		return binary.BigEndian.AppendUint64(response, s.connectionID(addr, s.now()))
+	}
+
+	if !s.validConnectionID(connectionID, addr) {
+		return udpError(transactionID, ErrInvalidConnectionID)
+	}
+
+	switch action {
+	case udpActionAnnounce:
+		return s.announce(request, addr, transactionID)
+	case udpActionScrape:
+		return s.scrape(request[16:], transactionID)
+	}
+	return udpError(transactionID, ErrInvalidRequest)
+}
+
+func (s *UDPServer) announce(request []byte, addr netip.AddrPort, transactionID uint32) []byte {
+	if len(request) < udpAnnounceLength {
+		return udpError(transactionID, ErrInvalidRequest)
+	}
+	event, knownEvent := udpEvents[binary.BigEndian.Uint32(request[80:84])]
+	if !knownEvent {
+		return udpError(transactionID, ErrInvalidRequest)
+	}
+
+	req := &AnnounceRequest{
+		Downloaded: int64(binary.BigEndian.Uint64(request[56:64])),
+		Left:       int64(binary.BigEndian.Uint64(request[64:72])),
+		Uploaded:   int64(binary.BigEndian.Uint64(request[72:80])),
+		Event:      event,
+		NumWant:    int(int32(binary.BigEndian.Uint32(request[92:96]))),
+	}
+	copy(req.InfoHash[:], request[16:36])
+	copy(req.PeerID[:], request[36:56])
+
+	port := binary.BigEndian.Uint16(request[96:98])
+	if port == 0 {
+		return udpError(transactionID, ErrInvalidPort)
+	}
+	// an ip other than the sender's is only taken from IPv4 clients
+	ip := addr.Addr()
+	if given := binary.BigEndian.Uint32(request[84:88]); given != 0 && ip.Is4() {
+		var given4 [4]byte
+		copy(given4[:], request[84:88])
+		ip = netip.AddrFrom4(given4)
+	}
+	req.Addr = netip.AddrPortFrom(ip, port)
+
+	response, err := s.store.Announce(req)
+	if err != nil {
+		return udpError(transactionID, err)
+	}
+
+	// the peers returned are of the family the client talks to us over
+	samePeers := []Peer{}
+	for _, p := range response.Peers {
+		if p.Addr.Addr().Is4() == ip.Is4() {
+			samePeers = append(samePeers, p)
+		}
+	}
+	peers4, peers6 := compactPeers(samePeers)
+
+	packet := udpHeader(udpActionAnnounce, transactionID)
+	packet = binary.BigEndian.AppendUint32(packet, uint32(response.Interval.Seconds()))
+	packet = binary.BigEndian.AppendUint32(packet, uint32(response.Incomplete))
+	packet = binary.BigEndian.AppendUint32(packet, uint32(response.Complete))
+	packet = append(packet, peers4...)
+	return append(packet, peers6...)
+}
+
+func (s *UDPServer) scrape(body []byte, transactionID uint32) []byte {
+	if len(body) == 0 || len(body)%20 != 0 || len(body)/20 > udpMaxScrapeHashes {
+		return udpError(transactionID, ErrInvalidRequest)
+	}
+
+	infoHashes := make([][20]byte, len(body)/20)
+	for i := range infoHashes {
+		copy(infoHashes[i][:], body[i*20:])
+	}
+
+	packet := udpHeader(udpActionScrape, transactionID)
+	for _, stats := range s.store.Scrape(infoHashes) {
+		packet = binary.BigEndian.AppendUint32(packet, uint32(stats.Complete))
+		packet = binary.BigEndian.AppendUint32(packet, uint32(stats.Downloaded))
+		packet = binary.BigEndian.AppendUint32(packet, uint32(stats.Incomplete))
+	}
+	return packet
+}
+
+// connectionID signs the client's IP address and the current window. The
+// port is left out, as clients may send every request from a new socket.
+func (s *UDPServer) connectionID(addr netip.AddrPort, at time.Time) uint64 {
+	window := at.Unix() / int64(udpConnectionIDWindow/time.Second)
+
+	mac := hmac.New(sha256.New, s.secret)
+	addrBytes, _ := addr.Addr().MarshalBinary()
+	mac.Write(addrBytes)
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(window)))
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// validConnectionID accepts the connection ids of the current and the
+// previous window.
+func (s *UDPServer) validConnectionID(connectionID uint64, addr netip.AddrPort) bool {
+	now := s.now()
+	return connectionID == s.connectionID(addr, now) ||
+		connectionID == s.connectionID(addr, now.Add(-udpConnectionIDWindow))
+}
+
+func udpHeader(action uint32, transactionID uint32) []byte {
+	packet := binary.BigEndian.AppendUint32(nil, action)
+	return binary.BigEndian.AppendUint32(packet, transactionID)
+}
+
+func udpError(transactionID uint32, err error) []byte {
+	return append(udpHeader(udpActionError, transactionID), err.Error()...)
+}
@@ -0,0 +1,160 @@
+package tracker
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// udpTestClient sends raw BEP 15 requests to a UDPServer on a local socket.
+type udpTestClient struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func newTestUDPTracker(t *testing.T, store *Store) *udpTestClient {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	server := NewUDPServer(store)
+	go server.Serve(listener)
+	t.Cleanup(func() { listener.Close() })
+
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &udpTestClient{t: t, conn: conn}
+}
+
+// roundTrip sends a request and returns the action and body of the
+// response, checking its transaction id.
+func (c *udpTestClient) roundTrip(connectionID uint64, action uint32, body []byte) (uint32, []byte) {
+	c.t.Helper()
+	request := binary.BigEndian.AppendUint64(nil, connectionID)
+	request = append(request, udpHeader(action, 42)...)
+	request = append(request, body...)
+	if _, err := c.conn.Write(request); err != nil {
+		c.t.Fatalf("unexpected error: %s", err)
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	response := make([]byte, udpMaxPacketLength)
+	n, err := c.conn.Read(response)
+	if err != nil {
+		c.t.Fatalf("unexpected error: %s", err)
+	}
+	if n < 8 || binary.BigEndian.Uint32(response[4:8]) != 42 {
+		c.t.Fatalf("unexpected response %x", response[:n])
+	}
+	return binary.BigEndian.Uint32(response[0:4]), response[8:n]
+}
+
+func (c *udpTestClient) connect() uint64 {
+	c.t.Helper()
+	action, body := c.roundTrip(udpProtocolID, udpActionConnect, nil)
+	if action != udpActionConnect || len(body) != 8 {
+		c.t.Fatalf("unexpected connect response %d %x", action, body)
+	}
+	return binary.BigEndian.Uint64(body)
+}
+
+func udpAnnounceBody(peer int, left uint64, event uint32) []byte {
+	peerID := testPeerID(peer)
+	body := append([]byte{}, testInfoHash[:]...)
+	body = append(body, peerID[:]...)
+	body = binary.BigEndian.AppendUint64(body, 0)
+	body = binary.BigEndian.AppendUint64(body, left)
+	body = binary.BigEndian.AppendUint64(body, 0)
+	body = binary.BigEndian.AppendUint32(body, event)
+	body = append(body, 10, 0, 0, byte(peer))
+	body = binary.BigEndian.AppendUint32(body, 0)
+	body = binary.BigEndian.AppendUint32(body, ^uint32(0))
+	return binary.BigEndian.AppendUint16(body, 6881)
+}
+
+func TestUDPAnnounceAndScrape(t *testing.T) {
+	client := newTestUDPTracker(t, NewStore(DefaultConfig()))
+	connectionID := client.connect()
+
+	client.roundTrip(connectionID, udpActionAnnounce, udpAnnounceBody(1, 0, 2))
+	action, body := client.roundTrip(connectionID, udpActionAnnounce, udpAnnounceBody(2, 100, 2))
+	if action != udpActionAnnounce || len(body) != 12+6 {
+		t.Fatalf("unexpected announce response %d %x", action, body)
+	}
+	interval := binary.BigEndian.Uint32(body[0:4])
+	leechers := binary.BigEndian.Uint32(body[4:8])
+	seeders := binary.BigEndian.Uint32(body[8:12])
+	if interval != 1800 || leechers != 1 || seeders != 1 || string(body[12:]) != "\x0a\x00\x00\x01\x1a\xe1" {
+		t.Fatalf("unexpected announce response %x", body)
+	}
+
+	unknown := make([]byte, 20)
+	action, body = client.roundTrip(connectionID, udpActionScrape, append(append([]byte{}, testInfoHash[:]...), unknown...))
+	expected := []uint32{1, 0, 1, 0, 0, 0}
+	if action != udpActionScrape || len(body) != 4*len(expected) {
+		t.Fatalf("unexpected scrape response %d %x", action, body)
+	}
+	for i, value := range expected {
+		if binary.BigEndian.Uint32(body[i*4:]) != value {
+			t.Fatalf("unexpected scrape response %x", body)
+		}
+	}
+}
+
+func TestUDPConnectionID(t *testing.T) {
+	server := NewUDPServer(NewStore(DefaultConfig()))
+	now := time.Now()
+	server.now = func() time.Time { return now }
+	addr := netip.MustParseAddrPort("10.0.0.1:50000")
+
+	request := binary.BigEndian.AppendUint64(nil, udpProtocolID)
+	request = append(request, udpHeader(udpActionConnect, 42)...)
+	connectionID := binary.BigEndian.Uint64(server.handle(request, addr)[8:])
+
+	announce := func(connectionID uint64, from netip.AddrPort) uint32 {
+		request := binary.BigEndian.AppendUint64(nil, connectionID)
+		request = append(request, udpHeader(udpActionAnnounce, 43)...)
+		request = append(request, udpAnnounceBody(1, 0, 0)...)
+		return binary.BigEndian.Uint32(server.handle(request, from))
+	}
+
+	// accepted from any port, for the rest of this window and all of the next
+	now = now.Add(udpConnectionIDWindow)
+	if action := announce(connectionID, netip.MustParseAddrPort("10.0.0.1:50001")); action != udpActionAnnounce {
+		t.Fatalf("expected the connection id to be accepted, got action %d", action)
+	}
+
+	if action := announce(connectionID, netip.MustParseAddrPort("10.0.0.2:50000")); action != udpActionError {
+		t.Fatalf("expected the connection id of another address to be refused")
+	}
+	if action := announce(connectionID+1, addr); action != udpActionError {
+		t.Fatalf("expected a forged connection id to be refused")
+	}
+
+	now = now.Add(udpConnectionIDWindow)
+	if action := announce(connectionID, addr); action != udpActionError {
+		t.Fatalf("expected the connection id to expire")
+	}
+}
+
+func TestUDPErrors(t *testing.T) {
+	config := DefaultConfig()
+	config.Allowlist = map[[20]byte]bool{}
+	client := newTestUDPTracker(t, NewStore(config))
+	connectionID := client.connect()
+
+	action, body := client.roundTrip(connectionID, udpActionAnnounce, udpAnnounceBody(1, 0, 0))
+	if action != udpActionError || string(body) != ErrNotAllowed.Error() {
+		t.Fatalf("expected the torrent to be refused, got %d %q", action, body)
+	}
+
+	action, _ = client.roundTrip(connectionID, udpActionAnnounce, udpAnnounceBody(1, 0, 7))
+	if action != udpActionError {
+		t.Fatalf("expected an unknown event to be refused")
+	}
+}